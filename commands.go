@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/alexflint/go-arg"
+	"github.com/byxorna/flow/config"
+	"github.com/byxorna/flow/types/storage"
+)
+
+// openCommand parses the flags of a subcommand into dest, which embeds the
+// server config at cfg, and connects to storage. If the command should not
+// go on, ok is false and code is what to exit with
+func openCommand(args []string, dest interface{}, cfg *config.Config) (store *storage.KVStore, code int, ok bool) {
+	p, err := arg.NewParser(arg.Config{}, dest)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, 2, false
+	}
+	if err := p.Parse(args); err != nil {
+		if err == arg.ErrHelp {
+			p.WriteHelp(os.Stdout)
+			return nil, 0, false
+		}
+		p.Fail(err.Error())
+	}
+	if err := cfg.ValidateAndSetDefaults(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, 2, false
+	}
+
+	store, err = storage.New(*cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, 2, false
+	}
+	return store, 0, true
+}
@@ -0,0 +1,23 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// AuditConfig configures how long audit events are kept, and where else they are written
+type AuditConfig struct {
+	AuditRetention time.Duration `yaml:"audit-retention" arg:"--audit-retention" help:"How long to keep audit events"`
+	AuditLogFile   string        `yaml:"audit-log-file" arg:"--audit-log-file" help:"Also append audit events to this file, one JSON object per line"`
+}
+
+// ValidateAndSetAuditDefaults validates config and sets defaults if possible
+func (c *AuditConfig) ValidateAndSetAuditDefaults() error {
+	if c.AuditRetention < 0 {
+		return fmt.Errorf("audit-retention cannot be negative")
+	}
+	if c.AuditRetention == 0 {
+		c.AuditRetention = 30 * 24 * time.Hour
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// AuthConfig configures how API callers authenticate, and who the admins are
+type AuthConfig struct {
+	AuthTokensFile  string   `yaml:"auth-tokens-file" arg:"--auth-tokens-file" help:"YAML file of bearer tokens allowed to use the API"`
+	TLSCertFile     string   `yaml:"tls-cert-file" arg:"--tls-cert-file" help:"Serve the API over TLS with this certificate"`
+	TLSKeyFile      string   `yaml:"tls-key-file" arg:"--tls-key-file" help:"Private key for tls-cert-file"`
+	TLSClientCAFile string   `yaml:"tls-client-ca-file" arg:"--tls-client-ca-file" help:"Accept client certificates signed by this CA as authentication"`
+	AuthAdmins      []string `yaml:"auth-admins" arg:"--auth-admins" help:"Principals allowed everything whatever the stored access policy says, i.e. to store the first one"`
+	AuthAdminGroups []string `yaml:"auth-admin-groups" arg:"--auth-admin-groups" help:"Groups whose members are allowed everything whatever the stored access policy says"`
+
+	// Tokens are loaded from AuthTokensFile
+	Tokens []Token `yaml:"-" arg:"-"`
+}
+
+// Token is a bearer token and who it authenticates. Either the token itself,
+// or the hex encoded SHA-256 of it is given
+type Token struct {
+	Principal string   `yaml:"principal"`
+	Groups    []string `yaml:"groups"`
+	Token     string   `yaml:"token"`
+	SHA256    string   `yaml:"sha256"`
+}
+
+// AuthEnabled returns true if callers must authenticate to use the API. It
+// depends on what was asked for rather than on the tokens loaded, so a
+// tokens file that turns out empty locks the API instead of opening it
+func (c *AuthConfig) AuthEnabled() bool {
+	return c.AuthTokensFile != "" || c.TLSClientCAFile != "" || len(c.Tokens) > 0
+}
+
+// TLSEnabled returns true if the API is served over TLS
+func (c *AuthConfig) TLSEnabled() bool {
+	return c.TLSCertFile != ""
+}
+
+// ValidateAndSetAuthDefaults validates config, and loads the tokens file
+func (c *AuthConfig) ValidateAndSetAuthDefaults() error {
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return fmt.Errorf("tls-cert-file and tls-key-file must be given together")
+	}
+	if c.TLSClientCAFile != "" && c.TLSCertFile == "" {
+		return fmt.Errorf("tls-client-ca-file requires serving TLS with tls-cert-file and tls-key-file")
+	}
+	if (len(c.AuthAdmins) > 0 || len(c.AuthAdminGroups) > 0) && c.AuthTokensFile == "" && c.TLSClientCAFile == "" {
+		return fmt.Errorf("auth-admins and auth-admin-groups require authentication with auth-tokens-file or tls-client-ca-file")
+	}
+	if c.AuthTokensFile == "" {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(c.AuthTokensFile)
+	if err != nil {
+		return fmt.Errorf("unable to read auth-tokens-file: %s", err)
+	}
+	if err := yaml.Unmarshal(b, &c.Tokens); err != nil {
+		return fmt.Errorf("unable to parse auth-tokens-file: %s", err)
+	}
+	if len(c.Tokens) == 0 {
+		return fmt.Errorf("auth-tokens-file %s has no tokens", c.AuthTokensFile)
+	}
+	for i, t := range c.Tokens {
+		if t.Principal == "" {
+			return fmt.Errorf("token %d in auth-tokens-file has no principal", i)
+		}
+		if (t.Token == "") == (t.SHA256 == "") {
+			return fmt.Errorf("token for %s must set exactly one of token or sha256", t.Principal)
+		}
+		if t.SHA256 != "" {
+			if h, err := hex.DecodeString(t.SHA256); err != nil || len(h) != 32 {
+				return fmt.Errorf("sha256 for %s is not a hex encoded SHA-256 hash", t.Principal)
+			}
+		}
+	}
+	return nil
+}
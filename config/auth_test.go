@@ -0,0 +1,56 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFile writes content to name in a temporary directory, and returns
+// its path and a func to remove it
+func writeFile(t *testing.T, name, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "flow-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestAuthTokensFile(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{name: "empty", content: "", err: "has no tokens"},
+		{name: "empty list", content: "[]", err: "has no tokens"},
+		{name: "no principal", content: "- token: secret", err: "has no principal"},
+		{name: "token and hash", content: "- principal: a\n  token: secret\n  sha256: " + strings.Repeat("ab", 32), err: "exactly one of"},
+		{name: "bad hash", content: "- principal: a\n  sha256: abc", err: "not a hex encoded SHA-256"},
+		{name: "valid", content: "- principal: a\n  token: secret\n- principal: b\n  sha256: " + strings.Repeat("ab", 32)},
+	} {
+		path, cleanup := writeFile(t, "tokens.yaml", tc.content)
+		c := AuthConfig{AuthTokensFile: path}
+		err := c.ValidateAndSetAuthDefaults()
+		cleanup()
+		if tc.err == "" {
+			if err != nil {
+				t.Errorf("%s: expected the tokens to load, got %s", tc.name, err)
+			} else if len(c.Tokens) != 2 {
+				t.Errorf("%s: expected 2 tokens, got %d", tc.name, len(c.Tokens))
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: expected an error containing %q, got %v", tc.name, tc.err, err)
+		}
+		if !c.AuthEnabled() {
+			t.Errorf("%s: expected auth to be enabled by a tokens file, whatever it holds", tc.name)
+		}
+	}
+}
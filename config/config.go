@@ -2,15 +2,32 @@ package config
 
 // Config is a union of all configuration structs
 type Config struct {
+	StorageConfig
 	EtcdConfig
 	ServerConfig
+	SchedulerConfig
+	ExecutorConfig
+	AuthConfig
+	AuditConfig
 }
 
 // ValidateAndSetDefaults validates all embedded structs and sets defaults where applicable
 func (c *Config) ValidateAndSetDefaults() error {
-	if err := c.ValidateAndSetEtcdDefaults(); err != nil {
+	if err := c.ValidateAndSetStorageDefaults(); err != nil {
 		return err
 	}
-	err := c.ValidateAndSetServerDefaults()
+	if err := c.ValidateAndSetServerDefaults(); err != nil {
+		return err
+	}
+	if err := c.ValidateAndSetSchedulerDefaults(); err != nil {
+		return err
+	}
+	if err := c.ValidateAndSetExecutorDefaults(); err != nil {
+		return err
+	}
+	if err := c.ValidateAndSetAuthDefaults(); err != nil {
+		return err
+	}
+	err := c.ValidateAndSetAuditDefaults()
 	return err
 }
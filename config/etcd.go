@@ -1,7 +1,12 @@
 package config
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
 	"time"
 
 	"github.com/docker/libkv/store"
@@ -9,8 +14,14 @@ import (
 
 // EtcdConfig ...
 type EtcdConfig struct {
-	EtcdEndpoints []string `yaml:"etcd-endpoints" arg:"--etcd-endpoints,required" help:"etcd endpoints for storage"`
-	EtcdPrefix    string   `yaml:"etcd-prefix" arg:"--etcd-prefix" help:"etcd prefix for storage"`
+	EtcdEndpoints         []string      `yaml:"etcd-endpoints" arg:"--etcd-endpoints" help:"etcd endpoints for storage, as host:port"`
+	EtcdPrefix            string        `yaml:"etcd-prefix" arg:"--etcd-prefix" help:"etcd prefix for storage"`
+	EtcdCAFile            string        `yaml:"etcd-ca-file" arg:"--etcd-ca-file" help:"Verify etcd servers with this CA bundle"`
+	EtcdCertFile          string        `yaml:"etcd-cert-file" arg:"--etcd-cert-file" help:"Client certificate to authenticate to etcd with"`
+	EtcdKeyFile           string        `yaml:"etcd-key-file" arg:"--etcd-key-file" help:"Private key for etcd-cert-file"`
+	EtcdUsername          string        `yaml:"etcd-username" arg:"--etcd-username" help:"etcd username"`
+	EtcdPassword          string        `yaml:"etcd-password" arg:"--etcd-password,env:FLOW_ETCD_PASSWORD" help:"etcd password"`
+	EtcdConnectionTimeout time.Duration `yaml:"etcd-connection-timeout" arg:"--etcd-connection-timeout" help:"How long to wait to connect to etcd"`
 }
 
 // ValidateAndSetEtcdDefaults validates config, and sets defaults if possible
@@ -21,15 +32,106 @@ func (c *EtcdConfig) ValidateAndSetEtcdDefaults() error {
 	if c.EtcdPrefix == "" {
 		c.EtcdPrefix = "/"
 	}
-	return nil
+	if c.EtcdConnectionTimeout < 0 {
+		return fmt.Errorf("etcd-connection-timeout cannot be negative")
+	}
+	if c.EtcdConnectionTimeout == 0 {
+		c.EtcdConnectionTimeout = 1 * time.Second
+	}
+	if (c.EtcdCertFile == "") != (c.EtcdKeyFile == "") {
+		return fmt.Errorf("etcd-cert-file and etcd-key-file must be given together")
+	}
+	if c.EtcdPassword != "" && c.EtcdUsername == "" {
+		return fmt.Errorf("etcd-password requires etcd-username")
+	}
+	if c.EtcdUsername != "" && c.EtcdPassword == "" {
+		return fmt.Errorf("etcd-username requires etcd-password")
+	}
+	for i, e := range c.EtcdEndpoints {
+		endpoint, err := c.etcdEndpoint(e)
+		if err != nil {
+			return err
+		}
+		c.EtcdEndpoints[i] = endpoint
+	}
+	// load the certificates now, so a bad file fails at startup rather than on first use
+	_, err := c.etcdTLSConfig()
+	return err
+}
+
+// etcdEndpoint returns an endpoint as host:port, the form both the etcd v2
+// and v3 clients take. Whether TLS is used comes from the etcd-* TLS flags,
+// so a scheme is accepted only if it agrees with them, and is dropped
+func (c *EtcdConfig) etcdEndpoint(e string) (string, error) {
+	endpoint := e
+	if i := strings.Index(endpoint, "://"); i >= 0 {
+		switch scheme := endpoint[:i]; {
+		case scheme == "http" && c.etcdTLSEnabled():
+			return "", fmt.Errorf("etcd endpoint %s is plain http, but etcd TLS is configured", e)
+		case scheme == "https" && !c.etcdTLSEnabled():
+			return "", fmt.Errorf("etcd endpoint %s is https, but etcd TLS is not configured; set etcd-ca-file", e)
+		case scheme != "http" && scheme != "https":
+			return "", fmt.Errorf("etcd endpoint %s has unsupported scheme %s", e, scheme)
+		}
+		endpoint = strings.TrimSuffix(endpoint[i+len("://"):], "/")
+	}
+	if host, port, err := net.SplitHostPort(endpoint); err != nil || host == "" || port == "" {
+		return "", fmt.Errorf("etcd endpoint %s is not host:port", e)
+	}
+	return endpoint, nil
+}
+
+// etcdTLSEnabled returns true if etcd is talked to over TLS
+func (c *EtcdConfig) etcdTLSEnabled() bool {
+	return c.EtcdCAFile != "" || c.EtcdCertFile != ""
+}
+
+// etcdTLSConfig builds the TLS config for talking to etcd, or nil if TLS is not configured
+func (c *EtcdConfig) etcdTLSConfig() (*tls.Config, error) {
+	if !c.etcdTLSEnabled() {
+		return nil, nil
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.EtcdCAFile != "" {
+		pem, err := ioutil.ReadFile(c.EtcdCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read etcd-ca-file: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in etcd-ca-file %s", c.EtcdCAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if c.EtcdCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.EtcdCertFile, c.EtcdKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load etcd-cert-file and etcd-key-file: %s", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
 }
 
 // ToLibKVConfig returns a etcd client Config structure for libkv
-func (c *EtcdConfig) ToLibKVConfig() store.Config {
-	return store.Config{
-		ClientTLS:         nil,
-		TLS:               nil,
-		ConnectionTimeout: 1 * time.Second,
+func (c *EtcdConfig) ToLibKVConfig() (*store.Config, error) {
+	tlsConfig, err := c.etcdTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	cfg := &store.Config{
+		TLS:               tlsConfig,
+		ConnectionTimeout: c.EtcdConnectionTimeout,
+		Username:          c.EtcdUsername,
+		Password:          c.EtcdPassword,
 		PersistConnection: true,
 	}
+	if tlsConfig != nil {
+		cfg.ClientTLS = &store.ClientTLSConfig{
+			CACertFile: c.EtcdCAFile,
+			CertFile:   c.EtcdCertFile,
+			KeyFile:    c.EtcdKeyFile,
+		}
+	}
+	return cfg, nil
 }
@@ -0,0 +1,177 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// selfSigned returns a PEM encoded self-signed certificate and its key
+func selfSigned(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "etcd"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	priv := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return string(cert), string(priv)
+}
+
+func TestEtcdEndpoint(t *testing.T) {
+	for _, tc := range []struct {
+		endpoint string
+		tls      bool
+		want     string
+		err      string
+	}{
+		{endpoint: "127.0.0.1:2379", want: "127.0.0.1:2379"},
+		{endpoint: "etcd:2379", tls: true, want: "etcd:2379"},
+		{endpoint: "http://etcd:2379/", want: "etcd:2379"},
+		{endpoint: "https://etcd:2379", tls: true, want: "etcd:2379"},
+		{endpoint: "https://etcd:2379", err: "TLS is not configured"},
+		{endpoint: "http://etcd:2379", tls: true, err: "plain http"},
+		{endpoint: "unix://etcd:2379", err: "unsupported scheme"},
+		{endpoint: "etcd", err: "not host:port"},
+		{endpoint: ":2379", err: "not host:port"},
+		{endpoint: "http://etcd", err: "not host:port"},
+	} {
+		var c EtcdConfig
+		if tc.tls {
+			c.EtcdCAFile = "ca.pem"
+		}
+		got, err := c.etcdEndpoint(tc.endpoint)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: expected an error containing %q, got %v", tc.endpoint, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", tc.endpoint, err)
+		} else if got != tc.want {
+			t.Errorf("%s: expected %s, got %s", tc.endpoint, tc.want, got)
+		}
+	}
+}
+
+func TestEtcdCredentials(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		username string
+		password string
+		err      string
+	}{
+		{name: "none"},
+		{name: "both", username: "flow", password: "secret"},
+		{name: "password only", password: "secret", err: "requires etcd-username"},
+		{name: "username only", username: "flow", err: "requires etcd-password"},
+	} {
+		c := EtcdConfig{EtcdEndpoints: []string{"etcd:2379"}, EtcdUsername: tc.username, EtcdPassword: tc.password}
+		err := c.ValidateAndSetEtcdDefaults()
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: expected an error containing %q, got %v", tc.name, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", tc.name, err)
+			continue
+		}
+		cfg, err := c.ToLibKVConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Username != tc.username || cfg.Password != tc.password {
+			t.Errorf("%s: expected the credentials to be passed to the client, got %q and %q", tc.name, cfg.Username, cfg.Password)
+		}
+	}
+}
+
+func TestEtcdTLSConfig(t *testing.T) {
+	cert, key := selfSigned(t)
+	certFile, cleanup := writeFile(t, "cert.pem", cert)
+	defer cleanup()
+	keyFile, cleanup := writeFile(t, "key.pem", key)
+	defer cleanup()
+	garbage, cleanup := writeFile(t, "garbage.pem", "not a certificate")
+	defer cleanup()
+
+	for _, tc := range []struct {
+		name     string
+		ca       string
+		cert     string
+		key      string
+		roots    bool
+		identity bool
+		err      string
+	}{
+		{name: "plain"},
+		{name: "ca", ca: certFile, roots: true},
+		{name: "client certificate", cert: certFile, key: keyFile, identity: true},
+		{name: "ca and client certificate", ca: certFile, cert: certFile, key: keyFile, roots: true, identity: true},
+		{name: "certificate without key", cert: certFile, err: "must be given together"},
+		{name: "missing ca", ca: certFile + ".missing", err: "unable to read etcd-ca-file"},
+		{name: "ca without certificates", ca: garbage, err: "no certificates found"},
+		{name: "bad key pair", cert: certFile, key: garbage, err: "unable to load etcd-cert-file"},
+	} {
+		c := EtcdConfig{EtcdEndpoints: []string{"etcd:2379"}, EtcdCAFile: tc.ca, EtcdCertFile: tc.cert, EtcdKeyFile: tc.key}
+		err := c.ValidateAndSetEtcdDefaults()
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: expected an error containing %q, got %v", tc.name, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", tc.name, err)
+			continue
+		}
+		cfg, err := c.ToLibKVConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !tc.roots && !tc.identity {
+			if cfg.TLS != nil || cfg.ClientTLS != nil {
+				t.Errorf("%s: expected no TLS, got %+v", tc.name, cfg.TLS)
+			}
+			continue
+		}
+		if cfg.TLS == nil || cfg.ClientTLS == nil {
+			t.Errorf("%s: expected TLS to be configured", tc.name)
+			continue
+		}
+		if (cfg.TLS.RootCAs != nil) != tc.roots {
+			t.Errorf("%s: expected root CAs %t, got %t", tc.name, tc.roots, cfg.TLS.RootCAs != nil)
+		}
+		if (len(cfg.TLS.Certificates) == 1) != tc.identity {
+			t.Errorf("%s: expected a client certificate %t, got %d certificates", tc.name, tc.identity, len(cfg.TLS.Certificates))
+		}
+		if cfg.ClientTLS.CACertFile != tc.ca || cfg.ClientTLS.CertFile != tc.cert || cfg.ClientTLS.KeyFile != tc.key {
+			t.Errorf("%s: expected the files to be passed to the client, got %+v", tc.name, cfg.ClientTLS)
+		}
+	}
+}
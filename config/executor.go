@@ -0,0 +1,31 @@
+package config
+
+import (
+	"fmt"
+	"os"
+)
+
+// ExecutorConfig configures where the ssh executor finds its keys and known hosts
+type ExecutorConfig struct {
+	SSHKeyDir         string `yaml:"ssh-key-dir" arg:"--ssh-key-dir" help:"Directory of private keys ssh jobs may authenticate with, named by their key parameter"`
+	SSHKnownHostsFile string `yaml:"ssh-known-hosts-file" arg:"--ssh-known-hosts-file" help:"known_hosts file used to verify hosts of ssh jobs that do not set host_key"`
+}
+
+// ValidateAndSetExecutorDefaults validates config
+func (c *ExecutorConfig) ValidateAndSetExecutorDefaults() error {
+	if c.SSHKeyDir != "" {
+		info, err := os.Stat(c.SSHKeyDir)
+		if err != nil {
+			return fmt.Errorf("unable to read ssh-key-dir: %s", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("ssh-key-dir %s is not a directory", c.SSHKeyDir)
+		}
+	}
+	if c.SSHKnownHostsFile != "" {
+		if _, err := os.Stat(c.SSHKnownHostsFile); err != nil {
+			return fmt.Errorf("unable to read ssh-known-hosts-file: %s", err)
+		}
+	}
+	return nil
+}
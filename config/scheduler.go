@@ -0,0 +1,49 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// SchedulerConfig configures leader election, reconciling jobs, and pruning executions
+type SchedulerConfig struct {
+	NodeName               string        `yaml:"node-name" arg:"--node-name" help:"Name this node campaigns for scheduler leadership under. Defaults to the hostname"`
+	ReconcileInterval      time.Duration `yaml:"reconcile-interval" arg:"--reconcile-interval" help:"How often to fully resync executors with stored jobs, on top of watching for changes"`
+	ExecutionKeepGroups    int           `yaml:"execution-keep-groups" arg:"--execution-keep-groups" help:"How many execution groups to keep per job, unless its job or namespace sets a retention"`
+	ExecutionMaxAgeDays    int           `yaml:"execution-max-age-days" arg:"--execution-max-age-days" help:"How many days to keep execution groups, unless its job or namespace sets a retention. 0 keeps them regardless of age"`
+	ExecutionPruneInterval time.Duration `yaml:"execution-prune-interval" arg:"--execution-prune-interval" help:"How often to delete executions past their retention"`
+}
+
+// ValidateAndSetSchedulerDefaults validates config and sets defaults if possible
+func (c *SchedulerConfig) ValidateAndSetSchedulerDefaults() error {
+	if c.NodeName == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("unable to default node-name to the hostname: %s", err)
+		}
+		c.NodeName = hostname
+	}
+	if c.ReconcileInterval < 0 {
+		return fmt.Errorf("reconcile-interval cannot be negative")
+	}
+	if c.ReconcileInterval == 0 {
+		c.ReconcileInterval = 10 * time.Minute
+	}
+	if c.ExecutionKeepGroups < 0 {
+		return fmt.Errorf("execution-keep-groups cannot be negative")
+	}
+	if c.ExecutionKeepGroups == 0 {
+		c.ExecutionKeepGroups = 100
+	}
+	if c.ExecutionMaxAgeDays < 0 {
+		return fmt.Errorf("execution-max-age-days cannot be negative")
+	}
+	if c.ExecutionPruneInterval < 0 {
+		return fmt.Errorf("execution-prune-interval cannot be negative")
+	}
+	if c.ExecutionPruneInterval == 0 {
+		c.ExecutionPruneInterval = 10 * time.Minute
+	}
+	return nil
+}
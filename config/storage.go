@@ -0,0 +1,53 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/docker/libkv/store"
+)
+
+// StorageConfig selects the storage backend. The etcd backends are configured
+// with EtcdConfig, the others here. Which settings a backend needs is checked
+// by storage.New, which knows the backends
+type StorageConfig struct {
+	StorageBackend           string        `yaml:"storage-backend" arg:"--storage-backend" help:"Storage backend: etcd, etcdv3, consul, zk, boltdb or memory"`
+	StorageEndpoints         []string      `yaml:"storage-endpoints" arg:"--storage-endpoints" help:"consul or zookeeper endpoints for storage"`
+	StoragePrefix            string        `yaml:"storage-prefix" arg:"--storage-prefix" help:"consul, zookeeper or boltdb prefix for storage"`
+	StorageConnectionTimeout time.Duration `yaml:"storage-connection-timeout" arg:"--storage-connection-timeout" help:"How long to wait to connect to consul or zookeeper, or to lock the boltdb file"`
+	BoltDBPath               string        `yaml:"boltdb-path" arg:"--boltdb-path" help:"BoltDB file for storage, for single node setups"`
+	BoltDBBucket             string        `yaml:"boltdb-bucket" arg:"--boltdb-bucket" help:"BoltDB bucket to store data in"`
+}
+
+// ValidateAndSetStorageDefaults validates config, and sets defaults if possible
+func (c *StorageConfig) ValidateAndSetStorageDefaults() error {
+	if c.StorageBackend == "" {
+		c.StorageBackend = string(store.ETCD)
+	}
+	if c.StoragePrefix == "" {
+		c.StoragePrefix = "/"
+	}
+	if c.StorageConnectionTimeout < 0 {
+		return fmt.Errorf("storage-connection-timeout cannot be negative")
+	}
+	if c.StorageConnectionTimeout == 0 {
+		c.StorageConnectionTimeout = 5 * time.Second
+	}
+	if c.BoltDBBucket == "" {
+		c.BoltDBBucket = "flow"
+	}
+	return nil
+}
+
+// StorageLibKVConfig returns a libkv client Config structure for the consul,
+// zookeeper or boltdb backends
+func (c *StorageConfig) StorageLibKVConfig() *store.Config {
+	cfg := &store.Config{
+		ConnectionTimeout: c.StorageConnectionTimeout,
+		PersistConnection: true,
+	}
+	if store.Backend(c.StorageBackend) == store.BOLTDB {
+		cfg.Bucket = c.BoltDBBucket
+	}
+	return cfg
+}
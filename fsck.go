@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/byxorna/flow/config"
+	"github.com/byxorna/flow/types/storage"
+)
+
+// fsckArgs are the flags of flow fsck
+type fsckArgs struct {
+	config.Config
+	Repair bool `arg:"--repair" help:"Quarantine or delete what is found, instead of only reporting it"`
+}
+
+// fsck runs flow fsck, which checks storage for corrupt, invalid, orphaned
+// and dangling data. It exits 0 if nothing is left to fix, 1 if something
+// is, and 2 if the check itself failed
+func fsck(args []string) int {
+	var cfg fsckArgs
+	store, code, ok := openCommand(args, &cfg, &cfg.Config)
+	if !ok {
+		return code
+	}
+	report, err := store.Fsck(cfg.Repair)
+	if report != nil {
+		printFsckReport(report)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if report.Unrepaired() > 0 {
+		return 1
+	}
+	return 0
+}
+
+func printFsckReport(r *storage.FsckReport) {
+	fmt.Printf("scanned %d values, found %d problems\n", r.Scanned, len(r.Findings))
+	for _, f := range r.Findings {
+		fmt.Printf("  %s %s: %s\n", f.Kind, f.Key, f.Problem)
+		switch {
+		case f.Repaired:
+			fmt.Printf("    repaired: %s\n", f.Repair)
+		case f.Error != "":
+			fmt.Printf("    repair failed: %s\n", f.Error)
+		case f.Repair == "":
+			fmt.Printf("    needs fixing by hand\n")
+		default:
+			fmt.Printf("    --repair would %s\n", f.Repair)
+		}
+	}
+}
@@ -7,8 +7,11 @@ import (
 
 	"github.com/alexflint/go-arg"
 	"github.com/byxorna/flow/config"
+	"github.com/byxorna/flow/scheduler"
 	"github.com/byxorna/flow/server"
+	"github.com/byxorna/flow/types/executor"
 	"github.com/byxorna/flow/types/executor/shell"
+	"github.com/byxorna/flow/types/executor/ssh"
 	"github.com/byxorna/flow/types/storage"
 	"github.com/byxorna/flow/version"
 	"github.com/sirupsen/logrus"
@@ -38,6 +41,16 @@ func main() {
 		flag.PrintDefaults()
 	}
 
+	// subcommands take the same flags as the server
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			os.Exit(migrate(os.Args[2:]))
+		case "fsck":
+			os.Exit(fsck(os.Args[2:]))
+		}
+	}
+
 	var cfg config.Config
 	arg.MustParse(&cfg)
 	err := cfg.ValidateAndSetDefaults()
@@ -50,23 +63,40 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	// bring stored data up to the schema this version expects
+	if _, err := store.Migrate(false); err != nil {
+		log.Fatal(err)
+	}
 
 	// setup any executors
 	shellExecutor, err := shell.New(store)
 	if err != nil {
 		log.Fatal(err)
 	}
+	sshExecutor, err := ssh.New(store)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sshExecutor.Settings.KeyDir = cfg.SSHKeyDir
+	sshExecutor.Settings.KnownHostsFile = cfg.SSHKnownHostsFile
 
 	s, err := server.New(cfg, store)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// register executors with server
-	s.RegisterShellExecutor(shellExecutor)
+	sched := scheduler.New(cfg, store)
+
+	// register executors with server and scheduler
+	for _, e := range []executor.Executor{shellExecutor, sshExecutor} {
+		s.RegisterExecutor(e)
+		sched.RegisterExecutor(e)
+		e.Start()
+	}
 
-	//TODO: launch all executors via .Start()
-	shellExecutor.Start()
+	// hand stored jobs to executors and keep them in sync, while this node
+	// is the leader
+	sched.Start()
 
 	// now start handling traffic
 	log.Info("server starting up")
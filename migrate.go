@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/byxorna/flow/config"
+	"github.com/byxorna/flow/types/storage"
+)
+
+// migrateArgs are the flags of flow migrate
+type migrateArgs struct {
+	config.Config
+	DryRun bool `arg:"--dry-run" help:"Report what would change without writing anything"`
+}
+
+// migrate runs flow migrate, which brings storage up to the current schema
+// version and reports what changed. It returns the exit code
+func migrate(args []string) int {
+	var cfg migrateArgs
+	store, code, ok := openCommand(args, &cfg, &cfg.Config)
+	if !ok {
+		return code
+	}
+	report, err := store.Migrate(cfg.DryRun)
+	if report != nil {
+		printMigrationReport(report)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func printMigrationReport(r *storage.MigrationReport) {
+	verb := "migrated"
+	if r.DryRun {
+		verb = "would migrate"
+	}
+	if len(r.Applied) == 0 {
+		fmt.Printf("storage is at schema version %d, nothing to do\n", r.From)
+		return
+	}
+	fmt.Printf("%s storage from schema version %d to %d\n", verb, r.From, r.To)
+	for _, m := range r.Applied {
+		fmt.Printf("  %d: %s (%d changes)\n", m.Version, m.Description, len(m.Changes))
+		for _, c := range m.Changes {
+			fmt.Printf("    %s\n", c)
+		}
+	}
+}
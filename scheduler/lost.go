@@ -0,0 +1,35 @@
+package scheduler
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// how often to look for instances whose executor died
+const lostSweepInterval = 1 * time.Minute
+
+// FailLost records every instance that stopped sending heartbeats as
+// failed, so a job whose last run was left behind by a crash fires again
+func (s *Scheduler) FailLost(now time.Time) error {
+	lost, err := s.store.FailLostInstances(now)
+	for _, i := range lost {
+		log.WithFields(logrus.Fields{"job": i.Job.String(), "instance": i.ID}).Warn("instance lost its executor, marked it failed")
+	}
+	return err
+}
+
+func (s *Scheduler) lostLoop(stop <-chan struct{}) {
+	ticker := time.NewTicker(lostSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			if err := s.FailLost(now); err != nil {
+				log.WithError(err).Error("unable to fail lost instances")
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package scheduler
+
+import (
+	"time"
+
+	"github.com/byxorna/flow/types/job"
+	"github.com/docker/libkv/store"
+	"github.com/sirupsen/logrus"
+)
+
+// Prune deletes the executions of every job that are past their retention.
+// A job's own retention wins, then its namespace's, then the server default
+func (s *Scheduler) Prune(now time.Time) error {
+	jobs, err := s.store.GetJobs("")
+	if err != nil {
+		return err
+	}
+
+	namespaces := map[string]job.Retention{}
+	total := 0
+	for _, j := range jobs {
+		fallback, ok := namespaces[j.ID.Namespace]
+		if !ok {
+			fallback = s.retention
+			ns, err := s.store.GetNamespace(j.ID.Namespace)
+			if err != nil && err != store.ErrKeyNotFound {
+				return err
+			}
+			if ns != nil {
+				fallback = ns.Retention.Or(s.retention)
+			}
+			namespaces[j.ID.Namespace] = fallback
+		}
+
+		deleted, err := s.store.PruneExecutions(j.ID, j.Retention.Or(fallback), now)
+		if err != nil {
+			log.WithError(err).WithFields(logrus.Fields{"job": j.ID.String()}).Error("unable to prune executions")
+			continue
+		}
+		total += deleted
+	}
+	log.WithFields(logrus.Fields{"jobs": len(jobs), "deleted": total}).Debug("pruned executions")
+	return nil
+}
+
+func (s *Scheduler) pruneLoop(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.pruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			if err := s.Prune(now); err != nil {
+				log.WithError(err).Error("unable to prune executions")
+			}
+		}
+	}
+}
@@ -0,0 +1,384 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/byxorna/flow/config"
+	"github.com/byxorna/flow/types"
+	"github.com/byxorna/flow/types/executor"
+	"github.com/byxorna/flow/types/job"
+	"github.com/byxorna/flow/types/storage"
+	"github.com/docker/libkv/store"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"module": "scheduler"})
+	// runtimeFields are set by storage, and are not considered a change
+	// to a job's definition
+	runtimeFields = []string{"resource_version"}
+)
+
+const (
+	// how long to wait before reestablishing a failed watch
+	watchRetryInterval = 5 * time.Second
+	// how often to reconcile when storage cannot be watched
+	pollInterval = 1 * time.Minute
+)
+
+// Scheduler keeps the queues of all executors in sync with the jobs in storage
+type Scheduler struct {
+	sync.Mutex
+	store     storage.Store
+	executors map[types.Executor]executor.Executor
+	// known is the last definition of each job handed to an executor, by job ID
+	known    map[string]*registration
+	interval time.Duration
+	stop     chan struct{}
+	// node is the name this node campaigns for leadership under
+	node string
+	// retention is the default for jobs and namespaces that set none
+	retention     job.Retention
+	pruneInterval time.Duration
+}
+
+type registration struct {
+	spec        *job.Spec
+	fingerprint string
+}
+
+// New returns a new Scheduler
+func New(c config.Config, store storage.Store) *Scheduler {
+	return &Scheduler{
+		store:     store,
+		executors: map[types.Executor]executor.Executor{},
+		known:     map[string]*registration{},
+		interval:  c.ReconcileInterval,
+		node:      c.NodeName,
+		retention: job.Retention{
+			KeepGroups: c.ExecutionKeepGroups,
+			MaxAgeDays: c.ExecutionMaxAgeDays,
+		},
+		pruneInterval: c.ExecutionPruneInterval,
+	}
+}
+
+// RegisterExecutor adds an executor that jobs can be dispatched to
+func (s *Scheduler) RegisterExecutor(e executor.Executor) {
+	s.Lock()
+	defer s.Unlock()
+	s.executors[e.Type()] = e
+}
+
+// Start campaigns for leadership in the background, and schedules jobs for
+// as long as this node is the leader: it loads all jobs into their
+// executors, then keeps them in sync by applying each change seen watching
+// storage, with a slow periodic full reconcile as a safety net. The leader
+// also prunes executions past their retention, and fails instances left
+// running by executors that died. A leader that loses leadership takes its
+// jobs back from the executors and campaigns again. On backends that cannot
+// elect a leader, every node schedules
+func (s *Scheduler) Start() {
+	s.Lock()
+	defer s.Unlock()
+	if s.stop != nil {
+		return
+	}
+	s.stop = make(chan struct{})
+	go s.campaignLoop(s.stop)
+}
+
+// Stop stops scheduling, and gives up leadership
+func (s *Scheduler) Stop() {
+	s.Lock()
+	defer s.Unlock()
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+}
+
+// campaignLoop campaigns for leadership until stop is closed, and schedules
+// for as long as each term lasts
+func (s *Scheduler) campaignLoop(stop chan struct{}) {
+	for {
+		lost, resign, err := s.store.Campaign(s.node, stop)
+		if err == store.ErrCallNotSupported {
+			log.Info("storage backend cannot elect a leader, scheduling without one")
+			s.lead(stop, nil)
+			return
+		}
+		if err != nil {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			log.WithError(err).Error("unable to campaign for leadership")
+			select {
+			case <-stop:
+				return
+			case <-time.After(watchRetryInterval):
+			}
+			continue
+		}
+
+		log.WithFields(logrus.Fields{"node": s.node}).Info("elected leader, scheduling jobs")
+		s.lead(stop, lost)
+		if err := resign(); err != nil {
+			log.WithError(err).Error("unable to resign leadership")
+		}
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		log.WithFields(logrus.Fields{"node": s.node}).Warn("lost leadership, no longer scheduling jobs")
+		s.abdicate()
+	}
+}
+
+// lead schedules jobs until stop or lost is closed
+func (s *Scheduler) lead(stop <-chan struct{}, lost <-chan struct{}) {
+	term := make(chan struct{})
+	go func() {
+		select {
+		case <-stop:
+		case <-lost:
+		}
+		close(term)
+	}()
+
+	for {
+		err := s.Reconcile()
+		if err == nil {
+			break
+		}
+		log.WithError(err).Error("unable to load jobs")
+		select {
+		case <-term:
+			return
+		case <-time.After(watchRetryInterval):
+		}
+	}
+	if err := s.FailLost(time.Now()); err != nil {
+		log.WithError(err).Error("unable to fail lost instances")
+	}
+
+	var wg sync.WaitGroup
+	for _, loop := range []func(<-chan struct{}){s.watchLoop, s.reconcileLoop, s.pruneLoop, s.lostLoop} {
+		wg.Add(1)
+		go func(loop func(<-chan struct{})) {
+			defer wg.Done()
+			loop(term)
+		}(loop)
+	}
+	wg.Wait()
+}
+
+// abdicate takes every job back from the executors, once another node may
+// be scheduling them
+func (s *Scheduler) abdicate() {
+	s.Lock()
+	defer s.Unlock()
+	for k, old := range s.known {
+		s.deregister(old.spec)
+		delete(s.known, k)
+	}
+}
+
+// Reconcile compares all stored jobs against what executors were given, and
+// registers created or updated jobs and deregisters deleted ones. Jobs are
+// compared with their namespace defaults merged in, so this is also how a
+// change to a namespace's defaults reaches its jobs
+func (s *Scheduler) Reconcile() error {
+	// read under the lock, so a change applied from the watch meanwhile is
+	// not overwritten by an older snapshot
+	s.Lock()
+	defer s.Unlock()
+	jobs, err := s.store.GetJobs("")
+	if err != nil {
+		return err
+	}
+	if err := s.store.ApplyNamespaceDefaults(jobs...); err != nil {
+		return err
+	}
+
+	seen := map[string]bool{}
+	for _, j := range jobs {
+		seen[j.ID.String()] = true
+		s.update(j)
+	}
+	for k, old := range s.known {
+		if !seen[k] {
+			s.deregister(old.spec)
+			delete(s.known, k)
+		}
+	}
+	return nil
+}
+
+// apply hands a single changed job to its executor, or takes a deleted one away
+func (s *Scheduler) apply(ev *storage.JobEvent) {
+	if ev.Job != nil {
+		if err := s.store.ApplyNamespaceDefaults(ev.Job); err != nil {
+			// the next reconcile picks it up
+			log.WithError(err).WithFields(logrus.Fields{"job": ev.ID.String()}).Error("unable to read namespace defaults of job")
+			return
+		}
+	}
+	s.Lock()
+	defer s.Unlock()
+	if ev.Job != nil {
+		s.update(ev.Job)
+		return
+	}
+	k := ev.ID.String()
+	if old, ok := s.known[k]; ok {
+		s.deregister(old.spec)
+		delete(s.known, k)
+	}
+}
+
+// update registers a job if it is new or changed since it was last
+// registered. Must be called with the lock held
+func (s *Scheduler) update(j *job.Spec) {
+	k := j.ID.String()
+	fp, err := fingerprint(j)
+	if err != nil {
+		log.WithError(err).WithFields(logrus.Fields{"job": k}).Error("unable to fingerprint job")
+		return
+	}
+	old, ok := s.known[k]
+	if ok && old.fingerprint == fp {
+		return
+	}
+	if ok && old.spec.Executor != j.Executor {
+		s.deregister(old.spec)
+		delete(s.known, k)
+	}
+	// a job that failed to register is retried on the next change or
+	// reconcile, as its fingerprint still differs
+	if s.register(j) {
+		s.known[k] = &registration{spec: j, fingerprint: fp}
+	}
+}
+
+// register hands a job to its executor, and reports whether it took it.
+// Must be called with the lock held
+func (s *Scheduler) register(j *job.Spec) bool {
+	logger := log.WithFields(logrus.Fields{"job": j.ID.String(), "executor": j.Executor})
+	e, ok := s.executors[j.Executor]
+	if !ok {
+		logger.Warn("no executor registered for job")
+		return false
+	}
+	if err := e.Register(j); err != nil {
+		logger.WithError(err).Error("unable to register job")
+		return false
+	}
+	logger.Info("registered job")
+	return true
+}
+
+// deregister removes a job from its executor. Must be called with the lock held
+func (s *Scheduler) deregister(j *job.Spec) {
+	logger := log.WithFields(logrus.Fields{"job": j.ID.String(), "executor": j.Executor})
+	e, ok := s.executors[j.Executor]
+	if !ok {
+		return
+	}
+	if err := e.Deregister(j); err != nil {
+		logger.WithError(err).Debug("unable to deregister job")
+		return
+	}
+	logger.Info("deregistered job")
+}
+
+func (s *Scheduler) watchLoop(stop <-chan struct{}) {
+	for {
+		changes, err := s.store.WatchJobs(stop)
+		if err == store.ErrCallNotSupported {
+			log.Info("storage backend cannot watch jobs, polling for changes instead")
+			s.pollLoop(stop)
+			return
+		}
+		if err != nil {
+			log.WithError(err).Error("unable to watch jobs")
+		} else {
+			for ev := range changes {
+				if !ev.Resync {
+					s.apply(ev)
+					continue
+				}
+				if err := s.Reconcile(); err != nil {
+					log.WithError(err).Error("unable to reconcile jobs after change")
+				}
+			}
+			log.Warn("job watch ended")
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(watchRetryInterval):
+		}
+		// we may have missed changes while the watch was down
+		if err := s.Reconcile(); err != nil {
+			log.WithError(err).Error("unable to resync jobs")
+		}
+	}
+}
+
+// pollLoop picks up job changes by reconciling often, for backends that
+// cannot watch
+func (s *Scheduler) pollLoop(stop <-chan struct{}) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := s.Reconcile(); err != nil {
+				log.WithError(err).Error("unable to reconcile jobs")
+			}
+		}
+	}
+}
+
+// reconcileLoop fully resyncs executors with storage every interval, in
+// case a watch missed something
+func (s *Scheduler) reconcileLoop(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := s.Reconcile(); err != nil {
+				log.WithError(err).Error("unable to reconcile jobs")
+			}
+		}
+	}
+}
+
+// fingerprint identifies a job definition, ignoring fields set by storage
+func fingerprint(j *job.Spec) (string, error) {
+	b, err := json.Marshal(j)
+	if err != nil {
+		return "", err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return "", err
+	}
+	for _, f := range runtimeFields {
+		delete(m, f)
+	}
+	b, err = json.Marshal(m)
+	return string(b), err
+}
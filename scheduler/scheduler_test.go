@@ -0,0 +1,280 @@
+package scheduler
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/byxorna/flow/config"
+	"github.com/byxorna/flow/types"
+	"github.com/byxorna/flow/types/executor"
+	"github.com/byxorna/flow/types/job"
+	"github.com/byxorna/flow/types/namespace"
+	"github.com/byxorna/flow/types/storage"
+)
+
+// recorder is an executor that records what it was handed
+type recorder struct {
+	sync.Mutex
+	registered   []string
+	deregistered []string
+	specs        map[string]*job.Spec
+	// err fails every registration while set
+	err error
+}
+
+func (r *recorder) Register(j *job.Spec) error {
+	r.Lock()
+	defer r.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.registered = append(r.registered, j.ID.String())
+	if r.specs == nil {
+		r.specs = map[string]*job.Spec{}
+	}
+	r.specs[j.ID.String()] = j
+	return nil
+}
+
+func (r *recorder) Deregister(j *job.Spec) error {
+	r.Lock()
+	defer r.Unlock()
+	r.deregistered = append(r.deregistered, j.ID.String())
+	return nil
+}
+
+func (r *recorder) String() string                  { return string(types.ShellExecutor) }
+func (r *recorder) Type() types.Executor            { return types.ShellExecutor }
+func (r *recorder) Parameters() executor.Parameters { return nil }
+func (r *recorder) Start()                          {}
+func (r *recorder) Stop()                           {}
+
+// counts returns how many times jobs were registered and deregistered
+func (r *recorder) counts() (int, int) {
+	r.Lock()
+	defer r.Unlock()
+	return len(r.registered), len(r.deregistered)
+}
+
+// last returns the last job registered and deregistered
+func (r *recorder) last() (string, string) {
+	r.Lock()
+	defer r.Unlock()
+	return lastOf(r.registered), lastOf(r.deregistered)
+}
+
+// spec returns the job last registered under id
+func (r *recorder) spec(id string) *job.Spec {
+	r.Lock()
+	defer r.Unlock()
+	return r.specs[id]
+}
+
+func lastOf(ids []string) string {
+	if len(ids) == 0 {
+		return ""
+	}
+	return ids[len(ids)-1]
+}
+
+func newJob(name, command string) *job.Spec {
+	return &job.Spec{
+		ID:                 job.ID{Namespace: "default", Name: name},
+		Owner:              "test",
+		ScheduleString:     "@every 1h",
+		Executor:           types.ShellExecutor,
+		ExecutorParameters: map[string]string{"command": command},
+	}
+}
+
+// waitFor polls until cond is true, or fails the test
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatchAppliesSingleJobChanges(t *testing.T) {
+	store := storage.NewMemory()
+	for _, name := range []string{"a", "b", "c"} {
+		if err := store.SetJob(newJob(name, "true")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var cfg config.Config
+	if err := cfg.ValidateAndSetSchedulerDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	s := New(cfg, store)
+	r := &recorder{}
+	s.RegisterExecutor(r)
+	s.Start()
+	defer s.Stop()
+	waitFor(t, "3 jobs to be registered at start", func() bool { reg, _ := r.counts(); return reg == 3 })
+	// let the watch start, and resync against the jobs already registered
+	time.Sleep(100 * time.Millisecond)
+
+	if err := store.SetJob(newJob("b", "false")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "b to be registered again", func() bool { reg, _ := r.counts(); return reg == 4 })
+	if reg, _ := r.last(); reg != "default/b" {
+		t.Errorf("expected only default/b to be registered again, got %s", reg)
+	}
+
+	if err := store.SetJob(newJob("d", "true")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "d to be registered", func() bool { reg, _ := r.counts(); return reg == 5 })
+	if reg, _ := r.last(); reg != "default/d" {
+		t.Errorf("expected default/d to be registered, got %s", reg)
+	}
+
+	if _, err := store.DeleteJob(job.ID{Namespace: "default", Name: "a"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "a to be deregistered", func() bool { _, dereg := r.counts(); return dereg == 1 })
+	if _, dereg := r.last(); dereg != "default/a" {
+		t.Errorf("expected default/a to be deregistered, got %s", dereg)
+	}
+	if reg, _ := r.counts(); reg != 5 {
+		t.Errorf("expected no other jobs to be registered again, got %d registrations", reg)
+	}
+}
+
+func TestReconcileAppliesNamespaceDefaults(t *testing.T) {
+	store := storage.NewMemory()
+	n := &namespace.Namespace{Name: "default", Owner: "test", DefaultEnvVars: map[string]string{"REGION": "east", "TIER": "batch"}}
+	if err := store.SetNamespace(n, 0); err != nil {
+		t.Fatal(err)
+	}
+	j := newJob("a", "true")
+	j.EnvVars = map[string]string{"TIER": "web"}
+	if err := store.SetJob(j); err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg config.Config
+	if err := cfg.ValidateAndSetSchedulerDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	s := New(cfg, store)
+	r := &recorder{}
+	s.RegisterExecutor(r)
+	if err := s.Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+	if env := r.spec("default/a").EnvVars; env["REGION"] != "east" || env["TIER"] != "web" {
+		t.Errorf("expected the namespace default and the job's own value, got %v", env)
+	}
+
+	// changing the defaults reschedules the job without touching what is stored
+	n.DefaultEnvVars["REGION"] = "west"
+	if err := store.SetNamespace(n, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+	if env := r.spec("default/a").EnvVars; env["REGION"] != "west" {
+		t.Errorf("expected the new default to be registered, got %v", env)
+	}
+	stored, err := store.GetJob(j.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.EnvVars) != 1 {
+		t.Errorf("expected defaults not to be stored with the job, got %v", stored.EnvVars)
+	}
+}
+
+func TestReconcileRetriesFailedRegistration(t *testing.T) {
+	store := storage.NewMemory()
+	if err := store.SetJob(newJob("a", "true")); err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg config.Config
+	if err := cfg.ValidateAndSetSchedulerDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	s := New(cfg, store)
+	r := &recorder{err: errors.New("queue is full")}
+	s.RegisterExecutor(r)
+	if err := s.Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+	if reg, _ := r.counts(); reg != 0 {
+		t.Fatalf("expected the registration to fail, got %d registrations", reg)
+	}
+
+	// the job is unchanged, but was never taken, so the next reconcile retries it
+	r.Lock()
+	r.err = nil
+	r.Unlock()
+	if err := s.Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+	if reg, _ := r.last(); reg != "default/a" {
+		t.Errorf("expected default/a to be registered on retry, got %q", reg)
+	}
+}
+
+func TestLeaderElection(t *testing.T) {
+	store := storage.NewMemory()
+	if err := store.SetJob(newJob("a", "true")); err != nil {
+		t.Fatal(err)
+	}
+
+	start := func(node string) (*Scheduler, *recorder) {
+		var cfg config.Config
+		cfg.NodeName = node
+		if err := cfg.ValidateAndSetSchedulerDefaults(); err != nil {
+			t.Fatal(err)
+		}
+		s := New(cfg, store)
+		r := &recorder{}
+		s.RegisterExecutor(r)
+		s.Start()
+		return s, r
+	}
+	one, r1 := start("one")
+	defer one.Stop()
+	waitFor(t, "one to be elected", func() bool { reg, _ := r1.counts(); return reg == 1 })
+	if leader := string(store.GetLeader()); leader != "one" {
+		t.Errorf("expected one to be recorded as leader, got %q", leader)
+	}
+	two, r2 := start("two")
+	defer two.Stop()
+	time.Sleep(100 * time.Millisecond)
+	if reg, _ := r2.counts(); reg != 0 {
+		t.Fatalf("expected a follower not to schedule jobs, got %d registrations", reg)
+	}
+
+	// losing leadership hands the jobs back, and whoever is elected next schedules
+	if err := store.Client.Delete(store.LeaderKey()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "one to give up its job", func() bool { _, dereg := r1.counts(); return dereg == 1 })
+	waitFor(t, "a new leader to schedule", func() bool {
+		reg1, _ := r1.counts()
+		reg2, _ := r2.counts()
+		return reg1+reg2 == 2
+	})
+
+	// stopping the leader resigns, and the follower takes over
+	leader, follower := one, r2
+	if string(store.GetLeader()) == "two" {
+		leader, follower = two, r1
+	}
+	before, _ := follower.counts()
+	leader.Stop()
+	waitFor(t, "the follower to take over", func() bool { reg, _ := follower.counts(); return reg == before+1 })
+}
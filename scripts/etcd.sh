@@ -12,7 +12,8 @@ docker run \
   quay.io/coreos/etcd:$version \
   etcd \
     --listen-client-urls=http://0.0.0.0:2379 \
-    --advertise-client-urls=http://0.0.0.0:2379
+    --advertise-client-urls=http://0.0.0.0:2379 \
+    --enable-v2=true
 
 
 docker ps
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/byxorna/flow/types/audit"
+	"github.com/byxorna/flow/types/job"
+	"github.com/byxorna/flow/types/rbac"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// how often audit events past their retention are deleted
+	auditPruneInterval = 1 * time.Hour
+	defaultAuditLimit  = 100
+)
+
+// auditMirror appends audit events to a file as JSON lines
+type auditMirror struct {
+	sync.Mutex
+	f *os.File
+}
+
+func newAuditMirror(path string) (*auditMirror, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open audit-log-file: %s", err)
+	}
+	return &auditMirror{f: f}, nil
+}
+
+func (m *auditMirror) write(e *audit.Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	m.Lock()
+	defer m.Unlock()
+	_, err = m.f.Write(append(b, '\n'))
+	return err
+}
+
+// newAuditEvent returns an event for an action taken by the caller of a request
+func newAuditEvent(r *http.Request, action audit.Action, resource audit.Resource) *audit.Event {
+	return audit.NewEvent(requester(r), r.RemoteAddr, action, resource)
+}
+
+// recordAudit stores an audit event, and mirrors it to the audit log file.
+// The change it records has already been made, so failures are only logged
+func (s *svr) recordAudit(e *audit.Event) {
+	logger := log.WithFields(logrus.Fields{"action": e.Action, "resource": e.Resource, "principal": e.Principal})
+	if err := s.store.AppendAuditEvent(e); err != nil {
+		logger.WithError(err).Error("unable to store audit event")
+	}
+	if s.auditMirror != nil {
+		if err := s.auditMirror.write(e); err != nil {
+			logger.WithError(err).Error("unable to write audit event to file")
+		}
+	}
+}
+
+// auditJob records a change to a job. before is nil for a created job, and after for a deleted one
+func (s *svr) auditJob(r *http.Request, action audit.Action, before *job.Spec, after *job.Spec) {
+	e := newAuditEvent(r, action, audit.JobResource)
+	j := after
+	if j == nil {
+		j = before
+	}
+	id := j.ID
+	e.Namespace = id.Namespace
+	e.Job = &id
+	diff, err := job.Diff(before, after)
+	if err != nil {
+		log.WithError(err).Error("unable to diff job for audit event")
+	}
+	e.Diff = diff
+	s.recordAudit(e)
+}
+
+// pruneAuditEvents deletes audit events past their retention until the process exits
+func (s *svr) pruneAuditEvents() {
+	for {
+		n, err := s.store.PruneAuditEvents(time.Now().Add(-s.AuditRetention))
+		if err != nil {
+			log.WithError(err).Error("unable to prune audit events")
+		} else if n > 0 {
+			log.WithFields(logrus.Fields{"pruned": n}).Info("pruned audit events past retention")
+		}
+		time.Sleep(auditPruneInterval)
+	}
+}
+
+// auditEvents lists audit events, newest first. Callers only see events in
+// namespaces they can get, and events outside any namespace if they are admins
+func (s *svr) auditEvents(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	q := r.URL.Query()
+	f := audit.Filter{
+		Namespace: q.Get("namespace"),
+		Job:       q.Get("job"),
+		Principal: q.Get("principal"),
+		Action:    audit.Action(q.Get("action")),
+	}
+	for param, t := range map[string]*time.Time{"since": &f.Since, "until": &f.Until} {
+		if v := q.Get(param); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write(errorJSON(fmt.Errorf("%s must be an RFC3339 timestamp", param)))
+				return
+			}
+			*t = parsed
+		}
+	}
+	limit := defaultAuditLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(errorJSON(fmt.Errorf("limit must be a positive integer")))
+			return
+		}
+		limit = n
+	}
+
+	allowed, err := s.authorizer(r)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errorJSON(err))
+		return
+	}
+	events, err := s.store.GetAuditEvents(&f, limit, func(e *audit.Event) bool {
+		if e.Namespace == "" {
+			return allowed(rbac.Admin, "")
+		}
+		return allowed(rbac.Get, e.Namespace)
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errorJSON(err))
+		return
+	}
+	json.NewEncoder(w).Encode(events)
+}
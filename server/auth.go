@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/byxorna/flow/config"
+	"github.com/sirupsen/logrus"
+)
+
+// Principal is an authenticated caller of the API
+type Principal struct {
+	Name   string
+	Groups []string
+	// Method is how the caller authenticated: token, certificate or anonymous
+	Method string
+}
+
+type principalKey struct{}
+
+// PrincipalFrom returns the principal a request was authenticated as, or nil
+func PrincipalFrom(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// tokenPrincipals maps the hex SHA-256 of each configured token to who it authenticates
+func tokenPrincipals(tokens []config.Token) map[string]*Principal {
+	m := map[string]*Principal{}
+	for _, t := range tokens {
+		hash := strings.ToLower(t.SHA256)
+		if t.Token != "" {
+			hash = hashToken(t.Token)
+		}
+		m[hash] = &Principal{Name: t.Principal, Groups: t.Groups, Method: "token"}
+	}
+	return m
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// authenticate identifies the caller by client certificate or bearer token,
+// and attaches the principal to the request context. When no authentication
+// is configured, every caller is let through anonymously
+func (s *svr) authenticate(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, err := s.principal(r)
+		if err != nil {
+			log.WithFields(logrus.Fields{"remoteaddr": r.RemoteAddr}).WithError(err).Info("authentication failed")
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="flow"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write(errorJSON(err))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	}
+}
+
+func (s *svr) principal(r *http.Request) (*Principal, error) {
+	if !s.AuthEnabled() {
+		return &Principal{Name: r.RemoteAddr, Method: "anonymous"}, nil
+	}
+	// certificates are only verified when a client CA is configured
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		cert := r.TLS.VerifiedChains[0][0]
+		return &Principal{Name: cert.Subject.CommonName, Groups: cert.Subject.Organization, Method: "certificate"}, nil
+	}
+	auth := r.Header.Get("Authorization")
+	if auth == "" {
+		return nil, fmt.Errorf("authentication required")
+	}
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return nil, fmt.Errorf("authorization must be a bearer token")
+	}
+	p, ok := s.tokens[hashToken(strings.TrimSpace(strings.TrimPrefix(auth, "Bearer ")))]
+	if !ok {
+		return nil, fmt.Errorf("invalid bearer token")
+	}
+	return p, nil
+}
+
+// tlsConfig returns the TLS config for serving the API, asking for client
+// certificates if a client CA is configured
+func (s *svr) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if s.TLSClientCAFile == "" {
+		return cfg, nil
+	}
+	pem, err := ioutil.ReadFile(s.TLSClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", s.TLSClientCAFile)
+	}
+	cfg.ClientCAs = pool
+	// clients may still authenticate with a token instead
+	cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	return cfg, nil
+}
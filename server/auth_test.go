@@ -0,0 +1,83 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/byxorna/flow/config"
+)
+
+// whoami is a handler that responds with the principal a request was
+// authenticated as
+func whoami(w http.ResponseWriter, r *http.Request) {
+	p := PrincipalFrom(r.Context())
+	w.Write([]byte(p.Method + ":" + p.Name))
+}
+
+func TestAuthenticate(t *testing.T) {
+	var c config.Config
+	c.AuthTokensFile = "tokens.yaml"
+	c.TLSClientCAFile = "ca.pem"
+	c.Tokens = []config.Token{
+		{Principal: "plain", Token: "secret"},
+		{Principal: "hashed", SHA256: hashToken("hidden")},
+	}
+	s := &svr{Config: c, tokens: tokenPrincipals(c.Tokens)}
+	h := s.authenticate(http.HandlerFunc(whoami))
+
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "worker", Organization: []string{"ops"}}}
+	for _, tc := range []struct {
+		name   string
+		header string
+		cert   *x509.Certificate
+		code   int
+		body   string
+	}{
+		{name: "missing header", code: http.StatusUnauthorized},
+		{name: "not a bearer token", header: "Basic c2VjcmV0", code: http.StatusUnauthorized},
+		{name: "wrong token", header: "Bearer nope", code: http.StatusUnauthorized},
+		{name: "token", header: "Bearer secret", code: http.StatusOK, body: "token:plain"},
+		{name: "sha256 token", header: "Bearer hidden", code: http.StatusOK, body: "token:hashed"},
+		{name: "client certificate", cert: cert, code: http.StatusOK, body: "certificate:worker"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tc.header != "" {
+			r.Header.Set("Authorization", tc.header)
+		}
+		if tc.cert != nil {
+			r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{tc.cert}}}
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.code, w.Code)
+		}
+		if tc.body != "" && w.Body.String() != tc.body {
+			t.Errorf("%s: expected %s, got %s", tc.name, tc.body, w.Body)
+		}
+	}
+}
+
+func TestAuthenticateEmptyTokensFile(t *testing.T) {
+	// a tokens file that loaded no tokens must not leave the API open
+	var c config.Config
+	c.AuthTokensFile = "tokens.yaml"
+	s := &svr{Config: c, tokens: tokenPrincipals(c.Tokens)}
+	h := s.authenticate(http.HandlerFunc(whoami))
+
+	for _, header := range []string{"", "Bearer anything"} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("expected %d with authorization %q, got %d", http.StatusUnauthorized, header, w.Code)
+		}
+	}
+}
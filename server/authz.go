@@ -0,0 +1,121 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/byxorna/flow/types/audit"
+	"github.com/byxorna/flow/types/rbac"
+)
+
+// subject returns who made a request, for access control
+func subject(r *http.Request) rbac.Subject {
+	if p := PrincipalFrom(r.Context()); p != nil {
+		return rbac.Subject{Name: p.Name, Groups: p.Groups}
+	}
+	return rbac.Subject{Name: r.RemoteAddr}
+}
+
+// authorizer returns a function that checks whether the caller may do a verb
+// in a namespace. The policy is read from storage each time authorizer is
+// called, so it can change without a restart; a handler that checks more
+// than once calls it once and reuses the function. The admins from config
+// are allowed everything, so there is always someone who can store a
+// policy. Until one is stored, everyone else is denied everything, unless
+// authentication is off, in which case callers cannot be told apart and
+// everything is allowed
+func (s *svr) authorizer(r *http.Request) (func(verb rbac.Verb, namespace string) bool, error) {
+	sub := subject(r)
+	if s.admins().Allowed(sub, rbac.Admin, "") {
+		return func(rbac.Verb, string) bool { return true }, nil
+	}
+	policy, err := s.store.GetPolicy()
+	if err != nil {
+		return nil, err
+	}
+	return func(verb rbac.Verb, namespace string) bool {
+		if policy == nil {
+			return !s.AuthEnabled()
+		}
+		return policy.Allowed(sub, verb, namespace)
+	}, nil
+}
+
+// admins is a policy that allows the admins from config everything
+func (s *svr) admins() *rbac.Policy {
+	return &rbac.Policy{
+		Roles:    []rbac.Role{{Name: "admin", Verbs: rbac.Verbs, Namespaces: []string{rbac.AllNamespaces}}},
+		Bindings: []rbac.Binding{{Role: "admin", Principals: s.AuthAdmins, Groups: s.AuthAdminGroups}},
+	}
+}
+
+// authorize responds with 403 and returns false if the caller may not do verb in namespace
+func (s *svr) authorize(w http.ResponseWriter, r *http.Request, verb rbac.Verb, namespace string) bool {
+	allowed, err := s.authorizer(r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errorJSON(err))
+		return false
+	}
+	return permit(w, r, allowed, verb, namespace)
+}
+
+// permit is authorize with the policy already read by authorizer
+func permit(w http.ResponseWriter, r *http.Request, allowed func(rbac.Verb, string) bool, verb rbac.Verb, namespace string) bool {
+	if allowed(verb, namespace) {
+		return true
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	if namespace == "" {
+		w.Write(errorJSON(fmt.Errorf("%s is not allowed to %s", subject(r).Name, verb)))
+	} else {
+		w.Write(errorJSON(fmt.Errorf("%s is not allowed to %s in namespace %s", subject(r).Name, verb, namespace)))
+	}
+	return false
+}
+
+func (s *svr) policy(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !s.authorize(w, r, rbac.Admin, "") {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		p, err := s.store.GetPolicy()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(errorJSON(err))
+			return
+		}
+		if p == nil {
+			w.WriteHeader(http.StatusNotFound)
+			if s.AuthEnabled() {
+				w.Write(errorJSON(fmt.Errorf("no policy is set, only the admins from config are allowed anything")))
+			} else {
+				w.Write(errorJSON(fmt.Errorf("no policy is set, and authentication is off, so every caller is allowed everything")))
+			}
+			return
+		}
+		json.NewEncoder(w).Encode(p)
+	case http.MethodPut:
+		var p rbac.Policy
+		defer r.Body.Close()
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write(errorJSON(err))
+			return
+		}
+		if err := s.store.SetPolicy(&p); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(errorJSON(err))
+			return
+		}
+		s.recordAudit(newAuditEvent(r, audit.Update, audit.PolicyResource))
+		json.NewEncoder(w).Encode(p)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/byxorna/flow/config"
+)
+
+func TestNoPolicyDeniesAllButAdmins(t *testing.T) {
+	var c config.Config
+	c.Tokens = testTokens
+	c.AuthAdmins = []string{"admin"}
+	h, _ := newTestServerWith(t, c, nil)
+
+	for _, tc := range []struct {
+		token, method, path string
+	}{
+		{"reader", http.MethodGet, "/v1/job/default/a"},
+		{"editor", http.MethodGet, "/v1/jobs/default"},
+		{"editor", http.MethodPut, "/v1/rbac/policy"},
+	} {
+		if w := call(h, tc.token, tc.method, tc.path, "application/json", []byte(`{}`)); w.Code != http.StatusForbidden {
+			t.Errorf("%s %s %s: expected %d without a policy, got %d: %s", tc.token, tc.method, tc.path, http.StatusForbidden, w.Code, w.Body)
+		}
+	}
+
+	// the admin from config sets up the first policy
+	policy, err := json.Marshal(testPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := call(h, "admin", http.MethodPut, "/v1/rbac/policy", "application/json", policy); w.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+	if w := call(h, "reader", http.MethodGet, "/v1/jobs/default", "", nil); w.Code != http.StatusOK {
+		t.Errorf("expected the stored policy to let reader list jobs, got %d: %s", w.Code, w.Body)
+	}
+	if w := call(h, "reader", http.MethodPut, "/v1/rbac/policy", "application/json", policy); w.Code != http.StatusForbidden {
+		t.Errorf("expected the stored policy to keep reader from changing it, got %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestNoPolicyWithoutAuth(t *testing.T) {
+	h, _ := newTestServerWith(t, config.Config{}, nil)
+	if w := call(h, "", http.MethodGet, "/v1/jobs", "", nil); w.Code != http.StatusOK {
+		t.Errorf("expected everything to be allowed without authentication, got %d: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/byxorna/flow/types/job"
+	"github.com/byxorna/flow/types/storage"
+	"github.com/docker/libkv/store"
+)
+
+const (
+	// dryRunFireTimes is how many upcoming runs a dry run reports
+	dryRunFireTimes = 5
+)
+
+// dryRunResponse is what a job would look like once stored, without storing it
+type dryRunResponse struct {
+	Spec     *job.Spec         `json:"spec"`
+	NextRuns []time.Time       `json:"next_runs"`
+	Diff     []job.FieldChange `json:"diff"`
+}
+
+// dryRunRequested reports whether a request asked for ?dryRun=true
+func dryRunRequested(r *http.Request) (bool, error) {
+	v := r.URL.Query().Get("dryRun")
+	if v == "" {
+		return false, nil
+	}
+	dryRun, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("dryRun must be true or false")
+	}
+	return dryRun, nil
+}
+
+// dryRunJob normalizes and validates a job the way storing it would, and
+// responds with the result and how it differs from the stored version
+func (s *svr) dryRunJob(w http.ResponseWriter, j *job.Spec, version uint64, mismatchStatus int) {
+	if err := s.store.PrepareJob(j); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(errorJSON(err))
+		return
+	}
+
+	current, err := s.store.GetJob(j.ID)
+	if err == store.ErrKeyNotFound {
+		current = nil
+	} else if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errorJSON(err))
+		return
+	}
+	if version != 0 && (current == nil || current.ResourceVersion != version) {
+		w.WriteHeader(mismatchStatus)
+		w.Write(errorJSON(storage.ErrVersionMismatch))
+		return
+	}
+	if current != nil {
+		// pauses are not set through the spec, so storing would keep the current one
+		j.Pause = current.Pause
+		j.ResourceVersion = current.ResourceVersion
+	}
+
+	diff, err := job.Diff(current, j)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errorJSON(err))
+		return
+	}
+	json.NewEncoder(w).Encode(dryRunResponse{
+		Spec:     j,
+		NextRuns: j.NextRuns(time.Now(), dryRunFireTimes),
+		Diff:     diff,
+	})
+}
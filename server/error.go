@@ -1,8 +1,10 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
 
+	"github.com/byxorna/flow/types/executor"
 	"github.com/sirupsen/logrus"
 )
 
@@ -10,3 +12,17 @@ func errorJSON(err error) []byte {
 	log.WithFields(logrus.Fields{"error": err}).Error()
 	return []byte(fmt.Sprintf(`{"result":"","error":%q}`, err))
 }
+
+// validationErrorJSON is errorJSON with the offending parameters broken out
+func validationErrorJSON(err *executor.ValidationError) []byte {
+	log.WithFields(logrus.Fields{"error": err}).Error()
+	b, _ := json.Marshal(struct {
+		Result string                `json:"result"`
+		Error  string                `json:"error"`
+		Fields []executor.FieldError `json:"fields"`
+	}{
+		Error:  err.Error(),
+		Fields: err.Fields,
+	})
+	return b
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/byxorna/flow/types/execution"
+	"github.com/byxorna/flow/types/job"
+	"github.com/byxorna/flow/types/rbac"
+	"github.com/docker/libkv/store"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+// executionGroup is all instances of one run of a job
+type executionGroup struct {
+	Group     int64                 `json:"group"`
+	Instances []*execution.Instance `json:"instances"`
+}
+
+type executionsResponse struct {
+	Groups  []executionGroup `json:"groups"`
+	Page    int              `json:"page"`
+	PerPage int              `json:"per_page"`
+	Total   int              `json:"total"`
+}
+
+// executionFilter selects instances by outcome and start time
+type executionFilter struct {
+	success *bool
+	since   time.Time
+	until   time.Time
+}
+
+func newExecutionFilter(r *http.Request) (*executionFilter, error) {
+	q := r.URL.Query()
+	f := executionFilter{}
+	if v := q.Get("success"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("success must be true or false")
+		}
+		f.success = &b
+	}
+	for param, t := range map[string]*time.Time{"since": &f.since, "until": &f.until} {
+		if v := q.Get(param); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be an RFC3339 timestamp", param)
+			}
+			*t = parsed
+		}
+	}
+	return &f, nil
+}
+
+func (f *executionFilter) matches(i *execution.Instance) bool {
+	if f.success != nil && i.Success != *f.success {
+		return false
+	}
+	if !f.since.IsZero() && i.StartedAt.Before(f.since) {
+		return false
+	}
+	if !f.until.IsZero() && i.StartedAt.After(f.until) {
+		return false
+	}
+	return true
+}
+
+// pagination returns the page and page size requested
+func pagination(r *http.Request) (int, int, error) {
+	q := r.URL.Query()
+	page, perPage := 1, defaultPerPage
+	var err error
+	if v := q.Get("page"); v != "" {
+		if page, err = strconv.Atoi(v); err != nil || page < 1 {
+			return 0, 0, fmt.Errorf("page must be a positive integer")
+		}
+	}
+	if v := q.Get("per_page"); v != "" {
+		if perPage, err = strconv.Atoi(v); err != nil || perPage < 1 || perPage > maxPerPage {
+			return 0, 0, fmt.Errorf("per_page must be between 1 and %d", maxPerPage)
+		}
+	}
+	return page, perPage, nil
+}
+
+func (s *svr) executions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+	id := job.ID{Namespace: vars["namespace"], Name: vars["name"]}
+	if !s.authorize(w, r, rbac.Get, id.Namespace) {
+		return
+	}
+
+	filter, err := newExecutionFilter(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(errorJSON(err))
+		return
+	}
+	page, perPage, err := pagination(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(errorJSON(err))
+		return
+	}
+	if _, err := s.store.GetJob(id); err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(errorJSON(err))
+		return
+	}
+
+	groups, order, err := s.store.GetGroupedExecutions(id)
+	if err != nil && err != store.ErrKeyNotFound {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errorJSON(err))
+		return
+	}
+
+	// order is newest first
+	matched := []executionGroup{}
+	for _, g := range order {
+		instances := []*execution.Instance{}
+		for _, i := range groups[g] {
+			if filter.matches(i) {
+				instances = append(instances, i)
+			}
+		}
+		if len(instances) > 0 {
+			matched = append(matched, executionGroup{Group: g, Instances: instances})
+		}
+	}
+
+	res := executionsResponse{
+		Groups:  []executionGroup{},
+		Page:    page,
+		PerPage: perPage,
+		Total:   len(matched),
+	}
+	if start := (page - 1) * perPage; start < len(matched) {
+		end := start + perPage
+		if end > len(matched) {
+			end = len(matched)
+		}
+		res.Groups = matched[start:end]
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+func (s *svr) execution(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+	id := job.ID{Namespace: vars["namespace"], Name: vars["name"]}
+	if !s.authorize(w, r, rbac.Get, id.Namespace) {
+		return
+	}
+	instanceID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(errorJSON(err))
+		return
+	}
+	i, err := s.store.GetExecution(id, instanceID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(errorJSON(err))
+		return
+	}
+	json.NewEncoder(w).Encode(i)
+}
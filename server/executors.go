@@ -0,0 +1,38 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/byxorna/flow/types"
+	"github.com/byxorna/flow/types/executor"
+	"github.com/byxorna/flow/types/job"
+	"github.com/gorilla/mux"
+)
+
+func (s *svr) executorSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	t := types.Executor(mux.Vars(r)["type"])
+	e, ok := s.executors[t]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(errorJSON(fmt.Errorf("no executor registered for type %q", t)))
+		return
+	}
+	json.NewEncoder(w).Encode(executor.SchemaOf(e.Parameters()))
+}
+
+// validateExecutorParameters checks a job's executor parameters against the
+// schema of its executor, and fills in defaults for any that were omitted
+func (s *svr) validateExecutorParameters(j *job.Spec) error {
+	e, ok := s.executors[j.Executor]
+	if !ok {
+		return fmt.Errorf("no executor registered for type %q", j.Executor)
+	}
+	if err := executor.Validate(e.Parameters(), j.ExecutorParameters); err != nil {
+		return err
+	}
+	j.ExecutorParameters = executor.WithDefaults(e.Parameters(), j.ExecutorParameters)
+	return nil
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/byxorna/flow/types"
+	"github.com/byxorna/flow/types/executor"
+)
+
+func TestExecutorSchema(t *testing.T) {
+	h, _ := newTestServer(t)
+
+	w := call(h, "reader", http.MethodGet, "/v1/executors/shell/schema", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+	var schema executor.Schema
+	if err := json.Unmarshal(w.Body.Bytes(), &schema); err != nil {
+		t.Fatal(err)
+	}
+	if schema.Executor != types.ShellExecutor {
+		t.Errorf("expected the shell executor's schema, got %q", schema.Executor)
+	}
+	command := false
+	for _, f := range schema.Fields {
+		if f.Name == "command" {
+			command = f.Required && f.Type == executor.StringField
+		}
+	}
+	if !command {
+		t.Errorf("expected a required string command field, got %+v", schema.Fields)
+	}
+
+	if w := call(h, "reader", http.MethodGet, "/v1/executors/docker/schema", "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected %d for an unknown executor, got %d: %s", http.StatusNotFound, w.Code, w.Body)
+	}
+}
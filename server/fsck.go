@@ -0,0 +1,108 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/byxorna/flow/types/audit"
+	"github.com/byxorna/flow/types/rbac"
+	"github.com/byxorna/flow/types/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// fsckRepair is a repair started through the API, which runs in the background
+type fsckRepair struct {
+	Principal  string              `json:"principal"`
+	StartedAt  time.Time           `json:"started_at"`
+	FinishedAt *time.Time          `json:"finished_at,omitempty"`
+	Report     *storage.FsckReport `json:"report,omitempty"`
+	Error      string              `json:"error,omitempty"`
+}
+
+// fsck scans the keyspace for corrupt, invalid, orphaned and dangling data.
+// GET only reports what it finds. POST starts a repair, which can take as
+// long as a scan of the whole keyspace, so it runs in the background and is
+// followed at /admin/fsck/repair. One repair runs at a time
+func (s *svr) fsck(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !s.authorize(w, r, rbac.Admin, "") {
+		return
+	}
+	if r.Method != http.MethodPost {
+		report, err := s.store.Fsck(false)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(errorJSON(err))
+			return
+		}
+		json.NewEncoder(w).Encode(report)
+		return
+	}
+
+	s.repairMu.Lock()
+	if s.repair != nil && s.repair.FinishedAt == nil {
+		s.repairMu.Unlock()
+		w.WriteHeader(http.StatusConflict)
+		w.Write(errorJSON(fmt.Errorf("a repair started by %s at %s is still running", s.repair.Principal, s.repair.StartedAt.Format(time.RFC3339))))
+		return
+	}
+	repair := &fsckRepair{Principal: requester(r), StartedAt: time.Now()}
+	s.repair = repair
+	status := *repair
+	s.repairMu.Unlock()
+
+	go s.runRepair(repair, newAuditEvent(r, audit.Repair, audit.KeyspaceResource))
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(status)
+}
+
+// runRepair runs a repair, and records the keys it repaired in event
+func (s *svr) runRepair(repair *fsckRepair, event *audit.Event) {
+	report, err := s.store.Fsck(true)
+	if report != nil {
+		for _, f := range report.Findings {
+			if f.Repaired {
+				event.Keys = append(event.Keys, f.Key)
+			}
+		}
+	}
+	logger := log.WithFields(logrus.Fields{"principal": repair.Principal})
+	if err != nil {
+		logger.WithError(err).Error("fsck repair failed")
+	} else {
+		logger.WithFields(logrus.Fields{"repaired": len(event.Keys), "unrepaired": report.Unrepaired()}).Info("fsck repair finished")
+	}
+	if len(event.Keys) > 0 {
+		s.recordAudit(event)
+	}
+
+	finished := time.Now()
+	s.repairMu.Lock()
+	defer s.repairMu.Unlock()
+	repair.FinishedAt = &finished
+	repair.Report = report
+	if err != nil {
+		repair.Error = err.Error()
+	}
+}
+
+// fsckRepair returns the latest repair started through the API, which is
+// still running if it has no finished_at
+func (s *svr) fsckRepair(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !s.authorize(w, r, rbac.Admin, "") {
+		return
+	}
+	s.repairMu.Lock()
+	if s.repair == nil {
+		s.repairMu.Unlock()
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(errorJSON(fmt.Errorf("no repair has been started since the server started")))
+		return
+	}
+	status := *s.repair
+	s.repairMu.Unlock()
+	json.NewEncoder(w).Encode(status)
+}
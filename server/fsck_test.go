@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/byxorna/flow/types"
+	"github.com/byxorna/flow/types/audit"
+	"github.com/byxorna/flow/types/execution"
+	"github.com/byxorna/flow/types/job"
+)
+
+func TestFsckRepairsInBackground(t *testing.T) {
+	h, store := newTestServer(t)
+	// a job deleted while it ran leaves its status and instance behind
+	j := &job.Spec{
+		ID:                 job.ID{Namespace: "default", Name: "gone"},
+		Owner:              "test",
+		ScheduleString:     "@every 1h",
+		Executor:           types.ShellExecutor,
+		ExecutorParameters: map[string]string{"command": "true"},
+	}
+	if err := store.SetJob(j); err != nil {
+		t.Fatal(err)
+	}
+	i := execution.NewInstance(j.ID)
+	i.StartedAt = time.Now()
+	if _, err := store.SetExecution(i); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Client.Delete(job.Prefix("", j.ID)); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := call(h, "editor", http.MethodPost, "/v1/admin/fsck", "", nil); w.Code != http.StatusForbidden {
+		t.Errorf("expected %d for a non-admin, got %d", http.StatusForbidden, w.Code)
+	}
+	if w := call(h, "admin", http.MethodGet, "/v1/admin/fsck/repair", "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected %d before any repair, got %d", http.StatusNotFound, w.Code)
+	}
+	if w := call(h, "admin", http.MethodPost, "/v1/admin/fsck", "", nil); w.Code != http.StatusAccepted {
+		t.Fatalf("expected %d, got %d: %s", http.StatusAccepted, w.Code, w.Body)
+	}
+
+	var repair fsckRepair
+	deadline := time.Now().Add(5 * time.Second)
+	for repair.FinishedAt == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the repair to finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+		w := call(h, "admin", http.MethodGet, "/v1/admin/fsck/repair", "", nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+		}
+		if err := json.NewDecoder(w.Body).Decode(&repair); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if repair.Principal != "admin" || repair.Error != "" || repair.Report == nil || repair.Report.Unrepaired() != 0 {
+		t.Errorf("expected a repair by admin that fixed everything, got %+v", repair)
+	}
+
+	events, err := store.GetAuditEvents(&audit.Filter{Action: audit.Repair}, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("expected one repair event, got %d", len(events))
+	}
+	keys := map[string]bool{}
+	for _, k := range events[0].Keys {
+		keys[k] = true
+	}
+	if !keys[job.StatePath("", j.ID)] || !keys[execution.Path("", j.ID)] || len(keys) != 2 {
+		t.Errorf("expected the status and instances of %s in the event, got %v", j.ID.String(), events[0].Keys)
+	}
+}
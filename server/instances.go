@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/byxorna/flow/types/audit"
+	"github.com/byxorna/flow/types/executor"
+	"github.com/byxorna/flow/types/rbac"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+func (s *svr) cancelInstance(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(errorJSON(err))
+		return
+	}
+	instance, err := s.store.GetInstance(id)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(errorJSON(err))
+		return
+	}
+	if !s.authorize(w, r, rbac.Cancel, instance.Job.Namespace) {
+		return
+	}
+	if !instance.FinishedAt.IsZero() {
+		w.WriteHeader(http.StatusConflict)
+		w.Write(errorJSON(fmt.Errorf("instance %s already finished", id)))
+		return
+	}
+	j, err := s.store.GetJob(instance.Job)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(errorJSON(err))
+		return
+	}
+	e, ok := s.executors[j.Executor]
+	if !ok {
+		w.WriteHeader(http.StatusConflict)
+		w.Write(errorJSON(fmt.Errorf("no executor registered for type %q", j.Executor)))
+		return
+	}
+	canceler, ok := e.(executor.Canceler)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		w.Write(errorJSON(fmt.Errorf("%s executor does not support cancellation", j.Executor)))
+		return
+	}
+	err = canceler.Cancel(id, requester(r))
+	if err == executor.ErrInstanceNotRunning {
+		// no executor here is running it. If its executor died, nothing
+		// ever will finish it, so record it as cancelled instead. Otherwise
+		// it runs on another node, which picks up the request from storage
+		var lost bool
+		lost, err = s.store.FailLostInstance(instance, requester(r), time.Now())
+		if err == nil && !lost {
+			err = s.store.RequestCancel(id, requester(r))
+		}
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errorJSON(err))
+		return
+	}
+	event := newAuditEvent(r, audit.Cancel, audit.InstanceResource)
+	event.Namespace = instance.Job.Namespace
+	event.Job = &instance.Job
+	event.Instance = &instance.ID
+	s.recordAudit(event)
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(instance)
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/byxorna/flow/config"
+	"github.com/byxorna/flow/types"
+	"github.com/byxorna/flow/types/execution"
+	"github.com/byxorna/flow/types/job"
+	"github.com/byxorna/flow/types/storage"
+)
+
+// waitForInstance polls the instances of a job until one satisfies ok
+func waitForInstance(t *testing.T, store storage.Store, id job.ID, ok func(*execution.Instance) bool) *execution.Instance {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		instances, err := store.GetExecutions(id)
+		if err == nil {
+			for _, i := range instances {
+				if ok(i) {
+					return i
+				}
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for an instance of %s", id.String())
+	return nil
+}
+
+func TestCancelInstanceOnAnotherNode(t *testing.T) {
+	store := storage.NewMemory()
+	if err := store.SetPolicy(testPolicy); err != nil {
+		t.Fatal(err)
+	}
+	var c config.Config
+	c.Tokens = testTokens
+	// the API call lands on one node, while the instance runs on the other
+	api, _ := newTestNode(t, c, store)
+	_, runner := newTestNode(t, c, store)
+	runner.CancelCheckInterval = 20 * time.Millisecond
+
+	j := &job.Spec{
+		ID:                 job.ID{Namespace: "default", Name: "sleepy"},
+		Owner:              "test",
+		ScheduleString:     "@every 1s",
+		Executor:           types.ShellExecutor,
+		ExecutorParameters: map[string]string{"command": "sleep 30"},
+	}
+	if err := store.SetJob(j); err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.Register(j); err != nil {
+		t.Fatal(err)
+	}
+	runner.Start()
+	defer runner.Stop()
+
+	running := waitForInstance(t, store, j.ID, func(i *execution.Instance) bool {
+		return !i.StartedAt.IsZero() && i.FinishedAt.IsZero()
+	})
+	w := call(api, "admin", http.MethodPost, "/v1/instances/"+running.ID.String()+"/cancel", "", nil)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected %d, got %d: %s", http.StatusAccepted, w.Code, w.Body)
+	}
+
+	cancelled := waitForInstance(t, store, j.ID, func(i *execution.Instance) bool {
+		return i.ID == running.ID && !i.FinishedAt.IsZero()
+	})
+	if !cancelled.Cancelled || cancelled.CancelledBy != "admin" {
+		t.Errorf("expected the instance to be cancelled by admin, got cancelled=%t by %q", cancelled.Cancelled, cancelled.CancelledBy)
+	}
+	// the request is removed once the executor is done with the instance,
+	// just after it is stored as finished
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		by, err := store.GetCancelRequest(running.ID)
+		if err == nil && by == "" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the cancel request to be removed once the instance stopped, got %q, %v", by, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
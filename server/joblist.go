@@ -0,0 +1,305 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/byxorna/flow/types"
+	"github.com/byxorna/flow/types/job"
+	"github.com/byxorna/flow/types/labels"
+	"github.com/byxorna/flow/types/namespace"
+	"github.com/docker/libkv/store"
+)
+
+const (
+	defaultJobLimit = 100
+	maxJobLimit     = 1000
+)
+
+// jobSortKeys are the fields the job list can be sorted by
+var jobSortKeys = map[string]func(*job.Spec) string{
+	"name":     func(j *job.Spec) string { return j.ID.String() },
+	"owner":    func(j *job.Spec) string { return j.Owner },
+	"executor": func(j *job.Spec) string { return string(j.Executor) },
+	"schedule": func(j *job.Spec) string { return j.ScheduleString },
+}
+
+// jobsResponse is a page of jobs. Continue is passed back as ?continue= to
+// fetch the next page, and is empty on the last one
+type jobsResponse struct {
+	Jobs     []*jobResponse `json:"jobs"`
+	Continue string         `json:"continue,omitempty"`
+}
+
+// jobListOptions select, order and page through jobs
+type jobListOptions struct {
+	labels      labels.Selector
+	annotations labels.Selector
+	owner       string
+	executor    types.Executor
+	disabled    *bool
+	status      *job.Status
+	sortBy      string
+	descending  bool
+	limit       int
+	after       *jobCursor
+}
+
+// jobCursor is the position of the last job on a page
+type jobCursor struct {
+	SortBy string `json:"s"`
+	Key    string `json:"k"`
+	ID     string `json:"i"`
+}
+
+func newJobListOptions(r *http.Request) (*jobListOptions, error) {
+	q := r.URL.Query()
+	o := jobListOptions{
+		owner:    q.Get("owner"),
+		executor: types.Executor(q.Get("executor")),
+		sortBy:   "name",
+		limit:    defaultJobLimit,
+	}
+	var err error
+	if o.labels, err = labels.Parse(q.Get("labelSelector")); err != nil {
+		return nil, err
+	}
+	if o.annotations, err = labels.Parse(q.Get("annotationSelector")); err != nil {
+		return nil, err
+	}
+	if v := q.Get("disabled"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("disabled must be true or false")
+		}
+		o.disabled = &b
+	}
+	if v := q.Get("status"); v != "" {
+		st, err := job.ParseStatus(v)
+		if err != nil {
+			return nil, err
+		}
+		o.status = &st
+	}
+	if v := q.Get("sort"); v != "" {
+		o.descending = strings.HasPrefix(v, "-")
+		o.sortBy = strings.TrimPrefix(v, "-")
+		if _, ok := jobSortKeys[o.sortBy]; !ok {
+			return nil, fmt.Errorf("cannot sort jobs by %q", o.sortBy)
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("limit must be a positive integer")
+		}
+		if n > maxJobLimit {
+			n = maxJobLimit
+		}
+		o.limit = n
+	}
+	if v := q.Get("continue"); v != "" {
+		c, err := decodeJobCursor(v)
+		if err != nil || c.SortBy != o.sortBy {
+			return nil, fmt.Errorf("invalid continue token for this query")
+		}
+		o.after = c
+	}
+	return &o, nil
+}
+
+// matches applies the filters that only need the spec, and the job's labels
+// with its namespace's defaults merged in
+func (o *jobListOptions) matches(j *job.Spec, labels map[string]string) bool {
+	if o.owner != "" && j.Owner != o.owner {
+		return false
+	}
+	if o.executor != "" && j.Executor != o.executor {
+		return false
+	}
+	if o.disabled != nil && j.Disabled != *o.disabled {
+		return false
+	}
+	return o.labels.Matches(labels) && o.annotations.Matches(j.Annotations)
+}
+
+// sort orders jobs by the sort key, breaking ties by ID so the order is stable across pages
+func (o *jobListOptions) sort(jobs []*job.Spec) {
+	key := jobSortKeys[o.sortBy]
+	sort.Slice(jobs, func(a, b int) bool {
+		return o.less(key(jobs[a]), jobs[a].ID.String(), key(jobs[b]), jobs[b].ID.String())
+	})
+}
+
+func (o *jobListOptions) less(keyA, idA, keyB, idB string) bool {
+	if keyA == keyB {
+		keyA, keyB = idA, idB
+	}
+	if o.descending {
+		return keyA > keyB
+	}
+	return keyA < keyB
+}
+
+// afterCursor reports whether a job comes after the continue token
+func (o *jobListOptions) afterCursor(j *job.Spec) bool {
+	if o.after == nil {
+		return true
+	}
+	return o.less(o.after.Key, o.after.ID, jobSortKeys[o.sortBy](j), j.ID.String())
+}
+
+func (o *jobListOptions) cursor(j *job.Spec) string {
+	b, _ := json.Marshal(jobCursor{SortBy: o.sortBy, Key: jobSortKeys[o.sortBy](j), ID: j.ID.String()})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeJobCursor(token string) (*jobCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+	var c jobCursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// listJobs filters, sorts and pages through the jobs of a namespace, or of
+// every namespace if it is empty, that visible is true for. In the default
+// order, by name, the store pages through jobs in key order, and only
+// reads the page. Other orders need every job read and sorted here
+func (s *svr) listJobs(namespace string, visible func(*job.Spec) bool, o *jobListOptions) (*jobsResponse, error) {
+	if o.sortBy == "name" && !o.descending {
+		return s.pageJobs(namespace, visible, o)
+	}
+	jobs, err := s.store.GetJobs(namespace)
+	if err != nil {
+		return nil, err
+	}
+	labelsOf := s.jobLabels()
+	read := map[string]*jobResponse{}
+	candidates := []*job.Spec{}
+	for _, j := range jobs {
+		if !visible(j) {
+			continue
+		}
+		labels, err := labelsOf(j)
+		if err != nil {
+			return nil, err
+		}
+		if !o.matches(j, labels) {
+			continue
+		}
+		// filter by status before paging, so pages stay full and the
+		// continue token points past what was actually returned
+		if o.status != nil {
+			jr, err := s.newJobResponse(j)
+			if err != nil {
+				return nil, err
+			}
+			if jr.Status.Status != *o.status {
+				continue
+			}
+			read[j.ID.String()] = jr
+		}
+		candidates = append(candidates, j)
+	}
+	o.sort(candidates)
+
+	res := &jobsResponse{Jobs: []*jobResponse{}}
+	for i, j := range candidates {
+		if !o.afterCursor(j) {
+			continue
+		}
+		if len(res.Jobs) == o.limit {
+			// only hand out a token if there is something left to fetch
+			res.Continue = o.cursor(candidates[i-1])
+			break
+		}
+		jr, ok := read[j.ID.String()]
+		if !ok {
+			if jr, err = s.newJobResponse(j); err != nil {
+				return nil, err
+			}
+		}
+		res.Jobs = append(res.Jobs, jr)
+	}
+	return res, nil
+}
+
+// pageJobs has the store page through jobs by name. Status is only read for
+// jobs on the page, unless the list is filtered by status, which needs it
+// for every job that made it past the other filters
+func (s *svr) pageJobs(namespace string, visible func(*job.Spec) bool, o *jobListOptions) (*jobsResponse, error) {
+	after := ""
+	if o.after != nil {
+		after = o.after.ID
+	}
+	labelsOf := s.jobLabels()
+	read := map[string]*jobResponse{}
+	page, err := s.store.GetJobPage(namespace, after, o.limit, func(j *job.Spec) (bool, error) {
+		if !visible(j) {
+			return false, nil
+		}
+		labels, err := labelsOf(j)
+		if err != nil || !o.matches(j, labels) {
+			return false, err
+		}
+		if o.status == nil {
+			return true, nil
+		}
+		jr, err := s.newJobResponse(j)
+		if err != nil {
+			return false, err
+		}
+		read[j.ID.String()] = jr
+		return jr.Status.Status == *o.status, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := &jobsResponse{Jobs: []*jobResponse{}}
+	for _, j := range page.Jobs {
+		jr, ok := read[j.ID.String()]
+		if !ok {
+			if jr, err = s.newJobResponse(j); err != nil {
+				return nil, err
+			}
+		}
+		res.Jobs = append(res.Jobs, jr)
+	}
+	if page.Continue != "" {
+		res.Continue = o.cursor(page.Jobs[len(page.Jobs)-1])
+	}
+	return res, nil
+}
+
+// jobLabels returns a func that gives the labels of a job with the defaults
+// of its namespace merged in, so selectors match namespace default labels.
+// Each namespace is read once
+func (s *svr) jobLabels() func(*job.Spec) (map[string]string, error) {
+	namespaces := map[string]*namespace.Namespace{}
+	return func(j *job.Spec) (map[string]string, error) {
+		n, ok := namespaces[j.ID.Namespace]
+		if !ok {
+			var err error
+			n, err = s.store.GetNamespace(j.ID.Namespace)
+			if err != nil && err != store.ErrKeyNotFound {
+				return nil, err
+			}
+			namespaces[j.ID.Namespace] = n
+		}
+		if n == nil {
+			return j.Labels, nil
+		}
+		return n.Labels(j), nil
+	}
+}
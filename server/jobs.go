@@ -5,8 +5,17 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"github.com/byxorna/flow/types/audit"
+	"github.com/byxorna/flow/types/execution"
+	"github.com/byxorna/flow/types/executor"
 	"github.com/byxorna/flow/types/job"
+	"github.com/byxorna/flow/types/namespace"
+	"github.com/byxorna/flow/types/rbac"
+	"github.com/byxorna/flow/types/storage"
+	"github.com/docker/libkv/store"
 	"github.com/gorilla/mux"
 	"gopkg.in/yaml.v2"
 )
@@ -19,13 +28,61 @@ func (s *svr) jobs(w http.ResponseWriter, r *http.Request) {
 	}
 	vars := mux.Vars(r)
 	namespace := vars["namespace"]
-	jobs, err := s.store.GetJobs(namespace)
+
+	opts, err := newJobListOptions(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(errorJSON(err))
+		return
+	}
+
+	allowed, err := s.authorizer(r)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errorJSON(err))
+		return
+	}
+	if namespace != "" && !permit(w, r, allowed, rbac.List, namespace) {
+		return
+	}
+
+	// listing every namespace only shows the ones the caller can list
+	visible := func(j *job.Spec) bool { return allowed(rbac.List, j.ID.Namespace) }
+	res, err := s.listJobs(namespace, visible, opts)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write(errorJSON(err))
 		return
 	}
-	json.NewEncoder(w).Encode(jobs)
+	json.NewEncoder(w).Encode(res)
+}
+
+// jobResponse is the user owned spec of a job, and its system owned status
+type jobResponse struct {
+	Spec   *job.Spec `json:"spec"`
+	Status jobStatus `json:"status"`
+}
+
+// jobStatus is the runtime state of a job along with the outcome of its last run
+type jobStatus struct {
+	job.State
+	execution.Summary
+}
+
+// newJobResponse reads the status of a job. The outcome of its last run is
+// kept in its state, so this is a single read
+func (s *svr) newJobResponse(j *job.Spec) (*jobResponse, error) {
+	state, err := s.store.GetJobState(j.ID)
+	if err != nil {
+		return nil, err
+	}
+	summary := execution.SummaryOf(state)
+	// the summary already says how the last run went
+	state.Latest = nil
+	return &jobResponse{
+		Spec:   j,
+		Status: jobStatus{State: *state, Summary: summary},
+	}, nil
 }
 
 func (s *svr) job(w http.ResponseWriter, r *http.Request) {
@@ -42,23 +99,39 @@ func (s *svr) job(w http.ResponseWriter, r *http.Request) {
 
 	switch r.Method {
 	case http.MethodGet:
+		if !s.authorize(w, r, rbac.Get, namespace) {
+			return
+		}
 		j, err := s.store.GetJob(id)
 		if err != nil {
 			w.WriteHeader(http.StatusNotFound)
 			w.Write(errorJSON(err))
 			return
 		}
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(j)
+		s.writeJob(w, http.StatusOK, j)
 	case http.MethodDelete:
-		j, err := s.store.DeleteJob(id)
+		if !s.authorize(w, r, rbac.Delete, namespace) {
+			return
+		}
+		version, err := ifMatch(r)
 		if err != nil {
-			w.WriteHeader(http.StatusNotFound)
+			w.WriteHeader(http.StatusBadRequest)
 			w.Write(errorJSON(err))
 			return
 		}
+		j, err := s.store.DeleteJob(id, version)
+		if err != nil {
+			w.WriteHeader(storeErrorStatus(err, http.StatusNotFound))
+			w.Write(errorJSON(err))
+			return
+		}
+		s.auditJob(r, audit.Delete, j, nil)
 		w.WriteHeader(http.StatusAccepted)
 		json.NewEncoder(w).Encode(j)
+	case http.MethodPut:
+		s.putJob(w, r, id)
+	case http.MethodPatch:
+		s.patchJob(w, r, id)
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
@@ -68,39 +141,253 @@ func (s *svr) job(w http.ResponseWriter, r *http.Request) {
 
 func (s *svr) postJob(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	var j job.Spec
-	var err error
+	j, status, err := decodeJob(r)
+	if err != nil {
+		w.WriteHeader(status)
+		w.Write(errorJSON(err))
+		return
+	}
+	version, err := ifMatch(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(errorJSON(err))
+		return
+	}
+	s.submitJob(w, r, j, version, http.StatusPreconditionFailed, http.StatusCreated)
+}
+
+// putJob creates or replaces the job at a path. Like patchJob, the caller
+// must be allowed to write jobs in the namespace before the job is read, and
+// the job is read under the ID it is stored as
+func (s *svr) putJob(w http.ResponseWriter, r *http.Request, id job.ID) {
+	allowed, err := s.authorizer(r)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errorJSON(err))
+		return
+	}
+	// whether this creates or updates is only known once the job is read
+	if !allowed(rbac.Create, id.Namespace) && !permit(w, r, allowed, rbac.Update, id.Namespace) {
+		return
+	}
+	j, status, err := decodeJob(r)
+	if err != nil {
+		w.WriteHeader(status)
+		w.Write(errorJSON(err))
+		return
+	}
+	id = storage.NormalizeID(id)
+	if j.ID == (job.ID{}) {
+		j.ID = id
+	} else if storage.NormalizeID(j.ID) != id {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(errorJSON(fmt.Errorf("job ID %s does not match path %s", j.ID.String(), id.String())))
+		return
+	}
+	version, err := ifMatch(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(errorJSON(err))
+		return
+	}
+
+	status = http.StatusOK
+	if _, err := s.store.GetJob(id); err == store.ErrKeyNotFound {
+		status = http.StatusCreated
+	}
+	s.submitJob(w, r, j, version, http.StatusPreconditionFailed, status)
+}
+
+// patchJob applies a JSON merge patch (RFC 7386) to a stored job. The caller
+// must be allowed to update jobs in the namespace before anything about the
+// job is read, so the response cannot tell them whether it exists
+func (s *svr) patchJob(w http.ResponseWriter, r *http.Request, id job.ID) {
+	if !s.authorize(w, r, rbac.Update, id.Namespace) {
+		return
+	}
 	switch r.Header.Get("Content-Type") {
-	case "application/json":
-		decoder := json.NewDecoder(r.Body)
-		err = decoder.Decode(&j)
-		defer r.Body.Close()
-	case "application/yaml":
-		b, err := ioutil.ReadAll(r.Body)
-		if err != nil {
-			w.WriteHeader(http.StatusUnsupportedMediaType)
-			w.Write(errorJSON(err))
-			return
-		}
-		err = yaml.Unmarshal(b, &j)
+	case "application/merge-patch+json", "application/json":
 	default:
 		w.WriteHeader(http.StatusUnsupportedMediaType)
 		return
 	}
+	version, err := ifMatch(r)
 	if err != nil {
-		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(errorJSON(err))
+		return
+	}
+	current, err := s.store.GetJob(id)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
 		w.Write(errorJSON(err))
 		return
 	}
+	// without If-Match, the patch still must apply to the version it was merged with,
+	// but losing that race is a conflict rather than a failed precondition
+	mismatchStatus := http.StatusPreconditionFailed
+	if version == 0 {
+		version = current.ResourceVersion
+		mismatchStatus = http.StatusConflict
+	} else if version != current.ResourceVersion {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		w.Write(errorJSON(storage.ErrVersionMismatch))
+		return
+	}
 
-	log.Debugf("storing a job %v", j)
+	var patch interface{}
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write(errorJSON(err))
+		return
+	}
+	j, err := applyMergePatch(current, patch)
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write(errorJSON(err))
+		return
+	}
+	if j.ID != id {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(errorJSON(fmt.Errorf("job ID cannot be changed")))
+		return
+	}
+	s.submitJob(w, r, j, version, mismatchStatus, http.StatusOK)
+}
 
-	err = s.store.SetJob(&j)
+// submitJob validates and stores a job, and responds with the stored job.
+// With ?dryRun=true the job is validated but not stored
+func (s *svr) submitJob(w http.ResponseWriter, r *http.Request, j *job.Spec, version uint64, mismatchStatus int, okStatus int) {
+	dryRun, err := dryRunRequested(r)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write(errorJSON(err))
 		return
 	}
-	w.WriteHeader(http.StatusCreated)
+	// everything below checks the job under the ID it will be stored as
+	j.ID = storage.NormalizeID(j.ID)
+	before, err := s.store.GetJob(j.ID)
+	if err != nil && err != store.ErrKeyNotFound {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errorJSON(err))
+		return
+	}
+	verb, action := rbac.Create, audit.Create
+	if before != nil {
+		verb, action = rbac.Update, audit.Update
+	}
+	if !s.authorize(w, r, verb, j.ID.Namespace) {
+		return
+	}
+	if err := s.validateExecutorParameters(j); err != nil {
+		if verr, ok := err.(*executor.ValidationError); ok {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write(validationErrorJSON(verr))
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(errorJSON(err))
+		return
+	}
+	if err := s.store.CheckJobQuota(j); err != nil {
+		status := http.StatusInternalServerError
+		if _, ok := err.(*namespace.QuotaError); ok {
+			status = http.StatusForbidden
+		}
+		w.WriteHeader(status)
+		w.Write(errorJSON(err))
+		return
+	}
+	if err := s.store.CheckDependencies(j); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(errorJSON(err))
+		return
+	}
+	if dryRun {
+		s.dryRunJob(w, j, version, mismatchStatus)
+		return
+	}
+
+	log.Debugf("storing a job %s", j.ID.String())
+
+	if err := s.store.SetJobVersion(j, version); err != nil {
+		status := storeErrorStatus(err, http.StatusBadRequest)
+		if err == storage.ErrVersionMismatch {
+			status = mismatchStatus
+		}
+		w.WriteHeader(status)
+		w.Write(errorJSON(err))
+		return
+	}
+	s.auditJob(r, action, before, j)
+	s.writeJob(w, okStatus, j)
+}
+
+// writeJob responds with a job, its last run, and its resource version as the ETag
+func (s *svr) writeJob(w http.ResponseWriter, status int, j *job.Spec) {
+	jr, err := s.newJobResponse(j)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errorJSON(err))
+		return
+	}
+	if j.ResourceVersion != 0 {
+		w.Header().Set("ETag", fmt.Sprintf("%q", strconv.FormatUint(j.ResourceVersion, 10)))
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(jr)
+}
 
+// decodeJob reads a job from a JSON or YAML request body. On error, the HTTP
+// status to respond with is returned as well
+func decodeJob(r *http.Request) (*job.Spec, int, error) {
+	var j job.Spec
+	defer r.Body.Close()
+	switch r.Header.Get("Content-Type") {
+	case "application/json":
+		if err := json.NewDecoder(r.Body).Decode(&j); err != nil {
+			return nil, http.StatusUnprocessableEntity, err
+		}
+	case "application/yaml":
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, http.StatusUnsupportedMediaType, err
+		}
+		if err := yaml.Unmarshal(b, &j); err != nil {
+			return nil, http.StatusUnprocessableEntity, err
+		}
+	default:
+		return nil, http.StatusUnsupportedMediaType, fmt.Errorf("unsupported content type %q", r.Header.Get("Content-Type"))
+	}
+	return &j, 0, nil
+}
+
+// ifMatch returns the resource version named by a request's If-Match header,
+// or 0 if the request does not require one
+func ifMatch(r *http.Request) (uint64, error) {
+	v := strings.TrimSpace(r.Header.Get("If-Match"))
+	if v == "" || v == "*" {
+		return 0, nil
+	}
+	v = strings.Trim(strings.TrimPrefix(v, "W/"), `"`)
+	version, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("If-Match must be a resource version")
+	}
+	return version, nil
+}
+
+// storeErrorStatus maps errors from the storage layer to HTTP statuses
+func storeErrorStatus(err error, fallback int) int {
+	switch err {
+	case store.ErrKeyNotFound:
+		return http.StatusNotFound
+	case storage.ErrVersionMismatch:
+		return http.StatusPreconditionFailed
+	case storage.ErrConflict, storage.ErrNamespaceNotEmpty:
+		return http.StatusConflict
+	default:
+		return fallback
+	}
 }
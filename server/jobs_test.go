@@ -0,0 +1,183 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/byxorna/flow/types"
+	"github.com/byxorna/flow/types/job"
+	"github.com/byxorna/flow/types/namespace"
+)
+
+func TestPatchJobAuthorizesBeforeReading(t *testing.T) {
+	h, _ := newTestServer(t)
+
+	// a caller who may not update jobs cannot learn whether one exists
+	w := call(h, "reader", http.MethodPatch, "/v1/job/default/missing", "application/merge-patch+json", []byte(`{"owner":"x"}`))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected %d for a caller without update, got %d: %s", http.StatusForbidden, w.Code, w.Body)
+	}
+	w = call(h, "editor", http.MethodPatch, "/v1/job/default/missing", "application/merge-patch+json", []byte(`{"owner":"x"}`))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected %d for a caller with update, got %d: %s", http.StatusNotFound, w.Code, w.Body)
+	}
+	w = call(h, "editor", http.MethodPatch, "/v1/job/other/missing", "application/merge-patch+json", []byte(`{"owner":"x"}`))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected %d in a namespace the caller cannot update, got %d: %s", http.StatusForbidden, w.Code, w.Body)
+	}
+}
+
+func TestSubmitJobNormalizesIDFirst(t *testing.T) {
+	h, store := newTestServer(t)
+	if err := store.SetNamespace(&namespace.Namespace{Name: "default", Owner: "test", Quota: &namespace.Quota{MaxJobs: 1}}, 0); err != nil {
+		t.Fatal(err)
+	}
+	body := `{"id":{"namespace":"default","name":"%s"},"owner":"test","schedule":"@every 1h","executor":"shell","executor_parameters":{"command":"true"}}`
+
+	w := call(h, "editor", http.MethodPost, "/v1/job", "application/json", []byte(fmt.Sprintf(body, "nightly-report")))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected %d, got %d: %s", http.StatusCreated, w.Code, w.Body)
+	}
+	// the same job under its unnormalized name is an update, so it is not
+	// held to the quota on new jobs
+	w = call(h, "editor", http.MethodPost, "/v1/job", "application/json", []byte(fmt.Sprintf(body, "Nightly Report")))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected %d, got %d: %s", http.StatusCreated, w.Code, w.Body)
+	}
+	jobs, err := store.GetJobs("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].ID.Name != "nightly-report" {
+		t.Errorf("expected only default/nightly-report to be stored, got %v", jobs)
+	}
+}
+
+func TestListJobsPages(t *testing.T) {
+	h, store := newTestServer(t)
+	for _, id := range []string{"default/c", "default/a", "other/b", "default/b"} {
+		j := &job.Spec{
+			ID:                 job.ID{Namespace: strings.Split(id, "/")[0], Name: strings.Split(id, "/")[1]},
+			Owner:              "test",
+			ScheduleString:     "@every 1h",
+			Executor:           types.ShellExecutor,
+			ExecutorParameters: map[string]string{"command": "true"},
+		}
+		if err := store.SetJob(j); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		token string
+		want  [][]string
+	}{
+		{"admin", [][]string{{"default/a", "default/b"}, {"default/c", "other/b"}}},
+		// reader cannot list other, so its jobs are skipped rather than counted
+		{"reader", [][]string{{"default/a", "default/b"}, {"default/c"}}},
+	} {
+		path := "/v1/jobs?limit=2"
+		for n, want := range tc.want {
+			w := call(h, tc.token, http.MethodGet, path, "", nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("%s page %d: expected %d, got %d: %s", tc.token, n, http.StatusOK, w.Code, w.Body)
+			}
+			var res struct {
+				Jobs []struct {
+					Spec *job.Spec `json:"spec"`
+				} `json:"jobs"`
+				Continue string `json:"continue"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, j := range res.Jobs {
+				got = append(got, j.Spec.ID.String())
+			}
+			if strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("%s page %d: expected %v, got %v", tc.token, n, want, got)
+			}
+			if last := n == len(tc.want)-1; last != (res.Continue == "") {
+				t.Errorf("%s page %d: unexpected continue token %q", tc.token, n, res.Continue)
+			}
+			path = "/v1/jobs?limit=2&continue=" + res.Continue
+		}
+	}
+}
+
+func TestListJobsFiltersStatusBeforePaging(t *testing.T) {
+	h, store := newTestServer(t)
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		j := &job.Spec{
+			ID:                 job.ID{Namespace: "default", Name: name},
+			Owner:              "test",
+			ScheduleString:     "@every 1h",
+			Executor:           types.ShellExecutor,
+			ExecutorParameters: map[string]string{"command": "true"},
+		}
+		if err := store.SetJob(j); err != nil {
+			t.Fatal(err)
+		}
+		if name == "c" || name == "e" {
+			if _, err := store.UpdateJobState(j.ID, func(s *job.State) {
+				s.Latest = &job.Latest{Group: 1, Status: job.Failed, StartedAt: time.Now(), FinishedAt: time.Now()}
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// both failed jobs fit on the page, so there is nothing to continue to
+	for _, sort := range []string{"name", "-name"} {
+		w := call(h, "admin", http.MethodGet, "/v1/jobs?status=failed&limit=2&sort="+sort, "", nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("sort %s: expected %d, got %d: %s", sort, http.StatusOK, w.Code, w.Body)
+		}
+		var res struct {
+			Jobs []struct {
+				Spec *job.Spec `json:"spec"`
+			} `json:"jobs"`
+			Continue string `json:"continue"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Jobs) != 2 || res.Continue != "" {
+			t.Errorf("sort %s: expected 2 jobs and no continue token, got %d and %q", sort, len(res.Jobs), res.Continue)
+		}
+	}
+}
+
+func TestPutJobAuthorizesBeforeReading(t *testing.T) {
+	h, _ := newTestServer(t)
+	body := `{"owner":"test","schedule":"@every 1h","executor":"shell","executor_parameters":{"command":"true"}}`
+
+	// a caller who may not write jobs cannot learn whether one exists
+	w := call(h, "reader", http.MethodPut, "/v1/job/default/Nightly%20Report", "application/json", []byte(body))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected %d for a caller without create or update, got %d: %s", http.StatusForbidden, w.Code, w.Body)
+	}
+
+	// the path is normalized before the job is looked up, so the second put
+	// replaces the job the first created
+	for _, tc := range []struct {
+		path string
+		body string
+		code int
+	}{
+		{"/v1/job/default/Nightly%20Report", body, http.StatusCreated},
+		{"/v1/job/default/nightly-report", body, http.StatusOK},
+		{"/v1/job/default/Nightly%20Report", `{"id":{"namespace":"default","name":"nightly-report"},` + body[1:], http.StatusOK},
+		{"/v1/job/default/nightly-report", `{"id":{"namespace":"default","name":"other"},` + body[1:], http.StatusBadRequest},
+	} {
+		w := call(h, "editor", http.MethodPut, tc.path, "application/json", []byte(tc.body))
+		if w.Code != tc.code {
+			t.Errorf("PUT %s: expected %d, got %d: %s", tc.path, tc.code, w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/byxorna/flow/types/execution"
+	"github.com/byxorna/flow/types/rbac"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// how often to look for new output when following logs
+const followInterval = 1 * time.Second
+
+// logCursor tracks the next chunk to read from each stream of an instance's logs
+type logCursor map[string]int
+
+// readLogs returns new chunks of the requested streams, ordered by when they were
+// written, and advances the cursor past them
+func (s *svr) readLogs(id uuid.UUID, cursor logCursor) ([]*execution.LogChunk, error) {
+	chunks := []*execution.LogChunk{}
+	for stream, from := range cursor {
+		c, err := s.store.GetLogs(id, stream, from)
+		if err != nil {
+			return nil, err
+		}
+		if len(c) > 0 {
+			cursor[stream] = c[len(c)-1].Seq + 1
+		}
+		chunks = append(chunks, c...)
+	}
+	sort.SliceStable(chunks, func(i, j int) bool { return chunks[i].Time.Before(chunks[j].Time) })
+	return chunks, nil
+}
+
+func (s *svr) instanceLogs(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(errorJSON(err))
+		return
+	}
+	opts, err := newLogOptions(r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(errorJSON(err))
+		return
+	}
+	instance, err := s.store.GetInstance(id)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(errorJSON(err))
+		return
+	}
+	if !s.authorize(w, r, rbac.Get, instance.Job.Namespace) {
+		return
+	}
+
+	cursor := logCursor{}
+	for _, stream := range opts.streams {
+		cursor[stream] = 0
+	}
+	chunks, err := s.readLogs(id, cursor)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errorJSON(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(opts.apply(concatLogs(chunks)))
+	if !opts.follow {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(followInterval)
+	defer ticker.Stop()
+	for instance.FinishedAt.IsZero() {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+		// check whether it finished before reading, so the last read gets everything
+		if instance, err = s.store.GetInstance(id); err != nil {
+			log.WithError(err).Errorf("unable to follow logs of %s", id)
+			return
+		}
+		chunks, err := s.readLogs(id, cursor)
+		if err != nil {
+			log.WithError(err).Errorf("unable to follow logs of %s", id)
+			return
+		}
+		if len(chunks) > 0 {
+			w.Write(concatLogs(chunks))
+			flusher.Flush()
+		}
+	}
+}
+
+// logOptions selects which part of an instance's output to return
+type logOptions struct {
+	streams []string
+	// tail returns only the last N lines
+	tail int
+	// offset and length select a byte range
+	offset int
+	length int
+	// follow streams new output until the instance finishes
+	follow bool
+}
+
+func newLogOptions(r *http.Request) (*logOptions, error) {
+	q := r.URL.Query()
+	opts := logOptions{streams: execution.Streams, length: -1}
+	if stream := q.Get("stream"); stream != "" {
+		if stream != execution.Stdout && stream != execution.Stderr {
+			return nil, fmt.Errorf("stream must be %s or %s", execution.Stdout, execution.Stderr)
+		}
+		opts.streams = []string{stream}
+	}
+	ints := []struct {
+		param string
+		dest  *int
+	}{{"tail", &opts.tail}, {"offset", &opts.offset}, {"length", &opts.length}}
+	for _, i := range ints {
+		if v := q.Get(i.param); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("%s must be a non-negative integer", i.param)
+			}
+			*i.dest = n
+		}
+	}
+	if opts.tail > 0 && (opts.offset > 0 || opts.length >= 0) {
+		return nil, fmt.Errorf("tail cannot be combined with offset or length")
+	}
+	if v := q.Get("follow"); v != "" {
+		follow, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("follow must be true or false")
+		}
+		opts.follow = follow
+	}
+	return &opts, nil
+}
+
+// apply cuts the requested lines or byte range out of the output
+func (o *logOptions) apply(b []byte) []byte {
+	if o.tail > 0 {
+		return tailLines(b, o.tail)
+	}
+	if o.offset >= len(b) {
+		return []byte{}
+	}
+	b = b[o.offset:]
+	if o.length >= 0 && o.length < len(b) {
+		b = b[:o.length]
+	}
+	return b
+}
+
+func concatLogs(chunks []*execution.LogChunk) []byte {
+	var buf bytes.Buffer
+	for _, c := range chunks {
+		buf.Write(c.Data)
+	}
+	return buf.Bytes()
+}
+
+// tailLines returns the last n lines of b
+func tailLines(b []byte, n int) []byte {
+	end := len(b)
+	// a trailing newline does not start another line
+	if end > 0 && b[end-1] == '\n' {
+		end--
+	}
+	for i := end - 1; i >= 0; i-- {
+		if b[i] == '\n' {
+			n--
+			if n == 0 {
+				return b[i+1:]
+			}
+		}
+	}
+	return b
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/byxorna/flow/types/audit"
+	"github.com/byxorna/flow/types/namespace"
+	"github.com/byxorna/flow/types/rbac"
+	"github.com/docker/libkv/store"
+	"github.com/gorilla/mux"
+)
+
+func (s *svr) namespaces(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	allowed, err := s.authorizer(r)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errorJSON(err))
+		return
+	}
+	namespaces, err := s.store.GetNamespaces()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errorJSON(err))
+		return
+	}
+	visible := []*namespace.Namespace{}
+	for _, n := range namespaces {
+		if allowed(rbac.Get, n.Name) {
+			visible = append(visible, n)
+		}
+	}
+	json.NewEncoder(w).Encode(visible)
+}
+
+func (s *svr) postNamespace(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	n, err := decodeNamespace(r)
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write(errorJSON(err))
+		return
+	}
+	if !s.authorize(w, r, rbac.Create, n.Name) {
+		return
+	}
+	if _, err := s.store.GetNamespace(n.Name); err == nil {
+		w.WriteHeader(http.StatusConflict)
+		w.Write(errorJSON(fmt.Errorf("namespace %s already exists", n.Name)))
+		return
+	}
+	s.submitNamespace(w, r, n, 0, audit.Create, http.StatusCreated)
+}
+
+func (s *svr) namespace(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	name := mux.Vars(r)["namespace"]
+
+	switch r.Method {
+	case http.MethodGet:
+		if !s.authorize(w, r, rbac.Get, name) {
+			return
+		}
+		n, err := s.store.GetNamespace(name)
+		if err != nil {
+			w.WriteHeader(storeErrorStatus(err, http.StatusInternalServerError))
+			w.Write(errorJSON(err))
+			return
+		}
+		writeNamespace(w, http.StatusOK, n)
+	case http.MethodPut:
+		n, err := decodeNamespace(r)
+		if err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write(errorJSON(err))
+			return
+		}
+		if n.Name == "" {
+			n.Name = name
+		} else if n.Name != name {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(errorJSON(fmt.Errorf("namespace %s does not match path %s", n.Name, name)))
+			return
+		}
+		version, err := ifMatch(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(errorJSON(err))
+			return
+		}
+		status, verb, action := http.StatusOK, rbac.Update, audit.Update
+		if _, err := s.store.GetNamespace(name); err == store.ErrKeyNotFound {
+			status, verb, action = http.StatusCreated, rbac.Create, audit.Create
+		}
+		if !s.authorize(w, r, verb, name) {
+			return
+		}
+		s.submitNamespace(w, r, n, version, action, status)
+	case http.MethodDelete:
+		if !s.authorize(w, r, rbac.Delete, name) {
+			return
+		}
+		cascade := false
+		if v := r.URL.Query().Get("cascade"); v != "" {
+			var err error
+			if cascade, err = strconv.ParseBool(v); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write(errorJSON(fmt.Errorf("cascade must be true or false")))
+				return
+			}
+		}
+		n, deleted, err := s.store.DeleteNamespace(name, cascade)
+		// every job that went with the namespace is audited, even if the
+		// namespace itself could not be deleted
+		for _, j := range deleted {
+			s.auditJob(r, audit.Delete, j, nil)
+		}
+		if err != nil {
+			w.WriteHeader(storeErrorStatus(err, http.StatusInternalServerError))
+			w.Write(errorJSON(err))
+			return
+		}
+		e := newAuditEvent(r, audit.Delete, audit.NamespaceResource)
+		e.Namespace = name
+		s.recordAudit(e)
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(n)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// submitNamespace stores a namespace and responds with it
+func (s *svr) submitNamespace(w http.ResponseWriter, r *http.Request, n *namespace.Namespace, version uint64, action audit.Action, okStatus int) {
+	if err := s.store.SetNamespace(n, version); err != nil {
+		w.WriteHeader(storeErrorStatus(err, http.StatusBadRequest))
+		w.Write(errorJSON(err))
+		return
+	}
+	e := newAuditEvent(r, action, audit.NamespaceResource)
+	e.Namespace = n.Name
+	s.recordAudit(e)
+	writeNamespace(w, okStatus, n)
+}
+
+// writeNamespace responds with a namespace, and its resource version as the ETag
+func writeNamespace(w http.ResponseWriter, status int, n *namespace.Namespace) {
+	if n.ResourceVersion != 0 {
+		w.Header().Set("ETag", fmt.Sprintf("%q", strconv.FormatUint(n.ResourceVersion, 10)))
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(n)
+}
+
+func decodeNamespace(r *http.Request) (*namespace.Namespace, error) {
+	var n namespace.Namespace
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
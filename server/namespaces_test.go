@@ -0,0 +1,41 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/byxorna/flow/types/audit"
+	"github.com/byxorna/flow/types/namespace"
+)
+
+func TestDeleteNamespaceAuditsJobs(t *testing.T) {
+	h, store := newTestServer(t)
+	if err := store.SetNamespace(&namespace.Namespace{Name: "default", Owner: "test"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	body := `{"id":{"namespace":"default","name":"%s"},"owner":"test","schedule":"@every 1h","executor":"shell","executor_parameters":{"command":"true"}}`
+	for _, name := range []string{"a", "b"} {
+		if w := call(h, "admin", http.MethodPost, "/v1/job", "application/json", []byte(fmt.Sprintf(body, name))); w.Code != http.StatusCreated {
+			t.Fatalf("expected %d, got %d: %s", http.StatusCreated, w.Code, w.Body)
+		}
+	}
+
+	w := call(h, "admin", http.MethodDelete, "/v1/namespaces/default?cascade=true", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+	events, err := store.GetAuditEvents(&audit.Filter{Namespace: "default", Action: audit.Delete}, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted := map[string]bool{}
+	for _, e := range events {
+		if e.Resource == audit.JobResource {
+			deleted[e.Job.String()] = true
+		}
+	}
+	if !deleted["default/a"] || !deleted["default/b"] || len(deleted) != 2 {
+		t.Errorf("expected a delete event for each job, got %v", deleted)
+	}
+}
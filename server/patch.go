@@ -0,0 +1,48 @@
+package server
+
+import (
+	"encoding/json"
+
+	"github.com/byxorna/flow/types/job"
+)
+
+// applyMergePatch applies a JSON merge patch to a job, returning the patched copy
+func applyMergePatch(j *job.Spec, patch interface{}) (*job.Spec, error) {
+	b, err := json.Marshal(j)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	if b, err = json.Marshal(mergePatch(doc, patch)); err != nil {
+		return nil, err
+	}
+	var patched job.Spec
+	if err := json.Unmarshal(b, &patched); err != nil {
+		return nil, err
+	}
+	return &patched, nil
+}
+
+// mergePatch implements RFC 7386: objects in the patch are merged recursively,
+// nulls remove keys, and anything else replaces the target
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = mergePatch(t[k], v)
+		}
+	}
+	return t
+}
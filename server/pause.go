@@ -0,0 +1,155 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/byxorna/flow/types/audit"
+	"github.com/byxorna/flow/types/job"
+	"github.com/byxorna/flow/types/rbac"
+	"github.com/gorilla/mux"
+)
+
+// pauseRequest is the optional body of a pause request
+type pauseRequest struct {
+	Reason string `json:"reason"`
+	// Until is when to resume automatically
+	Until *time.Time `json:"until"`
+}
+
+// newPause builds a Pause from a request
+func newPause(r *http.Request) (*job.Pause, error) {
+	var req pauseRequest
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		return nil, err
+	}
+	now := time.Now()
+	if req.Until != nil && !req.Until.After(now) {
+		return nil, fmt.Errorf("until must be in the future")
+	}
+	return &job.Pause{
+		By:     requester(r),
+		At:     now,
+		Reason: req.Reason,
+		Until:  req.Until,
+	}, nil
+}
+
+func (s *svr) pauseJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !s.authorize(w, r, rbac.Pause, mux.Vars(r)["namespace"]) {
+		return
+	}
+	p, err := newPause(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(errorJSON(err))
+		return
+	}
+	s.setJobPause(w, r, p)
+}
+
+func (s *svr) resumeJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !s.authorize(w, r, rbac.Pause, mux.Vars(r)["namespace"]) {
+		return
+	}
+	s.setJobPause(w, r, nil)
+}
+
+func (s *svr) setJobPause(w http.ResponseWriter, r *http.Request, p *job.Pause) {
+	vars := mux.Vars(r)
+	id := job.ID{Namespace: vars["namespace"], Name: vars["name"]}
+	before, err := s.store.GetJob(id)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(errorJSON(err))
+		return
+	}
+	j, err := s.store.SetJobPause(id, p)
+	if err != nil {
+		w.WriteHeader(storeErrorStatus(err, http.StatusInternalServerError))
+		w.Write(errorJSON(err))
+		return
+	}
+	s.auditJob(r, pauseAction(p), before, j)
+	s.writeJob(w, http.StatusOK, j)
+}
+
+// namespacePauseResponse is the pause of a namespace
+type namespacePauseResponse struct {
+	Namespace string     `json:"namespace"`
+	Pause     *job.Pause `json:"pause"`
+}
+
+func (s *svr) getNamespacePause(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !s.authorize(w, r, rbac.Get, mux.Vars(r)["namespace"]) {
+		return
+	}
+	p, err := s.store.GetNamespacePause(mux.Vars(r)["namespace"])
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errorJSON(err))
+		return
+	}
+	if p == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(errorJSON(fmt.Errorf("namespace is not paused")))
+		return
+	}
+	json.NewEncoder(w).Encode(namespacePauseResponse{Namespace: mux.Vars(r)["namespace"], Pause: p})
+}
+
+func (s *svr) pauseNamespace(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !s.authorize(w, r, rbac.Pause, mux.Vars(r)["namespace"]) {
+		return
+	}
+	p, err := newPause(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(errorJSON(err))
+		return
+	}
+	if err := s.store.SetNamespacePause(mux.Vars(r)["namespace"], p); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errorJSON(err))
+		return
+	}
+	s.auditNamespacePause(r, p)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(namespacePauseResponse{Namespace: mux.Vars(r)["namespace"], Pause: p})
+}
+
+func (s *svr) resumeNamespace(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !s.authorize(w, r, rbac.Pause, mux.Vars(r)["namespace"]) {
+		return
+	}
+	if err := s.store.SetNamespacePause(mux.Vars(r)["namespace"], nil); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(errorJSON(err))
+		return
+	}
+	s.auditNamespacePause(r, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pauseAction is the audit action of setting a pause, or clearing it
+func pauseAction(p *job.Pause) audit.Action {
+	if p == nil {
+		return audit.Resume
+	}
+	return audit.Pause
+}
+
+func (s *svr) auditNamespacePause(r *http.Request, p *job.Pause) {
+	e := newAuditEvent(r, pauseAction(p), audit.NamespaceResource)
+	e.Namespace = mux.Vars(r)["namespace"]
+	s.recordAudit(e)
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/byxorna/flow/types"
+	"github.com/byxorna/flow/types/executor"
+	"github.com/byxorna/flow/types/job"
+)
+
+// pausedJob is the part of a job response the pause tests look at
+type pausedJob struct {
+	Spec   *job.Spec              `json:"spec"`
+	Status map[string]interface{} `json:"status"`
+}
+
+func TestPauseJob(t *testing.T) {
+	h, store := newTestServer(t)
+	j := &job.Spec{
+		ID:                 job.ID{Namespace: "default", Name: "a"},
+		Owner:              "test",
+		ScheduleString:     "@every 1h",
+		Executor:           types.ShellExecutor,
+		ExecutorParameters: map[string]string{"command": "true"},
+	}
+	if err := store.SetJob(j); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if !executor.Runnable(store, j, now) {
+		t.Fatal("expected the job to be runnable before it is paused")
+	}
+
+	until := now.Add(time.Hour).UTC()
+	body := []byte(fmt.Sprintf(`{"reason":"maintenance","until":%q}`, until.Format(time.RFC3339Nano)))
+	w := call(h, "admin", http.MethodPost, "/v1/job/default/a/pause", "application/json", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+	var res pausedJob
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Spec == nil || res.Status == nil {
+		t.Fatalf("expected a job response with spec and status, got %s", w.Body)
+	}
+	if p := res.Spec.Pause; p == nil || p.By != "admin" || p.Reason != "maintenance" {
+		t.Errorf("expected the pause by admin to be returned, got %+v", p)
+	}
+
+	paused, err := store.GetJob(j.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if executor.Runnable(store, paused, now) {
+		t.Error("expected a paused job not to be runnable")
+	}
+	if !executor.Runnable(store, paused, until.Add(time.Second)) {
+		t.Error("expected the job to be runnable once the pause is over")
+	}
+
+	w = call(h, "admin", http.MethodPost, "/v1/job/default/a/resume", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+	res = pausedJob{}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Spec == nil || res.Spec.Pause != nil {
+		t.Errorf("expected the resumed job without a pause, got %s", w.Body)
+	}
+}
+
+func TestPauseJobErrors(t *testing.T) {
+	h, store := newTestServer(t)
+	j := &job.Spec{
+		ID:                 job.ID{Namespace: "default", Name: "a"},
+		Owner:              "test",
+		ScheduleString:     "@every 1h",
+		Executor:           types.ShellExecutor,
+		ExecutorParameters: map[string]string{"command": "true"},
+	}
+	if err := store.SetJob(j); err != nil {
+		t.Fatal(err)
+	}
+
+	past := []byte(fmt.Sprintf(`{"until":%q}`, time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)))
+	for _, tc := range []struct {
+		name  string
+		token string
+		path  string
+		body  []byte
+		code  int
+	}{
+		{"unknown job", "admin", "/v1/job/default/missing/pause", nil, http.StatusNotFound},
+		{"resume unknown job", "admin", "/v1/job/default/missing/resume", nil, http.StatusNotFound},
+		{"until in the past", "admin", "/v1/job/default/a/pause", past, http.StatusBadRequest},
+		{"without the pause verb", "editor", "/v1/job/default/a/pause", nil, http.StatusForbidden},
+	} {
+		w := call(h, tc.token, http.MethodPost, tc.path, "application/json", tc.body)
+		if w.Code != tc.code {
+			t.Errorf("%s: expected %d, got %d: %s", tc.name, tc.code, w.Code, w.Body)
+		}
+	}
+}
+
+func TestPauseNamespaceOverridesJob(t *testing.T) {
+	h, store := newTestServer(t)
+	j := &job.Spec{
+		ID:                 job.ID{Namespace: "default", Name: "a"},
+		Owner:              "test",
+		ScheduleString:     "@every 1h",
+		Executor:           types.ShellExecutor,
+		ExecutorParameters: map[string]string{"command": "true"},
+	}
+	if err := store.SetJob(j); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := call(h, "admin", http.MethodGet, "/v1/namespaces/default/pause", "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected %d before the namespace is paused, got %d", http.StatusNotFound, w.Code)
+	}
+	w := call(h, "admin", http.MethodPost, "/v1/namespaces/default/pause", "application/json", []byte(`{"reason":"freeze"}`))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+	var res namespacePauseResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Namespace != "default" || res.Pause == nil || res.Pause.Reason != "freeze" {
+		t.Errorf("expected the pause of default, got %s", w.Body)
+	}
+
+	// the job itself is not paused, but its namespace is
+	if j.Pause != nil || !j.Runnable(time.Now()) {
+		t.Fatal("expected the job itself not to be paused")
+	}
+	if executor.Runnable(store, j, time.Now()) {
+		t.Error("expected a job in a paused namespace not to be runnable")
+	}
+
+	if w := call(h, "admin", http.MethodPost, "/v1/namespaces/default/resume", "", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("expected %d, got %d: %s", http.StatusNoContent, w.Code, w.Body)
+	}
+	if !executor.Runnable(store, j, time.Now()) {
+		t.Error("expected the job to be runnable once its namespace is resumed")
+	}
+}
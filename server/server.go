@@ -4,10 +4,12 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/byxorna/flow/config"
-	"github.com/byxorna/flow/types/executor/shell"
+	"github.com/byxorna/flow/types"
+	"github.com/byxorna/flow/types/executor"
 	"github.com/byxorna/flow/types/storage"
 	"github.com/byxorna/flow/version"
 	"github.com/gorilla/mux"
@@ -32,35 +34,60 @@ func (rl *responseLogger) WriteHeader(code int) {
 	rl.duration = time.Since(rl.timeStart)
 }
 
+// Flush lets handlers stream responses through the logger
+func (rl *responseLogger) Flush() {
+	if f, ok := rl.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 type svr struct {
 	config.Config
 	router *mux.Router
 	// Store is the backend data access layer (etcd)
-	store *storage.Store
+	store storage.Store
 
 	// executors the server needs to know about
-	shellExecutor *shell.Executor
+	executors map[types.Executor]executor.Executor
+
+	// tokens are the principals of the configured bearer tokens, by token hash
+	tokens map[string]*Principal
+
+	// auditMirror is where audit events are copied to, if configured
+	auditMirror *auditMirror
+
+	// repair is the latest fsck repair started through the API
+	repair   *fsckRepair
+	repairMu sync.Mutex
 }
 
 // Server ...
 type Server interface {
 	ListenAndServe() error
-	RegisterShellExecutor(e *shell.Executor)
+	RegisterExecutor(e executor.Executor)
 }
 
-// RegisterShellExecutor ...
-func (s *svr) RegisterShellExecutor(e *shell.Executor) {
-	s.shellExecutor = e
+// RegisterExecutor makes an executor known to the server, so jobs using it
+// can be validated and its parameters discovered
+func (s *svr) RegisterExecutor(e executor.Executor) {
+	s.executors[e.Type()] = e
 }
 
 // New returns a new server
-func New(c config.Config, store *storage.Store) (Server, error) {
+func New(c config.Config, store storage.Store) (Server, error) {
 	router := mux.NewRouter()
+	mirror, err := newAuditMirror(c.AuditLogFile)
+	if err != nil {
+		return nil, err
+	}
 
 	s := svr{
-		Config: c,
-		router: router,
-		store:  store,
+		Config:      c,
+		router:      router,
+		store:       store,
+		executors:   map[types.Executor]executor.Executor{},
+		tokens:      tokenPrincipals(c.Tokens),
+		auditMirror: mirror,
 	}
 
 	// register http handlers
@@ -72,21 +99,66 @@ func New(c config.Config, store *storage.Store) (Server, error) {
 		HandlerFunc(s.jobs)
 	v1api.Path("/job").Methods("POST").
 		HandlerFunc(s.postJob)
-	v1api.Path("/job/{namespace}/{name}").Methods("GET", "DELETE").
+	v1api.Path("/job/{namespace}/{name}").Methods("GET", "DELETE", "PUT", "PATCH").
 		HandlerFunc(s.job)
+	v1api.Path("/job/{namespace}/{name}/executions").Methods("GET").
+		HandlerFunc(s.executions)
+	v1api.Path("/job/{namespace}/{name}/executions/{id}").Methods("GET").
+		HandlerFunc(s.execution)
+	v1api.Path("/job/{namespace}/{name}/pause").Methods("POST").
+		HandlerFunc(s.pauseJob)
+	v1api.Path("/job/{namespace}/{name}/resume").Methods("POST").
+		HandlerFunc(s.resumeJob)
+	v1api.Path("/namespaces").Methods("GET").
+		HandlerFunc(s.namespaces)
+	v1api.Path("/namespaces").Methods("POST").
+		HandlerFunc(s.postNamespace)
+	v1api.Path("/namespaces/{namespace}").Methods("GET", "PUT", "DELETE").
+		HandlerFunc(s.namespace)
+	v1api.Path("/namespaces/{namespace}/pause").Methods("GET").
+		HandlerFunc(s.getNamespacePause)
+	v1api.Path("/namespaces/{namespace}/pause").Methods("POST").
+		HandlerFunc(s.pauseNamespace)
+	v1api.Path("/namespaces/{namespace}/resume").Methods("POST").
+		HandlerFunc(s.resumeNamespace)
+	v1api.Path("/instances/{id}/logs").Methods("GET").
+		HandlerFunc(s.instanceLogs)
+	v1api.Path("/instances/{id}/cancel").Methods("POST").
+		HandlerFunc(s.cancelInstance)
+	v1api.Path("/executors/{type}/schema").Methods("GET").
+		HandlerFunc(s.executorSchema)
+	v1api.Path("/rbac/policy").Methods("GET", "PUT").
+		HandlerFunc(s.policy)
+	v1api.Path("/audit").Methods("GET").
+		HandlerFunc(s.auditEvents)
+	v1api.Path("/admin/fsck").Methods("GET", "POST").
+		HandlerFunc(s.fsck)
+	v1api.Path("/admin/fsck/repair").Methods("GET").
+		HandlerFunc(s.fsckRepair)
 
 	return &s, nil
 }
 
-// ListenAndServe calls http.ListenAndServe
+// ListenAndServe serves the API, over TLS if it is configured
 func (s *svr) ListenAndServe() error {
 	log.WithFields(
-		logrus.Fields{"address": s.ServerListenAddr},
+		logrus.Fields{"address": s.ServerListenAddr, "tls": s.TLSEnabled(), "auth": s.AuthEnabled()},
 	).Infof("Listening for HTTP requests")
-	return http.ListenAndServe(
-		s.ServerListenAddr,
-		logRequest(s.router),
-	)
+	go s.pruneAuditEvents()
+
+	hs := &http.Server{
+		Addr:    s.ServerListenAddr,
+		Handler: logRequest(s.authenticate(s.router)),
+	}
+	if !s.TLSEnabled() {
+		return hs.ListenAndServe()
+	}
+	tlsConfig, err := s.tlsConfig()
+	if err != nil {
+		return err
+	}
+	hs.TLSConfig = tlsConfig
+	return hs.ListenAndServeTLS(s.TLSCertFile, s.TLSKeyFile)
 }
 
 func (s *svr) getVersion(w http.ResponseWriter, r *http.Request) {
@@ -102,6 +174,14 @@ func (s *svr) getVersion(w http.ResponseWriter, r *http.Request) {
 	)
 }
 
+// requester identifies who made a request
+func requester(r *http.Request) string {
+	if p := PrincipalFrom(r.Context()); p != nil {
+		return p.Name
+	}
+	return r.RemoteAddr
+}
+
 func logRequest(next http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log.WithFields(
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/byxorna/flow/config"
+	"github.com/byxorna/flow/types/executor/shell"
+	"github.com/byxorna/flow/types/rbac"
+	"github.com/byxorna/flow/types/storage"
+)
+
+// testTokens authenticate as the principal of the same name
+var testTokens = []config.Token{
+	{Principal: "admin", Token: "admin"},
+	{Principal: "editor", Token: "editor"},
+	{Principal: "reader", Token: "reader"},
+}
+
+// testPolicy lets admin do anything, editor change jobs in default, and
+// reader only read them
+var testPolicy = &rbac.Policy{
+	Roles: []rbac.Role{
+		{Name: "admin", Verbs: rbac.Verbs, Namespaces: []string{rbac.AllNamespaces}},
+		{Name: "edit", Verbs: []rbac.Verb{rbac.Get, rbac.List, rbac.Create, rbac.Update, rbac.Delete}, Namespaces: []string{"default"}},
+		{Name: "read", Verbs: []rbac.Verb{rbac.Get, rbac.List}, Namespaces: []string{"default"}},
+	},
+	Bindings: []rbac.Binding{
+		{Role: "admin", Principals: []string{"admin"}},
+		{Role: "edit", Principals: []string{"editor"}},
+		{Role: "read", Principals: []string{"reader"}},
+	},
+}
+
+// newTestServer returns the API of a server with token auth and testPolicy,
+// backed by the memory store, that knows the shell executor
+func newTestServer(t *testing.T) (http.Handler, *storage.KVStore) {
+	var c config.Config
+	c.Tokens = testTokens
+	return newTestServerWith(t, c, testPolicy)
+}
+
+// newTestServerWith is newTestServer with config c, and policy p stored
+// unless it is nil
+func newTestServerWith(t *testing.T, c config.Config, p *rbac.Policy) (http.Handler, *storage.KVStore) {
+	store := storage.NewMemory()
+	if p != nil {
+		if err := store.SetPolicy(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h, _ := newTestNode(t, c, store)
+	return h, store
+}
+
+// newTestNode returns the API of a server with config c on store, and its
+// shell executor, which is not started. Nodes on the same store act like
+// the nodes of one cluster
+func newTestNode(t *testing.T, c config.Config, store storage.Store) (http.Handler, *shell.Executor) {
+	srv, err := New(c, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := shell.New(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.RegisterExecutor(e)
+	s := srv.(*svr)
+	return s.authenticate(s.router), e
+}
+
+// call makes a request as the principal with token, and returns the response
+func call(h http.Handler, token, method, path, contentType string, body []byte) *httptest.ResponseRecorder {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req := httptest.NewRequest(method, path, r)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
@@ -0,0 +1,120 @@
+package audit
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/byxorna/flow/types/job"
+	"github.com/google/uuid"
+)
+
+const (
+	// StoragePath is the path in storage where audit events are stored
+	StoragePath = "audit"
+)
+
+// Action is what was done
+type Action string
+
+// Actions are named after the API call that made the change. Run is a job
+// started by hand rather than by its schedule, and Repair is a fix made
+// by fsck
+const (
+	Create Action = "create"
+	Update Action = "update"
+	Delete Action = "delete"
+	Pause  Action = "pause"
+	Resume Action = "resume"
+	Run    Action = "run"
+	Cancel Action = "cancel"
+	Repair Action = "repair"
+)
+
+// Resource is the kind of thing an action was done to
+type Resource string
+
+// Resources are what the API manages. KeyspaceResource is the whole of
+// storage, for changes such as repairs that span every kind of resource
+const (
+	JobResource       Resource = "job"
+	NamespaceResource Resource = "namespace"
+	InstanceResource  Resource = "instance"
+	PolicyResource    Resource = "policy"
+	KeyspaceResource  Resource = "keyspace"
+)
+
+// Event records a change made through the API, and who made it
+type Event struct {
+	ID         uuid.UUID `json:"id"`
+	Time       time.Time `json:"time"`
+	Principal  string    `json:"principal"`
+	SourceAddr string    `json:"source_addr"`
+	Action     Action    `json:"action"`
+	Resource   Resource  `json:"resource"`
+	// Namespace is the namespace of the resource, if it has one
+	Namespace string     `json:"namespace,omitempty"`
+	Job       *job.ID    `json:"job,omitempty"`
+	Instance  *uuid.UUID `json:"instance,omitempty"`
+	// Diff is how the job spec changed
+	Diff []job.FieldChange `json:"diff,omitempty"`
+	// Keys are the storage keys a repair changed
+	Keys []string `json:"keys,omitempty"`
+}
+
+// NewEvent returns an event that happened now
+func NewEvent(principal string, sourceAddr string, action Action, resource Resource) *Event {
+	return &Event{
+		ID:         uuid.New(),
+		Time:       time.Now(),
+		Principal:  principal,
+		SourceAddr: sourceAddr,
+		Action:     action,
+		Resource:   resource,
+	}
+}
+
+// Path returns the path to an event in the storage system. Keys sort newest
+// first, so the latest events can be listed without reading older ones
+func (e *Event) Path(keyspace string) string {
+	return fmt.Sprintf("%s/%s/%s-%s", keyspace, StoragePath, TimeKey(e.Time), e.ID)
+}
+
+// TimeKey is the start of the keys of events at t. Events at t sort after
+// it, and newer events before it
+func TimeKey(t time.Time) string {
+	return fmt.Sprintf("%019d", math.MaxInt64-t.UnixNano())
+}
+
+// Filter selects audit events
+type Filter struct {
+	Namespace string
+	Job       string
+	Principal string
+	Action    Action
+	Since     time.Time
+	Until     time.Time
+}
+
+// Matches returns true if the event passes the filter
+func (f *Filter) Matches(e *Event) bool {
+	if f.Namespace != "" && e.Namespace != f.Namespace {
+		return false
+	}
+	if f.Job != "" && (e.Job == nil || e.Job.Name != f.Job) {
+		return false
+	}
+	if f.Principal != "" && e.Principal != f.Principal {
+		return false
+	}
+	if f.Action != "" && e.Action != f.Action {
+		return false
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && e.Time.After(f.Until) {
+		return false
+	}
+	return true
+}
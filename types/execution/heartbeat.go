@@ -0,0 +1,47 @@
+package execution
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// HeartbeatsPath is the path in storage where running instances record
+	// that they are still alive
+	HeartbeatsPath = "heartbeats"
+	// HeartbeatInterval is how often the executor running an instance
+	// records its heartbeat
+	HeartbeatInterval = 30 * time.Second
+	// LostAfter is how long an unfinished instance can go without a
+	// heartbeat before it is considered lost, i.e. its executor died
+	LostAfter = 4 * HeartbeatInterval
+	// CancelsPath is the path in storage where cancelling an instance is
+	// requested of the executor running it, which may be on another node
+	CancelsPath = "cancels"
+)
+
+// HeartbeatPath returns the path to the heartbeat of a running instance
+func HeartbeatPath(keyspace string, instance uuid.UUID) string {
+	return fmt.Sprintf("%s/%s/%s", keyspace, HeartbeatsPath, instance)
+}
+
+// CancelPath returns the path to the cancel request of a running instance
+func CancelPath(keyspace string, instance uuid.UUID) string {
+	return fmt.Sprintf("%s/%s/%s", keyspace, CancelsPath, instance)
+}
+
+// Lost returns true if the instance has not finished, and nothing has
+// heard from it since LostAfter before now. heartbeat is its last
+// heartbeat, or the zero time if it never had one
+func (e *Instance) Lost(heartbeat, now time.Time) bool {
+	if !e.FinishedAt.IsZero() {
+		return false
+	}
+	last := heartbeat
+	if last.Before(e.StartedAt) {
+		last = e.StartedAt
+	}
+	return now.Sub(last) > LostAfter
+}
@@ -28,7 +28,18 @@ type Instance struct {
 	// If this execution executed succesfully.
 	Success bool `json:"success,omitempty"`
 
+	// If this execution was cancelled before it finished. Cancelled
+	// executions are not retried.
+	Cancelled bool `json:"cancelled,omitempty"`
+
+	// Who cancelled the execution.
+	CancelledBy string `json:"cancelled_by,omitempty"`
+
+	// When the execution was cancelled.
+	CancelledAt time.Time `json:"cancelled_at,omitempty"`
+
 	// Partial output of the execution.
+	// Deprecated: output is captured incrementally as logs, see LogChunk
 	Output []byte `json:"output,omitempty"`
 
 	// ExecutorAttributes filled by executor (node name, etc)
@@ -0,0 +1,56 @@
+package execution
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// LogsPath is the path in storage where instance output is stored
+	LogsPath = "logs"
+	// Stdout is the stream name of an instance's standard output
+	Stdout = "stdout"
+	// Stderr is the stream name of an instance's standard error
+	Stderr = "stderr"
+	// logIndexKey is the key under an instance's logs that names its job
+	logIndexKey = "job"
+)
+
+// Streams are the output streams captured for every instance
+var Streams = []string{Stdout, Stderr}
+
+// LogChunk is a piece of output written by an instance while it runs
+type LogChunk struct {
+	// Stream is the stream the chunk was written to
+	Stream string `json:"stream"`
+	// Seq orders chunks within a stream
+	Seq int `json:"seq"`
+	// Time the chunk was written
+	Time time.Time `json:"time"`
+	// Data is the output itself
+	Data []byte `json:"data"`
+}
+
+// LogPath returns the path in the storage layer for an instance's logs
+func LogPath(prefix string, instance uuid.UUID) string {
+	return fmt.Sprintf("%s/%s/%s", prefix, LogsPath, instance)
+}
+
+// LogIndexPath returns the path in the storage layer of the key that maps an
+// instance to its job
+func LogIndexPath(prefix string, instance uuid.UUID) string {
+	return fmt.Sprintf("%s/%s", LogPath(prefix, instance), logIndexKey)
+}
+
+// LogStreamPath returns the path in the storage layer for one stream of an instance's logs
+func LogStreamPath(prefix string, instance uuid.UUID, stream string) string {
+	return fmt.Sprintf("%s/%s", LogPath(prefix, instance), stream)
+}
+
+// LogChunkPath returns the path in the storage layer for a single chunk of output.
+// Sequence numbers are padded so chunks list in order
+func LogChunkPath(prefix string, instance uuid.UUID, stream string, seq int) string {
+	return fmt.Sprintf("%s/%012d", LogStreamPath(prefix, instance, stream), seq)
+}
@@ -0,0 +1,74 @@
+package execution
+
+import (
+	"sort"
+	"time"
+
+	"github.com/byxorna/flow/types/job"
+	"github.com/google/uuid"
+)
+
+// Expired returns the instances that retention r no longer keeps at time now.
+// Groups are ranked newest first by group number, so the most recent
+// KeepGroups groups are always the ones kept. A group that is kept by count
+// still expires once it last did anything more than the max age ago.
+// Groups with an instance that is still running are never expired, but an
+// unfinished instance that is lost, i.e. has no heartbeat within LostAfter,
+// does not count as running. heartbeats holds the last heartbeat of
+// unfinished instances, by instance ID
+func Expired(instances []*Instance, heartbeats map[uuid.UUID]time.Time, r job.Retention, now time.Time) []*Instance {
+	groups := map[int64][]*Instance{}
+	for _, i := range instances {
+		groups[i.Group] = append(groups[i.Group], i)
+	}
+	numbers := make([]int64, 0, len(groups))
+	for g := range groups {
+		numbers = append(numbers, g)
+	}
+	sort.Slice(numbers, func(a, b int) bool { return numbers[a] > numbers[b] })
+
+	expired := []*Instance{}
+	for rank, g := range numbers {
+		group := groups[g]
+		if running(group, heartbeats, now) {
+			continue
+		}
+		tooMany := r.KeepGroups > 0 && rank >= r.KeepGroups
+		tooOld := r.MaxAgeDays > 0 && now.Sub(ranAt(group, heartbeats)) > r.MaxAge()
+		if tooMany || tooOld {
+			expired = append(expired, group...)
+		}
+	}
+	return expired
+}
+
+// running returns true if any instance of a group is still going, and not lost
+func running(group []*Instance, heartbeats map[uuid.UUID]time.Time, now time.Time) bool {
+	for _, i := range group {
+		if i.Cancelled {
+			continue
+		}
+		if i.FinishedAt.IsZero() && !i.Lost(heartbeats[i.ID], now) {
+			return true
+		}
+	}
+	return false
+}
+
+// ranAt returns when a group last did anything: the latest time one of its
+// instances started, finished or sent a heartbeat, or when the group was
+// created
+func ranAt(group []*Instance, heartbeats map[uuid.UUID]time.Time) time.Time {
+	var last time.Time
+	for _, i := range group {
+		for _, t := range []time.Time{i.StartedAt, i.FinishedAt, i.CancelledAt, heartbeats[i.ID]} {
+			if t.After(last) {
+				last = t
+			}
+		}
+	}
+	if last.IsZero() {
+		last = time.Unix(0, group[0].Group)
+	}
+	return last
+}
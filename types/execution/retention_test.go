@@ -0,0 +1,155 @@
+package execution
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/byxorna/flow/types/job"
+	"github.com/google/uuid"
+)
+
+// run is an instance in a retention test. Times are how long before now
+// something happened, and 0 if it did not
+type run struct {
+	group     string
+	started   time.Duration
+	finished  time.Duration
+	heartbeat time.Duration
+	cancelled bool
+}
+
+func TestExpired(t *testing.T) {
+	now := time.Date(2020, 1, 10, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	// groups are numbered by when they were created, a, b, c and d newest first
+	created := map[string]time.Duration{"a": 1 * time.Hour, "b": 2 * day, "c": 3 * day, "d": 4 * day}
+
+	for _, tc := range []struct {
+		name string
+		keep job.Retention
+		runs []run
+		want string
+	}{
+		{
+			name: "newest groups are kept by count",
+			keep: job.Retention{KeepGroups: 2},
+			runs: []run{
+				{group: "a", started: time.Hour, finished: time.Hour},
+				{group: "b", started: 2 * day, finished: 2 * day},
+				{group: "c", started: 3 * day, finished: 3 * day},
+				{group: "d", started: 4 * day, finished: 4 * day},
+			},
+			want: "c,d",
+		},
+		{
+			name: "a running group is kept past the count",
+			keep: job.Retention{KeepGroups: 1},
+			runs: []run{
+				{group: "a", started: time.Hour, finished: time.Hour},
+				{group: "b", started: 2 * day, finished: 2 * day},
+				{group: "c", started: 3 * day, heartbeat: time.Minute},
+			},
+			want: "b",
+		},
+		{
+			name: "a stale running group is pruned",
+			keep: job.Retention{KeepGroups: 1},
+			runs: []run{
+				{group: "a", started: time.Hour, finished: time.Hour},
+				{group: "b", started: 2 * day, heartbeat: 2 * day},
+				{group: "c", started: 3 * day},
+			},
+			want: "b,c",
+		},
+		{
+			name: "a group with one instance running and one finished is kept",
+			keep: job.Retention{KeepGroups: 1},
+			runs: []run{
+				{group: "a", started: time.Hour, finished: time.Hour},
+				{group: "b", started: 2 * day, finished: 2 * day},
+				{group: "b", started: 2 * day, heartbeat: time.Minute},
+			},
+			want: "",
+		},
+		{
+			name: "a group with one instance lost and one finished is pruned",
+			keep: job.Retention{KeepGroups: 1},
+			runs: []run{
+				{group: "a", started: time.Hour, finished: time.Hour},
+				{group: "b", started: 2 * day, finished: 2 * day},
+				{group: "b", started: 2 * day, heartbeat: day},
+			},
+			want: "b",
+		},
+		{
+			name: "a cancelled instance that never finished is not running",
+			keep: job.Retention{KeepGroups: 1},
+			runs: []run{
+				{group: "a", started: time.Hour, finished: time.Hour},
+				{group: "b", started: 2 * day, cancelled: true},
+			},
+			want: "b",
+		},
+		{
+			name: "groups past the max age expire",
+			keep: job.Retention{MaxAgeDays: 1},
+			runs: []run{
+				{group: "a", started: time.Hour, finished: time.Hour},
+				{group: "b", started: 2 * day, finished: 2 * day},
+			},
+			want: "b",
+		},
+		{
+			name: "a group kept by count still expires past the max age",
+			keep: job.Retention{KeepGroups: 5, MaxAgeDays: 3},
+			runs: []run{
+				{group: "a", started: time.Hour, finished: time.Hour},
+				{group: "c", started: 3 * day, finished: 3 * day},
+				{group: "d", started: 4 * day, finished: 4 * day},
+			},
+			want: "d",
+		},
+		{
+			name: "a lost group is as old as its last heartbeat",
+			keep: job.Retention{MaxAgeDays: 1},
+			runs: []run{
+				{group: "b", started: 2 * day, heartbeat: 10 * time.Minute},
+				{group: "c", started: 3 * day, heartbeat: 2 * day},
+			},
+			want: "c",
+		},
+	} {
+		instances := []*Instance{}
+		heartbeats := map[uuid.UUID]time.Time{}
+		groups := map[uuid.UUID]string{}
+		for _, r := range tc.runs {
+			i := &Instance{ID: uuid.New(), Group: now.Add(-created[r.group]).UnixNano(), Cancelled: r.cancelled}
+			if r.started > 0 {
+				i.StartedAt = now.Add(-r.started)
+			}
+			if r.finished > 0 {
+				i.FinishedAt = now.Add(-r.finished)
+			}
+			if r.heartbeat > 0 {
+				heartbeats[i.ID] = now.Add(-r.heartbeat)
+			}
+			groups[i.ID] = r.group
+			instances = append(instances, i)
+		}
+
+		seen := map[string]bool{}
+		for _, i := range Expired(instances, heartbeats, tc.keep, now) {
+			seen[groups[i.ID]] = true
+		}
+		got := []string{}
+		for g := range seen {
+			got = append(got, g)
+		}
+		sort.Strings(got)
+		if strings.Join(got, ",") != tc.want {
+			t.Errorf("%s: expected groups %q to expire, got %q", tc.name, tc.want, strings.Join(got, ","))
+		}
+	}
+}
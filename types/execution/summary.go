@@ -0,0 +1,108 @@
+package execution
+
+import (
+	"time"
+
+	"github.com/byxorna/flow/types/job"
+)
+
+// Summary describes the outcome of a job's last execution group
+type Summary struct {
+	// Status of the last run
+	Status job.Status `json:"status"`
+	// LastRun is when the last run started
+	LastRun time.Time `json:"last_run,omitempty"`
+	// LastRunDuration is how long the last run took, or has taken so far if
+	// it is still running
+	LastRunDuration string `json:"last_run_duration,omitempty"`
+}
+
+// Summarize returns the Summary of an execution group
+func Summarize(group []*Instance) Summary {
+	return SummaryOf(&job.State{Latest: Latest(group)})
+}
+
+// SummaryOf returns the Summary of a job's last execution group from the
+// job's state, as recorded by Latest
+func SummaryOf(state *job.State) Summary {
+	l := state.Latest
+	if l == nil {
+		return Summary{Status: job.Pending}
+	}
+	s := Summary{Status: l.Status, LastRun: l.StartedAt}
+	if !l.StartedAt.IsZero() {
+		finished := l.FinishedAt
+		if l.Status == job.Running {
+			finished = time.Now()
+		}
+		s.LastRunDuration = finished.Sub(l.StartedAt).String()
+	}
+	return s
+}
+
+// Latest returns how an execution group went, to be kept in its job's
+// state. An empty group has no outcome, and returns nil
+func Latest(group []*Instance) *job.Latest {
+	if len(group) == 0 {
+		return nil
+	}
+	outcomes := make(map[string]job.Outcome, len(group))
+	for _, i := range group {
+		outcomes[i.ID.String()] = OutcomeOf(i)
+	}
+	return LatestOf(group[0].Group, outcomes)
+}
+
+// OutcomeOf returns how a single instance went
+func OutcomeOf(i *Instance) job.Outcome {
+	return job.Outcome{
+		StartedAt:  i.StartedAt,
+		FinishedAt: i.FinishedAt,
+		Success:    i.Success,
+		Cancelled:  i.Cancelled,
+	}
+}
+
+// LatestOf returns how an execution group went from the outcomes of its
+// instances. The group is Running if any instance has not finished,
+// Cancelled if any instance was cancelled, Success or Failed if all
+// instances agree, and PartiallyFailed otherwise. No outcomes returns nil
+func LatestOf(group int64, outcomes map[string]job.Outcome) *job.Latest {
+	if len(outcomes) == 0 {
+		return nil
+	}
+
+	l := &job.Latest{Group: group, Instances: outcomes}
+	running, succeeded, cancelled := 0, 0, 0
+	for _, o := range outcomes {
+		if l.StartedAt.IsZero() || o.StartedAt.Before(l.StartedAt) {
+			l.StartedAt = o.StartedAt
+		}
+		if o.FinishedAt.IsZero() {
+			running++
+		} else if o.FinishedAt.After(l.FinishedAt) {
+			l.FinishedAt = o.FinishedAt
+		}
+		if o.Success {
+			succeeded++
+		}
+		if o.Cancelled {
+			cancelled++
+		}
+	}
+
+	switch {
+	case running > 0:
+		l.Status = job.Running
+		l.FinishedAt = time.Time{}
+	case cancelled > 0:
+		l.Status = job.Cancelled
+	case succeeded == len(outcomes):
+		l.Status = job.Success
+	case succeeded == 0:
+		l.Status = job.Failed
+	default:
+		l.Status = job.PartiallyFailed
+	}
+	return l
+}
@@ -0,0 +1,18 @@
+package executor
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrInstanceNotRunning is returned when cancelling an instance the executor is not running
+	ErrInstanceNotRunning = fmt.Errorf("instance is not running on this executor")
+)
+
+// Canceler is implemented by executors that can stop an instance while it runs
+type Canceler interface {
+	// Cancel stops a running instance, recording who cancelled it
+	Cancel(instance uuid.UUID, by string) error
+}
@@ -1,6 +1,7 @@
 package executor
 
 import (
+	"github.com/byxorna/flow/types"
 	"github.com/byxorna/flow/types/job"
 )
 
@@ -9,7 +10,8 @@ type Executor interface {
 	Register(job *job.Spec) error
 	Deregister(job *job.Spec) error
 	String() string
-	//	DefaultParameters() (Parameters, error)
+	Type() types.Executor
+	Parameters() Parameters
 	Start()
 	Stop()
 }
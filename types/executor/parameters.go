@@ -1,15 +1,141 @@
 package executor
 
 import (
-//	"github.com/byxorna/flow/types"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/byxorna/flow/types"
+)
+
+// FieldType is the type of value an executor parameter holds
+type FieldType string
+
+// Values of a field are checked to parse as its type, e.g. an IntField with
+// strconv.Atoi and a DurationField with time.ParseDuration
+const (
+	StringField   FieldType = "string"
+	IntField      FieldType = "int"
+	BoolField     FieldType = "bool"
+	DurationField FieldType = "duration"
 )
 
+// Field describes a single parameter an executor understands
+type Field struct {
+	Name        string    `json:"name"`
+	Type        FieldType `json:"type"`
+	Required    bool      `json:"required"`
+	Default     string    `json:"default,omitempty"`
+	Description string    `json:"description,omitempty"`
+}
+
 // Parameters is interface implemented by executors to define the fields a job
 // can populate to control how a job is to be run by them. i.e. docker container,
 // memory limits, etc
-/*
 type Parameters interface {
 	Type() types.Executor
-	//	Params() map[string]string
+	Fields() []Field
+}
+
+// Schema is the discoverable description of an executor's parameters
+type Schema struct {
+	Executor types.Executor `json:"executor"`
+	Fields   []Field        `json:"fields"`
+}
+
+// SchemaOf returns the Schema for a set of Parameters
+func SchemaOf(p Parameters) Schema {
+	return Schema{Executor: p.Type(), Fields: p.Fields()}
+}
+
+// FieldError is a validation failure of a single parameter
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"error"`
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// ValidationError holds every FieldError found when validating parameters
+type ValidationError struct {
+	Executor types.Executor
+	Fields   []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Error()
+	}
+	return fmt.Sprintf("invalid %s executor parameters: %s", e.Executor, strings.Join(msgs, ", "))
+}
+
+// Validate checks params against the fields declared by p. Unknown keys, missing
+// required keys and values that do not parse as the field's type are all reported
+// in the returned *ValidationError
+func Validate(p Parameters, params map[string]string) error {
+	fields := map[string]Field{}
+	for _, f := range p.Fields() {
+		fields[f.Name] = f
+	}
+
+	errs := []FieldError{}
+	for _, f := range p.Fields() {
+		v, ok := params[f.Name]
+		if !ok || v == "" {
+			if f.Required && f.Default == "" {
+				errs = append(errs, FieldError{Field: f.Name, Message: "is required"})
+			}
+			continue
+		}
+		if err := checkType(f.Type, v); err != nil {
+			errs = append(errs, FieldError{Field: f.Name, Message: fmt.Sprintf("must be a %s: %s", f.Type, err)})
+		}
+	}
+	unknown := []string{}
+	for k := range params {
+		if _, ok := fields[k]; !ok {
+			unknown = append(unknown, k)
+		}
+	}
+	sort.Strings(unknown)
+	for _, k := range unknown {
+		errs = append(errs, FieldError{Field: k, Message: "is not a known parameter"})
+	}
+
+	if len(errs) > 0 {
+		return &ValidationError{Executor: p.Type(), Fields: errs}
+	}
+	return nil
+}
+
+// WithDefaults returns a copy of params with any missing fields set to their defaults
+func WithDefaults(p Parameters, params map[string]string) map[string]string {
+	res := make(map[string]string, len(params))
+	for k, v := range params {
+		res[k] = v
+	}
+	for _, f := range p.Fields() {
+		if res[f.Name] == "" && f.Default != "" {
+			res[f.Name] = f.Default
+		}
+	}
+	return res
+}
+
+func checkType(t FieldType, v string) error {
+	var err error
+	switch t {
+	case IntField:
+		_, err = strconv.Atoi(v)
+	case BoolField:
+		_, err = strconv.ParseBool(v)
+	case DurationField:
+		_, err = time.ParseDuration(v)
+	}
+	return err
 }
-*/
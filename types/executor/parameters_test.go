@@ -0,0 +1,105 @@
+package executor
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/byxorna/flow/types"
+)
+
+// testParameters declares one field of each type
+type testParameters struct{}
+
+func (testParameters) Type() types.Executor { return types.ShellExecutor }
+
+func (testParameters) Fields() []Field {
+	return []Field{
+		{Name: "command", Type: StringField, Required: true},
+		{Name: "retries", Type: IntField, Default: "3"},
+		{Name: "verbose", Type: BoolField},
+		{Name: "timeout", Type: DurationField, Required: true, Default: "1m"},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		params map[string]string
+		// fields are the fields expected to be reported, in order
+		fields []string
+	}{
+		{name: "valid", params: map[string]string{"command": "true", "retries": "5", "verbose": "true", "timeout": "30s"}},
+		{name: "defaults fill required", params: map[string]string{"command": "true"}},
+		{name: "missing required", params: map[string]string{}, fields: []string{"command"}},
+		{name: "empty required", params: map[string]string{"command": ""}, fields: []string{"command"}},
+		{name: "wrong int", params: map[string]string{"command": "true", "retries": "many"}, fields: []string{"retries"}},
+		{name: "wrong bool", params: map[string]string{"command": "true", "verbose": "loud"}, fields: []string{"verbose"}},
+		{name: "wrong duration", params: map[string]string{"command": "true", "timeout": "10"}, fields: []string{"timeout"}},
+		{name: "unknown fields", params: map[string]string{"command": "true", "zone": "a", "image": "b"}, fields: []string{"image", "zone"}},
+		{name: "every error at once", params: map[string]string{"retries": "x", "zone": "a"}, fields: []string{"command", "retries", "zone"}},
+	} {
+		err := Validate(testParameters{}, tc.params)
+		if len(tc.fields) == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error %s", tc.name, err)
+			}
+			continue
+		}
+		verr, ok := err.(*ValidationError)
+		if !ok {
+			t.Errorf("%s: expected a *ValidationError, got %v", tc.name, err)
+			continue
+		}
+		got := []string{}
+		for _, f := range verr.Fields {
+			got = append(got, f.Field)
+		}
+		if !reflect.DeepEqual(got, tc.fields) {
+			t.Errorf("%s: expected errors for %v, got %v", tc.name, tc.fields, got)
+		}
+		if verr.Executor != types.ShellExecutor {
+			t.Errorf("%s: expected the error to name the executor, got %q", tc.name, verr.Executor)
+		}
+	}
+}
+
+func TestWithDefaults(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		params map[string]string
+		want   map[string]string
+	}{
+		{
+			name:   "fills missing",
+			params: map[string]string{"command": "true"},
+			want:   map[string]string{"command": "true", "retries": "3", "timeout": "1m"},
+		},
+		{
+			name:   "keeps given values",
+			params: map[string]string{"command": "true", "retries": "0", "timeout": "5s"},
+			want:   map[string]string{"command": "true", "retries": "0", "timeout": "5s"},
+		},
+		{
+			name:   "fills empty values",
+			params: map[string]string{"command": "true", "retries": ""},
+			want:   map[string]string{"command": "true", "retries": "3", "timeout": "1m"},
+		},
+		{
+			name:   "keeps unknown fields",
+			params: map[string]string{"zone": "a"},
+			want:   map[string]string{"zone": "a", "retries": "3", "timeout": "1m"},
+		},
+	} {
+		given := map[string]string{}
+		for k, v := range tc.params {
+			given[k] = v
+		}
+		got := WithDefaults(testParameters{}, tc.params)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, got)
+		}
+		if !reflect.DeepEqual(tc.params, given) {
+			t.Errorf("%s: expected the given parameters to be left alone, got %v", tc.name, tc.params)
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/byxorna/flow/types"
+	"github.com/byxorna/flow/types/execution"
+	"github.com/byxorna/flow/types/job"
+	"github.com/byxorna/flow/types/storage"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrWrongExecutor is returned when a job scheduled for another executor is registered
+	ErrWrongExecutor = fmt.Errorf("job is not configured to run with this executor")
+	// ErrJobNotFound is returned for a job that was never registered with the queue
+	ErrJobNotFound = fmt.Errorf("job not found in queue")
+)
+
+// Runner runs single instances of jobs. Executor backends implement Runner,
+// and leave queueing, admission, cancellation and recording results to a Queue
+type Runner interface {
+	Type() types.Executor
+	Parameters() Parameters
+	// Run runs an instance of a job until it exits or ctx is cancelled,
+	// setting any ExecutorAttributes on i. The Queue records everything else
+	Run(ctx context.Context, j *job.Spec, i *execution.Instance) error
+}
+
+// Checker is implemented by Runners that need to check more of a job than
+// its parameters before accepting it
+type Checker interface {
+	Check(j *job.Spec) error
+}
+
+// Queue holds the jobs registered with an executor, and fires them on their
+// schedule with its Runner
+type Queue struct {
+	sync.Mutex
+	runner   Runner
+	running  bool
+	queue    map[string]*job.Spec
+	next     map[string]time.Time
+	inflight map[uuid.UUID]*activeRun
+	held     map[string]string
+	slots    chan struct{}
+	store    storage.Store
+	log      *logrus.Entry
+	// Concurrency is how many instances can run at once
+	Concurrency int
+	// CancelCheckInterval is how often a running instance looks for a
+	// request to cancel it, made through the API on any node
+	CancelCheckInterval time.Duration
+}
+
+// NewQueue returns a Queue that runs jobs with r
+func NewQueue(backend storage.Store, r Runner) *Queue {
+	return &Queue{
+		runner:      r,
+		store:       backend,
+		queue:       map[string]*job.Spec{},
+		next:        map[string]time.Time{},
+		inflight:    map[uuid.UUID]*activeRun{},
+		held:        map[string]string{},
+		log:         log.WithFields(logrus.Fields{"executor": r.Type()}),
+		Concurrency: 1,
+
+		CancelCheckInterval: 5 * time.Second,
+	}
+}
+
+// Deregister deregisters a job from the queue
+func (q *Queue) Deregister(j *job.Spec) error {
+	q.Lock()
+	defer q.Unlock()
+	if _, ok := q.queue[j.ID.String()]; ok {
+		delete(q.queue, j.ID.String())
+		delete(q.next, j.ID.String())
+		delete(q.held, j.ID.String())
+		return nil
+	}
+	return ErrJobNotFound
+}
+
+// Register registers a job to be processed
+func (q *Queue) Register(j *job.Spec) error {
+	q.Lock()
+	defer q.Unlock()
+	if j.Executor != q.runner.Type() {
+		return ErrWrongExecutor
+	}
+	if err := Validate(q.runner.Parameters(), j.ExecutorParameters); err != nil {
+		return err
+	}
+	if c, ok := q.runner.(Checker); ok {
+		if err := c.Check(j); err != nil {
+			return err
+		}
+	}
+	q.queue[j.ID.String()] = j
+	delete(q.next, j.ID.String())
+	return nil
+}
+
+// Start runs the queue
+func (q *Queue) Start() {
+	q.Lock()
+	defer q.Unlock()
+	q.log.Info("Starting executor")
+	q.running = true
+	concurrency := q.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	q.slots = make(chan struct{}, concurrency)
+	go q.eventLoop()
+}
+
+// Stop stops the queue, cancelling any instances that are still running
+func (q *Queue) Stop() {
+	q.Lock()
+	defer q.Unlock()
+	q.log.Info("Stopping executor")
+	q.running = false
+	for id, run := range q.inflight {
+		q.log.WithFields(logrus.Fields{"instance": id}).Info("cancelling running instance")
+		run.cancelledBy = "executor shutdown"
+		run.cancel()
+	}
+}
+
+// activeRun is an instance that is currently running
+type activeRun struct {
+	cancel      context.CancelFunc
+	cancelledBy string
+}
+
+// Cancel stops a running instance
+func (q *Queue) Cancel(id uuid.UUID, by string) error {
+	q.Lock()
+	defer q.Unlock()
+	run, ok := q.inflight[id]
+	if !ok {
+		return ErrInstanceNotRunning
+	}
+	q.log.WithFields(logrus.Fields{"instance": id, "by": by}).Info("cancelling running instance")
+	run.cancelledBy = by
+	run.cancel()
+	return nil
+}
+
+func (q *Queue) eventLoop() {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		q.Lock()
+		if !q.running {
+			q.Unlock()
+			q.log.Info("Shutting down event loop")
+			return
+		}
+		runnables := q.due(now)
+		q.Unlock()
+
+		if len(runnables) > 0 {
+			q.log.WithFields(logrus.Fields{"jobs": len(runnables)}).Debug("jobs to run")
+			for _, j := range runnables {
+				if !Runnable(q.store, j, now) {
+					q.release(j)
+					continue
+				}
+				// the slot is taken in storage, so runs admitted by any
+				// executor, and runs still waiting for one of ours, count
+				i := execution.NewInstance(j.ID)
+				if err := q.store.ReserveRun(j, i.ID, now); err != nil {
+					q.log.WithFields(logrus.Fields{"job": j.ID.String()}).WithError(err).Debug("run not admitted")
+					q.hold(j, now, err)
+					continue
+				}
+				q.release(j)
+				go q.execute(j, i)
+			}
+		}
+	}
+}
+
+// due returns the jobs whose next fire time has passed. Must be called with the lock held
+func (q *Queue) due(now time.Time) []*job.Spec {
+	runnables := []*job.Spec{}
+	for k, j := range q.queue {
+		if j.Schedule() == nil {
+			continue
+		}
+		next, ok := q.next[k]
+		if !ok {
+			q.next[k] = j.Schedule().Next(now)
+			continue
+		}
+		if !now.Before(next) {
+			runnables = append(runnables, j)
+			q.next[k] = j.Schedule().Next(now)
+		}
+	}
+	return runnables
+}
+
+// hold keeps a due run in the queue to be retried on the next tick, and
+// records why on the job's state
+func (q *Queue) hold(j *job.Spec, now time.Time, reason error) {
+	q.Lock()
+	q.next[j.ID.String()] = now
+	changed := q.held[j.ID.String()] != reason.Error()
+	q.held[j.ID.String()] = reason.Error()
+	q.Unlock()
+
+	if changed {
+		q.log.WithFields(logrus.Fields{"job": j.ID.String(), "reason": reason}).Info("holding run")
+		if err := q.store.SetJobHeld(j.ID, reason.Error(), now); err != nil {
+			q.log.WithFields(logrus.Fields{"job": j.ID.String()}).WithError(err).Error("unable to record held run")
+		}
+	}
+}
+
+// release clears the held reason of a job that is no longer held
+func (q *Queue) release(j *job.Spec) {
+	q.Lock()
+	_, wasHeld := q.held[j.ID.String()]
+	delete(q.held, j.ID.String())
+	q.Unlock()
+
+	if wasHeld {
+		if err := q.store.SetJobHeld(j.ID, "", time.Time{}); err != nil {
+			q.log.WithFields(logrus.Fields{"job": j.ID.String()}).WithError(err).Error("unable to clear held run")
+		}
+	}
+}
+
+// execute runs an admitted instance of a job and records the result. A
+// heartbeat is recorded for it from the moment it is admitted, every
+// execution.HeartbeatInterval, so instances and admissions left behind by
+// an executor that died can be told apart from ones that are still running
+// or waiting for a slot
+func (q *Queue) execute(j *job.Spec, i *execution.Instance) {
+	logger := q.log.WithFields(logrus.Fields{"job": j.ID.String(), "instance": i.ID})
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go q.heartbeat(i, stop, stopped)
+	defer func() {
+		close(stop)
+		<-stopped
+		if err := q.store.DeleteHeartbeat(i.ID); err != nil {
+			logger.WithError(err).Error("unable to remove heartbeat")
+		}
+		if err := q.store.DeleteCancelRequest(i.ID); err != nil {
+			logger.WithError(err).Error("unable to remove cancel request")
+		}
+		if err := q.store.ReleaseRun(j.ID, i.ID); err != nil {
+			logger.WithError(err).Error("unable to release admitted run")
+		}
+	}()
+
+	q.slots <- struct{}{}
+	defer func() { <-q.slots }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	run := &activeRun{cancel: cancel}
+	q.Lock()
+	q.inflight[i.ID] = run
+	q.Unlock()
+
+	logger.Info("running instance")
+	err := q.run(ctx, j, i)
+
+	q.Lock()
+	delete(q.inflight, i.ID)
+	cancelledBy := run.cancelledBy
+	q.Unlock()
+
+	if cancelledBy != "" {
+		// cancelled runs are neither successes nor errors, and are not retried
+		logger.WithFields(logrus.Fields{"by": cancelledBy}).Info("instance cancelled")
+		i.Cancelled = true
+		i.CancelledBy = cancelledBy
+		i.CancelledAt = i.FinishedAt
+		if _, err := q.store.SetExecution(i); err != nil {
+			logger.WithError(err).Error("unable to record cancellation")
+		}
+		return
+	}
+	if err != nil {
+		logger.WithError(err).Error("instance failed")
+	}
+
+	if _, err := q.store.RecordRun(i); err != nil {
+		logger.WithError(err).Error("unable to update job after run")
+	}
+}
+
+// run stores the instance as started, runs it with the Runner and stores
+// how it finished
+func (q *Queue) run(ctx context.Context, j *job.Spec, i *execution.Instance) error {
+	i.StartedAt = time.Now()
+	if _, err := q.store.SetExecution(i); err != nil {
+		return err
+	}
+	err := q.runner.Run(ctx, j, i)
+	i.FinishedAt = time.Now()
+	i.Success = err == nil
+	if _, serr := q.store.SetExecution(i); serr != nil {
+		q.log.WithError(serr).Errorf("unable to store instance %s", i)
+	}
+	return err
+}
+
+// heartbeat records that an instance is alive until stop is closed, then
+// closes stopped. In between, it cancels the instance if that was requested
+// through a node other than this one
+func (q *Queue) heartbeat(i *execution.Instance, stop <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+	beat := time.NewTicker(execution.HeartbeatInterval)
+	defer beat.Stop()
+	interval := q.CancelCheckInterval
+	if interval <= 0 {
+		interval = execution.HeartbeatInterval
+	}
+	check := time.NewTicker(interval)
+	defer check.Stop()
+
+	q.beat(i, time.Now())
+	cancelled := false
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-beat.C:
+			q.beat(i, now)
+		case <-check.C:
+			if !cancelled {
+				cancelled = q.cancelIfRequested(i)
+			}
+		}
+	}
+}
+
+// beat records that an instance was alive at now
+func (q *Queue) beat(i *execution.Instance, now time.Time) {
+	if err := q.store.SetHeartbeat(i, now); err != nil {
+		q.log.WithError(err).Errorf("unable to record heartbeat of instance %s", i)
+	}
+}
+
+// cancelIfRequested cancels an instance if someone asked to, and returns
+// true once it is cancelled. An instance still waiting for a slot is
+// cancelled on a later check, once it runs
+func (q *Queue) cancelIfRequested(i *execution.Instance) bool {
+	by, err := q.store.GetCancelRequest(i.ID)
+	if err != nil {
+		q.log.WithError(err).Errorf("unable to check for a cancel request of instance %s", i)
+		return false
+	}
+	if by == "" {
+		return false
+	}
+	return q.Cancel(i.ID, by) == nil
+}
@@ -0,0 +1,42 @@
+package executor
+
+import (
+	"time"
+
+	"github.com/byxorna/flow/types/job"
+	"github.com/byxorna/flow/types/storage"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"module": "executor"})
+)
+
+// Runnable returns true if a job that is due may fire at time t. Jobs that are
+// disabled, paused, in a paused namespace or still running do not fire
+func Runnable(store storage.Store, j *job.Spec, t time.Time) bool {
+	logger := log.WithFields(logrus.Fields{"job": j.ID.String()})
+	if !j.Runnable(t) {
+		logger.Debug("job is not runnable, skipping")
+		return false
+	}
+	summary, err := store.GetJobSummary(j.ID)
+	if err != nil {
+		logger.WithError(err).Error("unable to check status of last run, skipping")
+		return false
+	}
+	if summary.Status == job.Running {
+		logger.Debug("last run is still running, skipping")
+		return false
+	}
+	p, err := store.GetNamespacePause(j.ID.Namespace)
+	if err != nil {
+		logger.WithError(err).Error("unable to check namespace pause, skipping")
+		return false
+	}
+	if p.Active(t) {
+		logger.WithFields(logrus.Fields{"paused_by": p.By}).Debug("namespace is paused, skipping")
+		return false
+	}
+	return true
+}
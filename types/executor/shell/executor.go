@@ -4,188 +4,137 @@ package shell
 // this is really only useful for debugging
 
 import (
+	"context"
 	"fmt"
-	"sync"
+	"os"
+	"os/exec"
+	"syscall"
 	"time"
 
 	"github.com/byxorna/flow/types"
+	"github.com/byxorna/flow/types/execution"
+	"github.com/byxorna/flow/types/executor"
 	"github.com/byxorna/flow/types/job"
 	"github.com/byxorna/flow/types/storage"
 	"github.com/sirupsen/logrus"
 )
 
 var (
-	// ErrWrongExecutor is returned when a job scheduled for another executor is attempted to be run
-	ErrWrongExecutor = fmt.Errorf("job is not configured to run with shell executor")
-	// ErrJobNotFound ...
-	ErrJobNotFound = fmt.Errorf("job not found in queue")
-	log            = logrus.WithFields(logrus.Fields{"module": "executor/shell"})
+	log = logrus.WithFields(logrus.Fields{"module": "executor/shell"})
 )
 
 // Executor is a shell executor
 type Executor struct {
-	sync.Mutex
-	running  bool
-	queue    map[string]*job.Spec
-	store    *storage.Store
-	Settings Parameters
+	*executor.Queue
+	store    storage.Store
+	Settings Settings
 }
 
-// Parameters is the type for shell executor parameters
-// TODO(gabe) this parameters type feels mad kludgy to me
-type Parameters struct {
-	// How many concurrent jobs the executor can run
-	Concurrency int
+// Settings controls the behavior of the shell executor
+type Settings struct {
+	// How long a cancelled command has to exit before it is killed
+	KillTimeout time.Duration
 }
 
-// New returns a new shell executor
-func New(backend *storage.Store) (*Executor, error) {
+// Parameters are the executor parameters a shell job can set
+type Parameters struct{}
 
-	log.Debug("loading all jobs")
-	jobs, err := backend.GetJobs("")
-	if err != nil {
-		return nil, err
-	}
-
-	queue := map[string]*job.Spec{}
-	for _, j := range jobs {
-		if j.Executor == types.ShellExecutor {
-			queue[j.ID.String()] = j
-		}
-	}
-
-	log.WithFields(logrus.Fields{"jobs": len(queue)}).Debug("loaded jobs")
-
-	return &Executor{
+// New returns a new shell executor. Jobs are handed to it with Register
+func New(backend storage.Store) (*Executor, error) {
+	e := &Executor{
 		store: backend,
-		queue: queue,
-		Settings: Parameters{
-			Concurrency: 1,
+		Settings: Settings{
+			KillTimeout: 10 * time.Second,
 		},
-	}, nil
-}
-
-// Deregister deregisters a job from the queue
-func (e *Executor) Deregister(j *job.Spec) error {
-	e.Lock()
-	defer e.Unlock()
-	if _, ok := e.queue[j.ID.String()]; ok {
-		delete(e.queue, j.ID.String())
-		return nil
 	}
-	return ErrJobNotFound
+	e.Queue = executor.NewQueue(backend, e)
+	return e, nil
 }
 
-// Register registers a job to be processed
-func (e *Executor) Register(j *job.Spec) error {
-	e.Lock()
-	defer e.Unlock()
-	if j.Executor != types.ShellExecutor {
-		return ErrWrongExecutor
-	}
-	e.queue[j.ID.String()] = j
-	return nil
+// String returns a string for the executor
+func (e *Executor) String() string {
+	return string(types.ShellExecutor)
 }
 
-/*
-// DefaultParameters is the default params for Shell Executors
-func (e *Executor) DefaultParameters() (executor.Parameters, error) {
-	return &Parameters{}, nil
+// Type returns the type of jobs this executor runs
+func (e *Executor) Type() types.Executor {
+	return types.ShellExecutor
 }
-*/
-
-/*
-// Run executes an instance of a job
-func (e *Executor) Run(instance *execution.Instance) error {
-	//TODO(gabe) make this handle N executions and delegate to workers!
-	log.WithFields(logrus.Fields{
-		"id":        instance.id,
-		"namespace": instance.Namespace,
-		"job":       instance.Job,
-	}).Infof("executing instance")
-
-	j, err := e.store.GetJob(instance.Namespace, instance.Job)
-	if err != nil {
-		return err
-	}
-	if j.Executor != e.Type() {
-		return ErrWrongExecutor
-	}
-	e.StartedAt = time.Now()
-	e.store.SetExecution(instance)
-	log.Infof("would have run: %s", j.String())
-
-	// when all done, update the execution
-	instance.FinishedAt = time.Now()
-	//TODO!!!
-	instance.Success = true
-	instance.Output = []byte{}
-	e.store.SetExecution(instance)
-
-	j, _ := e.store.GetJob(instance.Namespace, instance.Job)
-	//TODO!!! how do we ensure this locks around a job so we avoid concurrent modification?
-	j.SuccessCount += 1
-	e.store.SetJob(j)
-
-	return nil
+
+// Parameters returns the parameters shell jobs can set
+func (e *Executor) Parameters() executor.Parameters {
+	return &Parameters{}
 }
-*/
 
-// Type ...
+// Type returns the executor these parameters are for
 func (p *Parameters) Type() types.Executor {
 	return types.ShellExecutor
 }
 
-// Start runs the executor
-func (e *Executor) Start() {
-	e.Lock()
-	defer e.Unlock()
-	log.Info("Starting executor")
-	e.running = true
-	go e.eventLoop()
+// Fields returns the parameters a shell job can set
+func (p *Parameters) Fields() []executor.Field {
+	return []executor.Field{
+		{Name: "command", Type: executor.StringField, Required: true, Description: "command line run with sh -c"},
+	}
 }
 
-// Stop stop the executor
-func (e *Executor) Stop() {
-	e.Lock()
-	defer e.Unlock()
-	log.Info("Stopping executor")
-	e.running = false
+// Run executes an instance of a job locally with sh -c, storing stdout and
+// stderr as instance logs as they are written. Cancelling ctx signals the
+// command's process group to terminate.
+func (e *Executor) Run(ctx context.Context, j *job.Spec, i *execution.Instance) error {
+	hostname, _ := os.Hostname()
+	i.ExecutorAttributes = map[string]string{"host": hostname}
+
+	cmd := exec.Command("sh", "-c", j.ExecutorParameters["command"])
+	cmd.Env = os.Environ()
+	for k, v := range j.EnvVars {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
+	}
+	stdout := e.store.NewLogWriter(i, execution.Stdout)
+	defer stdout.Close()
+	stderr := e.store.NewLogWriter(i, execution.Stderr)
+	defer stderr.Close()
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// run in its own process group, so cancelling reaches anything the command spawns
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	err := e.wait(ctx, cmd)
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			i.ExecutorAttributes["exit_status"] = fmt.Sprintf("%d", status.ExitStatus())
+		}
+	} else if err == nil {
+		i.ExecutorAttributes["exit_status"] = "0"
+	}
+
+	return err
 }
 
-func (e *Executor) eventLoop() {
-	ticker := time.NewTicker(1 * time.Second)
-	for {
-		if !e.running {
-			log.Info("Shutting down event loop")
-			return
-		}
-		now := <-ticker.C
-
-		// find what needs to run next
-		runnables := []*job.Spec{}
-		for _, j := range e.queue {
-			log.WithFields(logrus.Fields{"job": j.ID.Name, "namespace": j.ID.Namespace}).Debug("evaluating if we should run job")
-			if j.Schedule() != nil {
-				log.WithFields(logrus.Fields{
-					"job":       j.ID.Name,
-					"namespace": j.ID.Namespace,
-					"schedule":  j.Schedule(),
-				}).
-					Debug("job has a schedule")
-				next := j.Schedule().Next(now)
-				if now.After(next) {
-					runnables = append(runnables, j)
-				}
-			}
-		}
+// wait starts cmd and waits for it to exit. If ctx is cancelled first, the
+// process group is sent SIGTERM, and SIGKILL if it is still around after KillTimeout
+func (e *Executor) wait(ctx context.Context, cmd *exec.Cmd) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
 
-		if len(runnables) > 0 {
-			log.WithFields(logrus.Fields{"jobs": len(runnables)}).Debug("jobs to run")
-			for _, j := range runnables {
-				log.Infof("running %s", j.ID)
-				// TODO handle the job!
-			}
-		}
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+
+	pgid := cmd.Process.Pid
+	log.WithFields(logrus.Fields{"pgid": pgid}).Debug("terminating process group")
+	syscall.Kill(-pgid, syscall.SIGTERM)
+	select {
+	case <-done:
+	case <-time.After(e.Settings.KillTimeout):
+		log.WithFields(logrus.Fields{"pgid": pgid}).Warn("process group did not exit, killing it")
+		syscall.Kill(-pgid, syscall.SIGKILL)
+		<-done
 	}
+	return ctx.Err()
 }
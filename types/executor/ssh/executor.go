@@ -0,0 +1,256 @@
+package ssh
+
+// ssh executor runs job commands on remote hosts we cannot install an agent on
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"time"
+
+	"github.com/byxorna/flow/types"
+	"github.com/byxorna/flow/types/execution"
+	"github.com/byxorna/flow/types/executor"
+	"github.com/byxorna/flow/types/job"
+	"github.com/byxorna/flow/types/storage"
+	"github.com/sirupsen/logrus"
+	xssh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	// DefaultPort is the ssh port used when a job does not specify one
+	DefaultPort = "22"
+)
+
+var (
+	// ErrNoHostKey is returned for jobs that do not say how to verify their remote host
+	ErrNoHostKey = fmt.Errorf("host_key is required when the server has no ssh known hosts file")
+	// ErrNoKeyDir is returned when running a job on a server without an ssh key directory
+	ErrNoKeyDir = fmt.Errorf("the server has no ssh key directory to read keys from")
+	log         = logrus.WithFields(logrus.Fields{"module": "executor/ssh"})
+)
+
+// DialFunc opens a client connection to an ssh server
+type DialFunc func(network, addr string, config *xssh.ClientConfig) (*xssh.Client, error)
+
+// Executor is an ssh executor
+type Executor struct {
+	*executor.Queue
+	store    storage.Store
+	Settings Settings
+	// Dial is used to connect to remote hosts. Defaults to ssh.Dial, but
+	// can be swapped out to talk to an in-process server
+	Dial DialFunc
+}
+
+// Settings controls the behavior of the ssh executor
+type Settings struct {
+	// How long to wait for a remote host to accept a connection
+	ConnectTimeout time.Duration
+	// KeyDir holds the private keys jobs can authenticate with. A job's key
+	// parameter names a file in it, so jobs cannot read anything else
+	KeyDir string
+	// KnownHostsFile verifies the hosts of jobs that do not set host_key
+	KnownHostsFile string
+}
+
+// Parameters are the executor parameters an ssh job can set
+type Parameters struct{}
+
+// Type returns the executor these parameters are for
+func (p *Parameters) Type() types.Executor {
+	return types.SSHExecutor
+}
+
+// Fields returns the parameters an ssh job can set
+func (p *Parameters) Fields() []executor.Field {
+	return []executor.Field{
+		{Name: "host", Type: executor.StringField, Required: true, Description: "remote host to connect to"},
+		{Name: "port", Type: executor.IntField, Default: DefaultPort, Description: "ssh port of the remote host"},
+		{Name: "user", Type: executor.StringField, Required: true, Description: "user to log in as"},
+		{Name: "key", Type: executor.StringField, Required: true, Description: "name of the private key in the server's ssh key directory used to authenticate"},
+		{Name: "host_key", Type: executor.StringField, Description: "expected public key of the remote host, in authorized_keys format. Required unless the server has a known hosts file"},
+		{Name: "command", Type: executor.StringField, Required: true, Description: "command to run on the remote host"},
+	}
+}
+
+// params are the job's executor parameters parsed for use
+type params struct {
+	Host    string
+	Port    string
+	User    string
+	Key     string
+	HostKey string
+	Command string
+}
+
+// New returns a new ssh executor. Jobs are handed to it with Register
+func New(backend storage.Store) (*Executor, error) {
+	e := &Executor{
+		store: backend,
+		Dial:  xssh.Dial,
+		Settings: Settings{
+			ConnectTimeout: 10 * time.Second,
+		},
+	}
+	e.Queue = executor.NewQueue(backend, e)
+	return e, nil
+}
+
+// String returns a string for the executor
+func (e *Executor) String() string {
+	return string(types.SSHExecutor)
+}
+
+// Type returns the type of jobs this executor runs
+func (e *Executor) Type() types.Executor {
+	return types.SSHExecutor
+}
+
+// Parameters returns the parameters ssh jobs can set
+func (e *Executor) Parameters() executor.Parameters {
+	return &Parameters{}
+}
+
+// Check makes sure a job names a key in the key directory rather than a
+// path, and that its remote host can be verified
+func (e *Executor) Check(j *job.Spec) error {
+	p := parseParams(j.ExecutorParameters)
+	if p.Key != filepath.Base(p.Key) || p.Key == "." || p.Key == ".." {
+		return &executor.ValidationError{
+			Executor: types.SSHExecutor,
+			Fields:   []executor.FieldError{{Field: "key", Message: "must be the name of a key in the server's ssh key directory, not a path"}},
+		}
+	}
+	if p.HostKey == "" {
+		if e.Settings.KnownHostsFile == "" {
+			return ErrNoHostKey
+		}
+		return nil
+	}
+	if _, _, _, _, err := xssh.ParseAuthorizedKey([]byte(p.HostKey)); err != nil {
+		return &executor.ValidationError{
+			Executor: types.SSHExecutor,
+			Fields:   []executor.FieldError{{Field: "host_key", Message: fmt.Sprintf("unable to parse: %s", err)}},
+		}
+	}
+	return nil
+}
+
+// Run executes an instance of a job on its remote host, storing stdout and
+// stderr as instance logs as they arrive. Cancelling ctx closes the session.
+func (e *Executor) Run(ctx context.Context, j *job.Spec, i *execution.Instance) error {
+	if err := e.Check(j); err != nil {
+		return err
+	}
+	p := parseParams(j.ExecutorParameters)
+	cfg, err := e.clientConfig(p)
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(p.Host, p.Port)
+	i.ExecutorAttributes = map[string]string{"host": addr, "user": p.User}
+
+	client, err := e.Dial("tcp", addr, cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	for k, v := range j.EnvVars {
+		if err := session.Setenv(k, v); err != nil {
+			log.WithFields(logrus.Fields{"job": j.ID.String(), "var": k}).Debug("remote host refused env var")
+		}
+	}
+	stdout := e.store.NewLogWriter(i, execution.Stdout)
+	defer stdout.Close()
+	stderr := e.store.NewLogWriter(i, execution.Stderr)
+	defer stderr.Close()
+	session.Stdout = stdout
+	session.Stderr = stderr
+
+	done := make(chan error, 1)
+	go func() { done <- session.Run(p.Command) }()
+
+	select {
+	case err = <-done:
+		return exitResult(i, err)
+	case <-ctx.Done():
+		session.Close()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// exitResult records the remote exit status on the instance
+func exitResult(i *execution.Instance, err error) error {
+	status := 0
+	if exitErr, ok := err.(*xssh.ExitError); ok {
+		status = exitErr.ExitStatus()
+	} else if err != nil {
+		return err
+	}
+	i.ExecutorAttributes["exit_status"] = fmt.Sprintf("%d", status)
+	return err
+}
+
+func parseParams(m map[string]string) *params {
+	m = executor.WithDefaults(&Parameters{}, m)
+	return &params{
+		Host:    m["host"],
+		Port:    m["port"],
+		User:    m["user"],
+		Key:     m["key"],
+		HostKey: m["host_key"],
+		Command: m["command"],
+	}
+}
+
+// clientConfig builds the ssh client config, authenticating with the job's
+// key from the key directory, and verifying the remote host with the job's
+// host_key or else the known hosts file
+func (e *Executor) clientConfig(p *params) (*xssh.ClientConfig, error) {
+	if e.Settings.KeyDir == "" {
+		return nil, ErrNoKeyDir
+	}
+	pem, err := ioutil.ReadFile(filepath.Join(e.Settings.KeyDir, p.Key))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read ssh key %s: %s", p.Key, err)
+	}
+	signer, err := xssh.ParsePrivateKey(pem)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse ssh key %s: %s", p.Key, err)
+	}
+
+	var hostKeyCallback xssh.HostKeyCallback
+	if p.HostKey != "" {
+		hostKey, _, _, _, err := xssh.ParseAuthorizedKey([]byte(p.HostKey))
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse host_key: %s", err)
+		}
+		hostKeyCallback = xssh.FixedHostKey(hostKey)
+	} else if e.Settings.KnownHostsFile != "" {
+		if hostKeyCallback, err = knownhosts.New(e.Settings.KnownHostsFile); err != nil {
+			return nil, fmt.Errorf("unable to read known hosts: %s", err)
+		}
+	} else {
+		return nil, ErrNoHostKey
+	}
+
+	return &xssh.ClientConfig{
+		User:            p.User,
+		Auth:            []xssh.AuthMethod{xssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         e.Settings.ConnectTimeout,
+	}, nil
+}
@@ -0,0 +1,291 @@
+package ssh
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/byxorna/flow/types"
+	"github.com/byxorna/flow/types/execution"
+	"github.com/byxorna/flow/types/executor"
+	"github.com/byxorna/flow/types/job"
+	"github.com/byxorna/flow/types/storage"
+	xssh "golang.org/x/crypto/ssh"
+)
+
+// testServer is an in-process ssh server that runs a few fake commands
+type testServer struct {
+	addr    string
+	hostKey xssh.PublicKey
+}
+
+func newKey(t *testing.T) (*ecdsa.PrivateKey, xssh.Signer) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := xssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, signer
+}
+
+// startServer starts a server that accepts only clientKey. It stops when l is closed
+func startServer(t *testing.T, l net.Listener, clientKey xssh.PublicKey) *testServer {
+	_, hostSigner := newKey(t)
+	cfg := &xssh.ServerConfig{
+		PublicKeyCallback: func(c xssh.ConnMetadata, k xssh.PublicKey) (*xssh.Permissions, error) {
+			if c.User() == "flow" && string(k.Marshal()) == string(clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, os.ErrPermission
+		},
+	}
+	cfg.AddHostKey(hostSigner)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveConn(conn, cfg)
+		}
+	}()
+	return &testServer{addr: l.Addr().String(), hostKey: hostSigner.PublicKey()}
+}
+
+func serveConn(conn net.Conn, cfg *xssh.ServerConfig) {
+	_, chans, reqs, err := xssh.NewServerConn(conn, cfg)
+	if err != nil {
+		return
+	}
+	go xssh.DiscardRequests(reqs)
+	for nc := range chans {
+		ch, chReqs, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+		go serveSession(ch, chReqs)
+	}
+}
+
+// serveSession runs "echo <words>", "fail" and "sleep" commands
+func serveSession(ch xssh.Channel, reqs <-chan *xssh.Request) {
+	defer ch.Close()
+	for req := range reqs {
+		if req.Type != "exec" {
+			req.Reply(req.Type == "env", nil)
+			continue
+		}
+		var payload struct{ Command string }
+		xssh.Unmarshal(req.Payload, &payload)
+		req.Reply(true, nil)
+
+		status := 0
+		switch {
+		case strings.HasPrefix(payload.Command, "echo "):
+			ch.Write([]byte(strings.TrimPrefix(payload.Command, "echo ") + "\n"))
+		case payload.Command == "fail":
+			ch.Stderr().Write([]byte("oops\n"))
+			status = 3
+		case payload.Command == "sleep":
+			// wait for the client to hang up
+			for range reqs {
+			}
+			return
+		}
+		ch.SendRequest("exit-status", false, xssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+		return
+	}
+}
+
+// newExecutor returns an executor with a key directory holding a key the
+// returned server accepts, and a func to clean both up
+func newExecutor(t *testing.T) (*Executor, *storage.KVStore, *testServer, func()) {
+	key, signer := newKey(t)
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "flow-ssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup := func() {
+		l.Close()
+		os.RemoveAll(dir)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "flow"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	store := storage.NewMemory()
+	e, err := New(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Settings.KeyDir = dir
+	return e, store, startServer(t, l, signer.PublicKey()), cleanup
+}
+
+func testJob(srv *testServer, command string) *job.Spec {
+	host, port, _ := net.SplitHostPort(srv.addr)
+	return &job.Spec{
+		ID:       job.ID{Namespace: "default", Name: "remote"},
+		Owner:    "flow",
+		Executor: types.SSHExecutor,
+		ExecutorParameters: map[string]string{
+			"host":     host,
+			"port":     port,
+			"user":     "flow",
+			"key":      "flow",
+			"host_key": string(xssh.MarshalAuthorizedKey(srv.hostKey)),
+			"command":  command,
+		},
+	}
+}
+
+func output(t *testing.T, store *storage.KVStore, i *execution.Instance, stream string) string {
+	chunks, err := store.GetLogs(i.ID, stream, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out string
+	for _, c := range chunks {
+		out += string(c.Data)
+	}
+	return out
+}
+
+func TestRun(t *testing.T) {
+	e, store, srv, cleanup := newExecutor(t)
+	defer cleanup()
+
+	i := execution.NewInstance(job.ID{Namespace: "default", Name: "remote"})
+	if err := e.Run(context.Background(), testJob(srv, "echo hello"), i); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if i.ExecutorAttributes["exit_status"] != "0" {
+		t.Errorf("expected exit status 0, got %q", i.ExecutorAttributes["exit_status"])
+	}
+	if out := output(t, store, i, execution.Stdout); out != "hello\n" {
+		t.Errorf("expected stdout %q, got %q", "hello\n", out)
+	}
+
+	i = execution.NewInstance(job.ID{Namespace: "default", Name: "remote"})
+	if err := e.Run(context.Background(), testJob(srv, "fail"), i); err == nil {
+		t.Fatal("expected a failing command to return an error")
+	}
+	if i.ExecutorAttributes["exit_status"] != "3" {
+		t.Errorf("expected exit status 3, got %q", i.ExecutorAttributes["exit_status"])
+	}
+	if out := output(t, store, i, execution.Stderr); out != "oops\n" {
+		t.Errorf("expected stderr %q, got %q", "oops\n", out)
+	}
+}
+
+func TestRunCancel(t *testing.T) {
+	e, _, srv, cleanup := newExecutor(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- e.Run(ctx, testJob(srv, "sleep"), execution.NewInstance(job.ID{Namespace: "default", Name: "remote"}))
+	}()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("expected %s, got %v", context.Canceled, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("run did not stop after being cancelled")
+	}
+}
+
+func TestRunWrongHostKey(t *testing.T) {
+	e, _, srv, cleanup := newExecutor(t)
+	defer cleanup()
+	_, other := newKey(t)
+
+	j := testJob(srv, "echo hello")
+	j.ExecutorParameters["host_key"] = string(xssh.MarshalAuthorizedKey(other.PublicKey()))
+	err := e.Run(context.Background(), j, execution.NewInstance(j.ID))
+	if err == nil || !strings.Contains(err.Error(), "host key mismatch") {
+		t.Errorf("expected a host key mismatch, got %v", err)
+	}
+}
+
+func TestRunKnownHosts(t *testing.T) {
+	e, _, srv, cleanup := newExecutor(t)
+	defer cleanup()
+	j := testJob(srv, "echo hello")
+	delete(j.ExecutorParameters, "host_key")
+
+	host, port, _ := net.SplitHostPort(srv.addr)
+	line := "[" + host + "]:" + port + " " + string(xssh.MarshalAuthorizedKey(srv.hostKey))
+	e.Settings.KnownHostsFile = filepath.Join(e.Settings.KeyDir, "known_hosts")
+	if err := ioutil.WriteFile(e.Settings.KnownHostsFile, []byte(line), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Run(context.Background(), j, execution.NewInstance(j.ID)); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	e, _, srv, cleanup := newExecutor(t)
+	defer cleanup()
+
+	if err := e.Register(testJob(srv, "echo hello")); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	j := testJob(srv, "echo hello")
+	delete(j.ExecutorParameters, "host_key")
+	if err := e.Register(j); err != ErrNoHostKey {
+		t.Errorf("expected %s without a host key or known hosts, got %v", ErrNoHostKey, err)
+	}
+	e.Settings.KnownHostsFile = "/etc/ssh/ssh_known_hosts"
+	if err := e.Register(j); err != nil {
+		t.Errorf("expected known hosts to stand in for host_key, got %s", err)
+	}
+
+	for _, key := range []string{"/etc/passwd", "../flow", "keys/flow", ".."} {
+		j := testJob(srv, "echo hello")
+		j.ExecutorParameters["key"] = key
+		if _, ok := e.Register(j).(*executor.ValidationError); !ok {
+			t.Errorf("expected key %q to be rejected", key)
+		}
+	}
+
+	j = testJob(srv, "echo hello")
+	j.ExecutorParameters["shell"] = "bash"
+	if _, ok := e.Register(j).(*executor.ValidationError); !ok {
+		t.Error("expected an unknown parameter to be rejected")
+	}
+
+	j = testJob(srv, "echo hello")
+	j.Executor = types.ShellExecutor
+	if err := e.Register(j); err != executor.ErrWrongExecutor {
+		t.Errorf("expected %s, got %v", executor.ErrWrongExecutor, err)
+	}
+}
@@ -0,0 +1,100 @@
+package job
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// diffIgnored are fields set by storage that are not part of a job's definition
+var diffIgnored = map[string]bool{"resource_version": true}
+
+// FieldChange is a difference in one field between two versions of a job
+type FieldChange struct {
+	// Field is the JSON path of the field, i.e. executor_parameters.command
+	Field string      `json:"field"`
+	Old   interface{} `json:"old,omitempty"`
+	New   interface{} `json:"new,omitempty"`
+}
+
+// Diff returns the fields that differ between two versions of a job, ordered
+// by field. A nil job compares as an empty one, so diffing against nil lists
+// every field that is set
+func Diff(old, updated *Spec) ([]FieldChange, error) {
+	before, err := flatten(old)
+	if err != nil {
+		return nil, err
+	}
+	after, err := flatten(updated)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []FieldChange{}
+	for k, v := range before {
+		if nv, ok := after[k]; !ok || !reflect.DeepEqual(v, nv) {
+			changes = append(changes, FieldChange{Field: k, Old: v, New: after[k]})
+		}
+	}
+	for k, v := range after {
+		if _, ok := before[k]; !ok {
+			changes = append(changes, FieldChange{Field: k, New: v})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes, nil
+}
+
+// flatten returns the JSON fields of a job keyed by their dotted path. Arrays
+// are compared as a whole
+func flatten(j *Spec) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+	if j == nil {
+		return fields, nil
+	}
+	b, err := json.Marshal(j)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	flattenInto(fields, "", doc)
+	return fields, nil
+}
+
+func flattenInto(fields map[string]interface{}, prefix string, doc map[string]interface{}) {
+	for k, v := range doc {
+		if prefix == "" && diffIgnored[k] {
+			continue
+		}
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
+		if m, ok := v.(map[string]interface{}); ok {
+			flattenInto(fields, path, m)
+			continue
+		}
+		fields[path] = v
+	}
+}
+
+// NextRuns returns up to n times the job's schedule fires after t. The job
+// must be validated first
+func (j *Spec) NextRuns(t time.Time, n int) []time.Time {
+	runs := []time.Time{}
+	if j.Schedule() == nil {
+		return runs
+	}
+	for len(runs) < n {
+		t = j.Schedule().Next(t)
+		if t.IsZero() {
+			break
+		}
+		runs = append(runs, t)
+	}
+	return runs
+}
@@ -0,0 +1,36 @@
+package job
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// PausesPath is the path in storage where namespace pauses are stored
+	PausesPath = "pauses"
+)
+
+// Pause records who paused a job or namespace, when and why
+type Pause struct {
+	// By is who paused it
+	By string `json:"by"`
+	// At is when it was paused
+	At time.Time `json:"at"`
+	// Reason is an optional explanation
+	Reason string `json:"reason,omitempty"`
+	// Until is when the pause lifts on its own. Nil pauses until resumed
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// Active returns true if the pause is in effect at time t
+func (p *Pause) Active(t time.Time) bool {
+	if p == nil {
+		return false
+	}
+	return p.Until == nil || t.Before(*p.Until)
+}
+
+// NamespacePausePath returns the path to a namespace's pause in the storage system
+func NamespacePausePath(keyspace string, namespace string) string {
+	return fmt.Sprintf("%s/%s/%s", keyspace, PausesPath, namespace)
+}
@@ -0,0 +1,47 @@
+package job
+
+import (
+	"fmt"
+	"time"
+)
+
+// Retention limits how much execution history of a job is kept. Zero
+// values are unset, and fall back to the namespace, then to the server default
+type Retention struct {
+	// KeepGroups is how many of the most recent execution groups to keep
+	KeepGroups int `json:"keep_groups,omitempty"`
+
+	// MaxAgeDays is how many days an execution group is kept after it ran
+	MaxAgeDays int `json:"max_age_days,omitempty"`
+}
+
+// Validate checks retention limits are not negative
+func (r *Retention) Validate() error {
+	if r.KeepGroups < 0 {
+		return fmt.Errorf("retention keep_groups cannot be negative")
+	}
+	if r.MaxAgeDays < 0 {
+		return fmt.Errorf("retention max_age_days cannot be negative")
+	}
+	return nil
+}
+
+// MaxAge returns MaxAgeDays as a duration, or 0 if it is unset
+func (r Retention) MaxAge() time.Duration {
+	return time.Duration(r.MaxAgeDays) * 24 * time.Hour
+}
+
+// Or returns r, with any unset values taken from fallback
+func (r *Retention) Or(fallback Retention) Retention {
+	if r == nil {
+		return fallback
+	}
+	merged := *r
+	if merged.KeepGroups == 0 {
+		merged.KeepGroups = fallback.KeepGroups
+	}
+	if merged.MaxAgeDays == 0 {
+		merged.MaxAgeDays = fallback.MaxAgeDays
+	}
+	return merged
+}
@@ -3,7 +3,6 @@ package job
 import (
 	"fmt"
 	"sync"
-	"time"
 
 	"github.com/byxorna/flow/types"
 	"github.com/robfig/cron"
@@ -24,7 +23,7 @@ var (
 )
 
 // Spec is a Job specification that is provided via API
-// to define a job
+// to define a job. Its runtime state is kept separately, see State
 type Spec struct {
 	// ID the name of the job
 	ID ID `json:"ID"`
@@ -35,24 +34,15 @@ type Spec struct {
 	// Disabled
 	Disabled bool `json:"disabled"`
 
+	// Pause is set while the job is paused through the API
+	Pause *Pause `json:"pause,omitempty"`
+
 	// EnvVars are extra env vars to inject into job
 	EnvVars map[string]string `json:"env_vars,omitempty"`
 
 	// Owner of the job.
 	Owner string `json:"owner"`
 
-	// Number of successful executions of this job.
-	SuccessCount int `json:"success_count"`
-
-	// Number of errors running this job.
-	ErrorCount int `json:"error_count"`
-
-	// Last time this job executed succesful.
-	LastSuccess time.Time `json:"last_success"`
-
-	// Last time this job failed.
-	LastError time.Time `json:"last_error"`
-
 	// Jobs that are dependent upon this one will be run after this job runs.
 	DependentJobs []ID `json:"dependent_jobs,omitempty"`
 
@@ -77,6 +67,14 @@ type Spec struct {
 	// Labels are labels to identify this job
 	Labels map[string]string `json:"labels,omitempty"`
 
+	// Retention limits the execution history kept for this job. Unset
+	// values fall back to the namespace's
+	Retention *Retention `json:"retention,omitempty"`
+
+	// ResourceVersion is the storage version this spec was read at. It is
+	// set by the storage layer and is not stored with the job
+	ResourceVersion uint64 `json:"resource_version,omitempty"`
+
 	running sync.Mutex
 }
 
@@ -124,6 +122,12 @@ func (j *Spec) Validate() error {
 		return fmt.Errorf("must specify an explicit executor")
 	}
 
+	if j.Retention != nil {
+		if err := j.Retention.Validate(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
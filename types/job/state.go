@@ -0,0 +1,68 @@
+package job
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// StatesPath is the path in storage where job runtime state is stored
+	StatesPath = "status"
+)
+
+// State is the runtime state of a job. It is owned by the scheduler and
+// stored apart from the Spec, so clients cannot write it and updating it
+// does not contend with spec changes
+type State struct {
+	// Number of successful executions of this job.
+	SuccessCount int `json:"success_count"`
+
+	// Number of errors running this job.
+	ErrorCount int `json:"error_count"`
+
+	// Last time this job executed succesful.
+	LastSuccess time.Time `json:"last_success"`
+
+	// Last time this job failed.
+	LastError time.Time `json:"last_error"`
+
+	// HeldReason is why a due run is being held instead of started, i.e. the
+	// namespace quota is exceeded. Empty when nothing is held
+	HeldReason string `json:"held_reason,omitempty"`
+
+	// HeldSince is when the held run was first due
+	HeldSince *time.Time `json:"held_since,omitempty"`
+
+	// Latest is how the job's most recent execution group went, or is
+	// going. Storage updates it whenever an instance is stored, so the status
+	// of a job can be read without reading its executions
+	Latest *Latest `json:"latest,omitempty"`
+
+	// CountersMigrated is the storage version of the spec whose legacy run
+	// counters were added to this state, so they are never added twice
+	CountersMigrated uint64 `json:"counters_migrated,omitempty"`
+}
+
+// Latest is the outcome of a job's most recent execution group
+type Latest struct {
+	Group      int64     `json:"group"`
+	Status     Status    `json:"status"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+	// Instances is how each instance of the group went, by instance ID, so
+	// storing one instance updates the outcome without reading the others
+	Instances map[string]Outcome `json:"instances,omitempty"`
+}
+
+// Outcome is how a single instance of an execution group went
+type Outcome struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+	Success    bool      `json:"success,omitempty"`
+	Cancelled  bool      `json:"cancelled,omitempty"`
+}
+
+// StatePath returns the path to a job's runtime state in the storage system
+func StatePath(keyspace string, id ID) string {
+	return fmt.Sprintf("%s/%s/%s/%s", keyspace, StatesPath, id.Namespace, id.Name)
+}
@@ -1,33 +1,77 @@
 package job
 
-// Status ...
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Status is how a job's last execution group went
 type Status uint8
 
 const (
-	// Pending ...
+	// Pending is a job that has not run yet
 	Pending Status = iota
-	// Running ...
+	// Running is a job with an instance of its last group still running
 	Running
-	// Success ...
+	// Success is a job whose last group all succeeded
 	Success
-	// Failed ...
+	// Failed is a job whose last group all failed
 	Failed
-	// PartiallyFailed ...
+	// PartiallyFailed is a job whose last group had some instances fail
 	PartiallyFailed
+	// Cancelled is a job whose last group had an instance cancelled
+	Cancelled
 )
 
-// Status returns the Spec's status of last run
-func (j *Spec) Status() Status {
-	// TODO get all executions for last run of this job
-	// and figure out what status it is...
-	return Pending
+var statusNames = map[Status]string{
+	Pending:         "pending",
+	Running:         "running",
+	Success:         "success",
+	Failed:          "failed",
+	PartiallyFailed: "partially_failed",
+	Cancelled:       "cancelled",
 }
 
-func (j *Spec) isRunnable() bool {
-	switch status := j.Status(); status {
-	case Running:
-		return false
-	default:
-		return j.Disabled || true
+// String returns the name of the status
+func (s Status) String() string {
+	if n, ok := statusNames[s]; ok {
+		return n
 	}
+	return fmt.Sprintf("unknown(%d)", uint8(s))
+}
+
+// ParseStatus returns the Status with the given name
+func ParseStatus(name string) (Status, error) {
+	for s, n := range statusNames {
+		if n == name {
+			return s, nil
+		}
+	}
+	return Pending, fmt.Errorf("unknown status %q", name)
+}
+
+// MarshalJSON encodes the status by name
+func (s Status) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// UnmarshalJSON decodes a status name
+func (s *Status) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err != nil {
+		return err
+	}
+	st, err := ParseStatus(name)
+	if err != nil {
+		return err
+	}
+	*s = st
+	return nil
+}
+
+// Runnable returns true if the job may fire at time t. Whether its last run is
+// still going is up to the executor to check
+func (j *Spec) Runnable(t time.Time) bool {
+	return !j.Disabled && !j.Pause.Active(t)
 }
@@ -0,0 +1,209 @@
+package labels
+
+// label selectors pick jobs by their labels, in the same syntax kubernetes uses:
+//   env=prod,tier!=frontend,region in (us-east,us-west),!legacy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Operator is how a requirement compares a label
+type Operator string
+
+const (
+	// Equals requires the label to be set to the value
+	Equals Operator = "="
+	// NotEquals requires the label to be unset, or set to another value
+	NotEquals Operator = "!="
+	// In requires the label to be set to one of the values
+	In Operator = "in"
+	// NotIn requires the label to be unset, or set to none of the values
+	NotIn Operator = "notin"
+	// Exists requires the label to be set
+	Exists Operator = "exists"
+	// DoesNotExist requires the label to be unset
+	DoesNotExist Operator = "!"
+)
+
+// Requirement is a single condition on a label
+type Requirement struct {
+	Key      string
+	Operator Operator
+	Values   []string
+}
+
+// Matches reports whether labels satisfy the requirement
+func (r Requirement) Matches(labels map[string]string) bool {
+	v, ok := labels[r.Key]
+	switch r.Operator {
+	case Equals, In:
+		return ok && contains(r.Values, v)
+	case NotEquals, NotIn:
+		return !ok || !contains(r.Values, v)
+	case Exists:
+		return ok
+	case DoesNotExist:
+		return !ok
+	}
+	return false
+}
+
+// String formats the requirement in selector syntax
+func (r Requirement) String() string {
+	switch r.Operator {
+	case Equals, NotEquals:
+		return r.Key + string(r.Operator) + r.Values[0]
+	case In, NotIn:
+		return fmt.Sprintf("%s %s (%s)", r.Key, r.Operator, strings.Join(r.Values, ","))
+	case DoesNotExist:
+		return "!" + r.Key
+	}
+	return r.Key
+}
+
+// Selector is a set of requirements that must all match
+type Selector []Requirement
+
+// Matches reports whether labels satisfy every requirement. An empty selector matches everything
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, r := range s {
+		if !r.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// String formats the selector in selector syntax
+func (s Selector) String() string {
+	parts := make([]string, len(s))
+	for i, r := range s {
+		parts[i] = r.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+// Parse parses a comma separated list of requirements. Each is one of
+//
+//	key=value, key==value, key!=value, key in (v1,v2), key notin (v1,v2), key, !key
+func Parse(selector string) (Selector, error) {
+	s := Selector{}
+	terms, err := split(selector)
+	if err != nil {
+		return nil, err
+	}
+	for _, term := range terms {
+		r, err := parseRequirement(term)
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, r)
+	}
+	return s, nil
+}
+
+// split breaks a selector on the commas that are not inside a value set
+func split(selector string) ([]string, error) {
+	terms := []string{}
+	depth := 0
+	start := 0
+	for i, c := range selector {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("unbalanced ) in selector %q", selector)
+			}
+		case ',':
+			if depth == 0 {
+				terms = append(terms, selector[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced ( in selector %q", selector)
+	}
+	terms = append(terms, selector[start:])
+
+	// an empty selector has no terms, but an empty term, as left by a
+	// trailing comma, is a mistake
+	if len(terms) == 1 && strings.TrimSpace(terms[0]) == "" {
+		return []string{}, nil
+	}
+	for i, t := range terms {
+		if terms[i] = strings.TrimSpace(t); terms[i] == "" {
+			return nil, fmt.Errorf("empty requirement in selector %q", selector)
+		}
+	}
+	return terms, nil
+}
+
+func parseRequirement(term string) (Requirement, error) {
+	if strings.HasPrefix(term, "!") && !strings.Contains(term, "=") {
+		return requirement(strings.TrimSpace(term[1:]), DoesNotExist, nil, term)
+	}
+	if i := strings.Index(term, "!="); i >= 0 {
+		return requirement(term[:i], NotEquals, []string{term[i+2:]}, term)
+	}
+	if i := strings.Index(term, "=="); i >= 0 {
+		return requirement(term[:i], Equals, []string{term[i+2:]}, term)
+	}
+	if i := strings.Index(term, "="); i >= 0 {
+		return requirement(term[:i], Equals, []string{term[i+1:]}, term)
+	}
+
+	fields := strings.Fields(term)
+	if len(fields) == 1 {
+		return requirement(fields[0], Exists, nil, term)
+	}
+	if len(fields) < 2 {
+		return Requirement{}, fmt.Errorf("invalid requirement %q", term)
+	}
+	op := Operator(fields[1])
+	if op != In && op != NotIn {
+		return Requirement{}, fmt.Errorf("invalid operator %q in requirement %q", fields[1], term)
+	}
+	set := strings.TrimSpace(strings.Join(fields[2:], " "))
+	if !strings.HasPrefix(set, "(") || !strings.HasSuffix(set, ")") {
+		return Requirement{}, fmt.Errorf("%s requires a set of values like (a,b) in requirement %q", op, term)
+	}
+	values := []string{}
+	for _, v := range strings.Split(set[1:len(set)-1], ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	if len(values) == 0 {
+		return Requirement{}, fmt.Errorf("%s requires at least one value in requirement %q", op, term)
+	}
+	sort.Strings(values)
+	return requirement(fields[0], op, values, term)
+}
+
+func requirement(key string, op Operator, values []string, term string) (Requirement, error) {
+	key = strings.TrimSpace(key)
+	if key == "" || strings.ContainsAny(key, " ()!=,") {
+		return Requirement{}, fmt.Errorf("invalid label key in requirement %q", term)
+	}
+	for i, v := range values {
+		values[i] = strings.TrimSpace(v)
+		if strings.ContainsAny(values[i], " ()!=,") {
+			return Requirement{}, fmt.Errorf("invalid label value in requirement %q", term)
+		}
+	}
+	return Requirement{Key: key, Operator: op, Values: values}, nil
+}
+
+func contains(values []string, v string) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package labels
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSelectorMatches(t *testing.T) {
+	labels := map[string]string{"env": "prod", "tier": "web", "region": "us-east"}
+	for _, tc := range []struct {
+		selector string
+		match    bool
+	}{
+		{"", true},
+		{"env=prod", true},
+		{"env==prod", true},
+		{"env=dev", false},
+		{"env!=dev", true},
+		{"env!=prod", false},
+		{"missing!=prod", true},
+		{"region in (us-east,us-west)", true},
+		{"region in (eu-west)", false},
+		{"missing in (a)", false},
+		{"region notin (us-east,us-west)", false},
+		{"region notin (eu-west)", true},
+		{"missing notin (a)", true},
+		{"tier", true},
+		{"missing", false},
+		{"!missing", true},
+		{"!tier", false},
+		{"env=prod, tier in (web,api), !legacy", true},
+		{"env=prod,tier=api", false},
+	} {
+		s, err := Parse(tc.selector)
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", tc.selector, err)
+			continue
+		}
+		if got := s.Matches(labels); got != tc.match {
+			t.Errorf("%q: expected match %t, got %t", tc.selector, tc.match, got)
+		}
+	}
+}
+
+func TestSelectorString(t *testing.T) {
+	s, err := Parse("env==prod, region notin (b,a),tier,!legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.String(), "env=prod,region notin (a,b),tier,!legacy"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tc := range []struct {
+		selector string
+		err      string
+	}{
+		{"=prod", "invalid label key"},
+		{"!=prod", "invalid label key"},
+		{"!", "invalid label key"},
+		{"region in (a,b", "unbalanced ("},
+		{"region in a,b)", "unbalanced )"},
+		{"env=prod,", "empty requirement"},
+		{"env=prod,,tier", "empty requirement"},
+		{"region in (a,)", ""},
+		{"region in ()", "at least one value"},
+		{"region in a", "requires a set of values"},
+		{"region has (a)", "invalid operator"},
+		{"env=pr od", "invalid label value"},
+	} {
+		_, err := Parse(tc.selector)
+		if tc.err == "" {
+			if err != nil {
+				t.Errorf("%q: unexpected error %s", tc.selector, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%q: expected an error containing %q, got %v", tc.selector, tc.err, err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package namespace
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/byxorna/flow/types/job"
+	"github.com/google/uuid"
+)
+
+const (
+	// AdmissionsPath is the path in storage where the runs admitted under
+	// each namespace's quota are kept
+	AdmissionsPath = "admissions"
+)
+
+// Admissions are the runs of a namespace admitted under its quota. They are
+// kept in a single record per namespace, so every executor admitting runs
+// of the namespace compares and swaps the same record, and none can admit a
+// run another one already took the last slot for
+type Admissions struct {
+	// Running are the runs admitted that have not finished, including ones
+	// still waiting for an executor slot
+	Running []Admitted `json:"running,omitempty"`
+	// Started is when each run of the last hour was admitted
+	Started []time.Time `json:"started,omitempty"`
+}
+
+// Admitted is a run admitted under a namespace quota
+type Admitted struct {
+	Instance uuid.UUID `json:"instance"`
+	Job      job.ID    `json:"job"`
+	At       time.Time `json:"at"`
+}
+
+// Usage returns how much of the quota the admitted runs use. Jobs are not counted
+func (a *Admissions) Usage() Usage {
+	return Usage{Running: len(a.Running), RunsLastHour: len(a.Started)}
+}
+
+// Admit counts a run against the quota of n, or returns a QuotaError if the
+// namespace is at one of its limits
+func (a *Admissions) Admit(n *Namespace, instance uuid.UUID, id job.ID, t time.Time) error {
+	if err := n.AllowsRun(a.Usage()); err != nil {
+		return err
+	}
+	a.Running = append(a.Running, Admitted{Instance: instance, Job: id, At: t})
+	a.Started = append(a.Started, t)
+	return nil
+}
+
+// Release stops counting a run as running, and returns false if it was not
+func (a *Admissions) Release(instance uuid.UUID) bool {
+	for k, r := range a.Running {
+		if r.Instance == instance {
+			a.Running = append(a.Running[:k], a.Running[k+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Expire forgets runs admitted over an hour before t, and running runs that
+// alive says are gone
+func (a *Admissions) Expire(t time.Time, alive func(Admitted) (bool, error)) error {
+	hourAgo := t.Add(-time.Hour)
+	started := a.Started[:0]
+	for _, s := range a.Started {
+		if s.After(hourAgo) {
+			started = append(started, s)
+		}
+	}
+	a.Started = started
+	running := a.Running[:0]
+	for _, r := range a.Running {
+		ok, err := alive(r)
+		if err != nil {
+			return err
+		}
+		if ok {
+			running = append(running, r)
+		}
+	}
+	a.Running = running
+	return nil
+}
+
+// AdmissionPath returns the path to the admissions of a namespace
+func AdmissionPath(keyspace string, name string) string {
+	return fmt.Sprintf("%s/%s/%s", keyspace, AdmissionsPath, name)
+}
@@ -0,0 +1,107 @@
+package namespace
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/byxorna/flow/types/job"
+)
+
+const (
+	// StoragePath is the path in storage where namespaces are stored
+	StoragePath = "namespaces"
+)
+
+var (
+	// ErrNameRequired is returned for a namespace without a name
+	ErrNameRequired = fmt.Errorf("namespace requires a name")
+	// ErrOwnerRequired is returned for a namespace without an owner
+	ErrOwnerRequired = fmt.Errorf("namespace requires an owner")
+)
+
+// Namespace groups jobs owned by a team, and holds defaults for those jobs
+type Namespace struct {
+	// Name is the namespace jobs refer to in their ID
+	Name string `json:"name"`
+
+	// Owner is the team that owns the namespace
+	Owner string `json:"owner"`
+
+	// Contact is how to reach the owner, i.e. an email address or chat channel
+	Contact string `json:"contact,omitempty"`
+
+	// DefaultLabels are added to every job in the namespace that does not set them
+	DefaultLabels map[string]string `json:"default_labels,omitempty"`
+
+	// DefaultEnvVars are added to every job in the namespace that does not set them
+	DefaultEnvVars map[string]string `json:"default_env_vars,omitempty"`
+
+	// DefaultConstraints are added to every job in the namespace that does not set them
+	DefaultConstraints map[string]string `json:"default_constraints,omitempty"`
+
+	// Quota limits the jobs and runs of the namespace. Nil is unlimited
+	Quota *Quota `json:"quota,omitempty"`
+
+	// Retention limits the execution history kept for jobs in the namespace
+	// that do not set their own
+	Retention *job.Retention `json:"retention,omitempty"`
+
+	// ResourceVersion is the storage version this namespace was read at. It is
+	// set by the storage layer and is not stored with the namespace
+	ResourceVersion uint64 `json:"resource_version,omitempty"`
+}
+
+// Validate checks a namespace can be stored
+func (n *Namespace) Validate() error {
+	if n.Name == "" {
+		return ErrNameRequired
+	}
+	if strings.Contains(n.Name, "/") {
+		return fmt.Errorf("namespace name %q cannot contain /", n.Name)
+	}
+	if n.Owner == "" {
+		return ErrOwnerRequired
+	}
+	if n.Retention != nil {
+		if err := n.Retention.Validate(); err != nil {
+			return err
+		}
+	}
+	if n.Quota != nil {
+		return n.Quota.Validate()
+	}
+	return nil
+}
+
+// Apply merges the namespace defaults into a job. Values the job sets itself win
+func (n *Namespace) Apply(j *job.Spec) {
+	j.Labels = merge(n.DefaultLabels, j.Labels)
+	j.EnvVars = merge(n.DefaultEnvVars, j.EnvVars)
+	j.ExecutorConstraints = merge(n.DefaultConstraints, j.ExecutorConstraints)
+}
+
+// Labels returns the labels of a job with the namespace's default labels
+// merged in, without changing the job
+func (n *Namespace) Labels(j *job.Spec) map[string]string {
+	return merge(n.DefaultLabels, j.Labels)
+}
+
+// merge returns overrides on top of defaults
+func merge(defaults map[string]string, overrides map[string]string) map[string]string {
+	if len(defaults) == 0 {
+		return overrides
+	}
+	m := make(map[string]string, len(defaults)+len(overrides))
+	for k, v := range defaults {
+		m[k] = v
+	}
+	for k, v := range overrides {
+		m[k] = v
+	}
+	return m
+}
+
+// Path returns the path to a namespace in the storage system
+func Path(keyspace string, name string) string {
+	return fmt.Sprintf("%s/%s/%s", keyspace, StoragePath, name)
+}
@@ -0,0 +1,67 @@
+package namespace
+
+import (
+	"fmt"
+)
+
+// Quota limits how much a namespace can use the shared executors. A zero
+// limit is unlimited
+type Quota struct {
+	// MaxJobs is how many jobs the namespace can hold
+	MaxJobs int `json:"max_jobs,omitempty"`
+	// MaxConcurrentRuns is how many instances can be running at once
+	MaxConcurrentRuns int `json:"max_concurrent_runs,omitempty"`
+	// MaxRunsPerHour is how many instances can be started in any hour
+	MaxRunsPerHour int `json:"max_runs_per_hour,omitempty"`
+}
+
+// Usage is what a namespace currently uses of its quota
+type Usage struct {
+	Jobs         int `json:"jobs"`
+	Running      int `json:"running"`
+	RunsLastHour int `json:"runs_last_hour"`
+}
+
+// QuotaError is returned when a namespace is at one of its limits
+type QuotaError struct {
+	Namespace string
+	Limit     string
+	Max       int
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("quota exceeded: namespace %s is at its limit of %d %s", e.Namespace, e.Max, e.Limit)
+}
+
+// Validate checks the limits make sense
+func (q *Quota) Validate() error {
+	if q.MaxJobs < 0 || q.MaxConcurrentRuns < 0 || q.MaxRunsPerHour < 0 {
+		return fmt.Errorf("quota limits cannot be negative")
+	}
+	return nil
+}
+
+// AllowsJob returns a QuotaError if the namespace cannot hold another job
+func (n *Namespace) AllowsJob(u Usage) error {
+	if n.Quota == nil {
+		return nil
+	}
+	if n.Quota.MaxJobs > 0 && u.Jobs >= n.Quota.MaxJobs {
+		return &QuotaError{Namespace: n.Name, Limit: "jobs", Max: n.Quota.MaxJobs}
+	}
+	return nil
+}
+
+// AllowsRun returns a QuotaError if the namespace cannot start another instance
+func (n *Namespace) AllowsRun(u Usage) error {
+	if n.Quota == nil {
+		return nil
+	}
+	if n.Quota.MaxConcurrentRuns > 0 && u.Running >= n.Quota.MaxConcurrentRuns {
+		return &QuotaError{Namespace: n.Name, Limit: "concurrent runs", Max: n.Quota.MaxConcurrentRuns}
+	}
+	if n.Quota.MaxRunsPerHour > 0 && u.RunsLastHour >= n.Quota.MaxRunsPerHour {
+		return &QuotaError{Namespace: n.Name, Limit: "runs per hour", Max: n.Quota.MaxRunsPerHour}
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package rbac
+
+import (
+	"fmt"
+)
+
+const (
+	// StoragePath is the path in storage where the policy is stored
+	StoragePath = "rbac"
+	// AllNamespaces scopes a role to every namespace
+	AllNamespaces = "*"
+)
+
+// Verb is an operation a role can allow
+type Verb string
+
+const (
+	// Get reads a job, namespace, or the runs of a job
+	Get Verb = "get"
+	// List lists jobs or namespaces
+	List Verb = "list"
+	// Create creates a job or namespace
+	Create Verb = "create"
+	// Update changes a job or namespace
+	Update Verb = "update"
+	// Delete deletes a job or namespace
+	Delete Verb = "delete"
+	// Run starts a job outside its schedule
+	Run Verb = "run"
+	// Cancel stops a running instance
+	Cancel Verb = "cancel"
+	// Pause pauses and resumes jobs or namespaces
+	Pause Verb = "pause"
+	// Admin changes cluster wide settings, like the policy itself. It is only
+	// granted by roles scoped to all namespaces
+	Admin Verb = "admin"
+)
+
+// Verbs are all the verbs a role can allow
+var Verbs = []Verb{Get, List, Create, Update, Delete, Run, Cancel, Pause, Admin}
+
+// Role is a set of verbs allowed in a set of namespaces
+type Role struct {
+	Name       string   `json:"name"`
+	Verbs      []Verb   `json:"verbs"`
+	Namespaces []string `json:"namespaces"`
+}
+
+// Binding grants a role to principals, and to members of groups
+type Binding struct {
+	Role       string   `json:"role"`
+	Principals []string `json:"principals,omitempty"`
+	Groups     []string `json:"groups,omitempty"`
+}
+
+// Policy is the roles and who they are granted to
+type Policy struct {
+	Roles    []Role    `json:"roles"`
+	Bindings []Binding `json:"bindings"`
+}
+
+// Subject is who is asking to do something
+type Subject struct {
+	Name   string
+	Groups []string
+}
+
+// Validate checks every verb is known and every binding names a role
+func (p *Policy) Validate() error {
+	roles := map[string]bool{}
+	for _, r := range p.Roles {
+		if r.Name == "" {
+			return fmt.Errorf("roles must have a name")
+		}
+		if roles[r.Name] {
+			return fmt.Errorf("role %s is defined more than once", r.Name)
+		}
+		roles[r.Name] = true
+		for _, v := range r.Verbs {
+			if !contains(verbNames(), string(v)) {
+				return fmt.Errorf("role %s has unknown verb %q", r.Name, v)
+			}
+		}
+		if len(r.Namespaces) == 0 {
+			return fmt.Errorf("role %s must list namespaces, or %q for all of them", r.Name, AllNamespaces)
+		}
+	}
+	for _, b := range p.Bindings {
+		if !roles[b.Role] {
+			return fmt.Errorf("binding refers to unknown role %s", b.Role)
+		}
+		if len(b.Principals) == 0 && len(b.Groups) == 0 {
+			return fmt.Errorf("binding for role %s grants it to nobody", b.Role)
+		}
+	}
+	return nil
+}
+
+// Allowed returns true if a subject may do verb in namespace
+func (p *Policy) Allowed(s Subject, verb Verb, namespace string) bool {
+	for _, b := range p.Bindings {
+		if !b.binds(s) {
+			continue
+		}
+		for _, r := range p.Roles {
+			if r.Name == b.Role && r.allows(verb, namespace) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (b *Binding) binds(s Subject) bool {
+	if contains(b.Principals, s.Name) {
+		return true
+	}
+	for _, g := range s.Groups {
+		if contains(b.Groups, g) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Role) allows(verb Verb, namespace string) bool {
+	if !contains(verbNamesOf(r.Verbs), string(verb)) {
+		return false
+	}
+	if contains(r.Namespaces, AllNamespaces) {
+		return true
+	}
+	// admin is cluster wide, so namespace scoped roles cannot grant it
+	return verb != Admin && contains(r.Namespaces, namespace)
+}
+
+// Path returns the path to the policy in the storage system
+func Path(keyspace string) string {
+	return fmt.Sprintf("%s/%s/policy", keyspace, StoragePath)
+}
+
+func verbNames() []string {
+	return verbNamesOf(Verbs)
+}
+
+func verbNamesOf(verbs []Verb) []string {
+	names := make([]string, len(verbs))
+	for i, v := range verbs {
+		names[i] = string(v)
+	}
+	return names
+}
+
+func contains(values []string, v string) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/byxorna/flow/types/execution"
+	"github.com/byxorna/flow/types/job"
+	"github.com/byxorna/flow/types/namespace"
+	"github.com/docker/libkv/store"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// ReserveRun admits a run of a job under the quota of its namespace, and
+// holds a slot for the instance until ReleaseRun is called or the instance
+// goes without a heartbeat for execution.LostAfter. It returns a
+// *namespace.QuotaError if the namespace is at one of its limits. Runs of
+// namespaces without a run quota are not counted
+func (s *KVStore) ReserveRun(j *job.Spec, instance uuid.UUID, now time.Time) error {
+	n, err := s.GetNamespace(j.ID.Namespace)
+	if err == store.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if n.Quota == nil || (n.Quota.MaxConcurrentRuns == 0 && n.Quota.MaxRunsPerHour == 0) {
+		return nil
+	}
+	return s.updateAdmissions(n.Name, now, func(a *namespace.Admissions) (bool, error) {
+		if err := a.Admit(n, instance, j.ID, now); err != nil {
+			return false, err
+		}
+		return true, nil
+	})
+}
+
+// ReleaseRun frees the slot held for an instance by ReserveRun, once it
+// finished or will not run after all
+func (s *KVStore) ReleaseRun(id job.ID, instance uuid.UUID) error {
+	exists, err := s.Client.Exists(namespace.AdmissionPath(s.keyspace, id.Namespace))
+	if err != nil || !exists {
+		return err
+	}
+	return s.updateAdmissions(id.Namespace, time.Now(), func(a *namespace.Admissions) (bool, error) {
+		return a.Release(instance), nil
+	})
+}
+
+// updateAdmissions applies update to the admissions of a namespace with a
+// compare-and-swap, after forgetting the ones that expired by now. Nothing is
+// written if update returns false. Namespaces that have no admissions stored
+// yet start from the runs stored for their jobs
+func (s *KVStore) updateAdmissions(name string, now time.Time, update func(*namespace.Admissions) (bool, error)) error {
+	path := namespace.AdmissionPath(s.keyspace, name)
+	for attempt := 0; attempt < maxAtomicAttempts; attempt++ {
+		var a namespace.Admissions
+		pair, err := s.Client.Get(path)
+		if err != nil && err != store.ErrKeyNotFound {
+			return err
+		}
+		if pair != nil {
+			if err := json.Unmarshal(pair.Value, &a); err != nil {
+				return err
+			}
+		} else {
+			jobs, err := s.GetJobs(name)
+			if err != nil {
+				return err
+			}
+			if a.Running, a.Started, err = s.namespaceRuns(jobs, now); err != nil {
+				return err
+			}
+		}
+		if err := a.Expire(now, s.admittedAlive(now)); err != nil {
+			return err
+		}
+		ok, err := update(&a)
+		if err != nil || !ok {
+			return err
+		}
+		aJSON, err := json.Marshal(&a)
+		if err != nil {
+			return err
+		}
+
+		// a nil previous pair only succeeds if there is still nothing stored
+		_, _, err = s.Client.AtomicPut(path, aJSON, pair, nil)
+		if err == store.ErrKeyModified || err == store.ErrKeyExists {
+			log.WithFields(logrus.Fields{"namespace": name, "attempt": attempt}).Debug("store: Admissions modified concurrently, retrying")
+			backoff(attempt)
+			continue
+		}
+		return err
+	}
+	return ErrConflict
+}
+
+// admittedAlive returns a func that tells whether an admitted run still
+// holds its slot at now: it was admitted recently, or its executor is still
+// recording heartbeats for it
+func (s *KVStore) admittedAlive(now time.Time) func(namespace.Admitted) (bool, error) {
+	return func(r namespace.Admitted) (bool, error) {
+		heartbeat, err := s.getHeartbeat(r.Instance)
+		if err != nil {
+			return false, err
+		}
+		last := r.At
+		if heartbeat.After(last) {
+			last = heartbeat
+		}
+		return now.Sub(last) <= execution.LostAfter, nil
+	}
+}
+
+// namespaceRuns returns the instances of jobs that are running and not lost
+// at now, and when the ones of the last hour started
+func (s *KVStore) namespaceRuns(jobs []*job.Spec, now time.Time) ([]namespace.Admitted, []time.Time, error) {
+	running := []namespace.Admitted{}
+	started := []time.Time{}
+	hourAgo := now.Add(-time.Hour)
+	for _, j := range jobs {
+		instances, err := s.GetExecutions(j.ID)
+		if err != nil {
+			if err == store.ErrKeyNotFound {
+				continue
+			}
+			return nil, nil, err
+		}
+		for _, i := range instances {
+			if i.StartedAt.IsZero() {
+				continue
+			}
+			if i.StartedAt.After(hourAgo) {
+				started = append(started, i.StartedAt)
+			}
+			if !i.FinishedAt.IsZero() {
+				continue
+			}
+			heartbeat, err := s.getHeartbeat(i.ID)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !i.Lost(heartbeat, now) {
+				running = append(running, namespace.Admitted{Instance: i.ID, Job: j.ID, At: i.StartedAt})
+			}
+		}
+	}
+	return running, started, nil
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/byxorna/flow/types/execution"
+	"github.com/byxorna/flow/types/namespace"
+	"github.com/google/uuid"
+)
+
+func setQuota(t *testing.T, s *KVStore, q namespace.Quota) {
+	if err := s.SetNamespace(&namespace.Namespace{Name: "default", Owner: "test", Quota: &q}, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReserveRunConcurrent(t *testing.T) {
+	s := NewMemory()
+	setQuota(t, s, namespace.Quota{MaxConcurrentRuns: 2})
+	a := newTestJob(t, s, "a")
+	b := newTestJob(t, s, "b")
+	// another executor sharing the same storage
+	other := &KVStore{Client: s.Client, backend: s.backend}
+	now := time.Now()
+
+	first := uuid.New()
+	if err := s.ReserveRun(a, first, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.ReserveRun(b, uuid.New(), now); err != nil {
+		t.Fatal(err)
+	}
+	err := s.ReserveRun(a, uuid.New(), now)
+	if _, ok := err.(*namespace.QuotaError); !ok {
+		t.Fatalf("expected a quota error with both slots taken, got %v", err)
+	}
+
+	if err := s.ReleaseRun(a.ID, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.ReserveRun(a, uuid.New(), now); err != nil {
+		t.Errorf("expected the released slot to be free, got %s", err)
+	}
+}
+
+func TestReserveRunExpires(t *testing.T) {
+	s := NewMemory()
+	setQuota(t, s, namespace.Quota{MaxConcurrentRuns: 1})
+	j := newTestJob(t, s, "a")
+	now := time.Now()
+
+	// admitted, then its executor went away without releasing it
+	gone := uuid.New()
+	if err := s.ReserveRun(j, gone, now.Add(-2*execution.LostAfter)); err != nil {
+		t.Fatal(err)
+	}
+	// still waiting for a slot, but its executor keeps recording heartbeats
+	waiting := execution.NewInstance(j.ID)
+	if err := s.ReserveRun(j, waiting.ID, now.Add(-execution.LostAfter/2)); err != nil {
+		t.Fatalf("expected the lost admission to have expired, got %s", err)
+	}
+	if err := s.SetHeartbeat(waiting, now); err != nil {
+		t.Fatal(err)
+	}
+	err := s.ReserveRun(j, uuid.New(), now.Add(execution.LostAfter))
+	if _, ok := err.(*namespace.QuotaError); !ok {
+		t.Errorf("expected a waiting run with a heartbeat to keep its slot, got %v", err)
+	}
+}
+
+func TestReserveRunPerHour(t *testing.T) {
+	s := NewMemory()
+	setQuota(t, s, namespace.Quota{MaxRunsPerHour: 2})
+	j := newTestJob(t, s, "a")
+	now := time.Now()
+
+	for n := 0; n < 2; n++ {
+		id := uuid.New()
+		if err := s.ReserveRun(j, id, now.Add(time.Duration(n)*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.ReleaseRun(j.ID, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := s.ReserveRun(j, uuid.New(), now.Add(30*time.Minute))
+	if _, ok := err.(*namespace.QuotaError); !ok {
+		t.Errorf("expected a quota error for the third run in an hour, got %v", err)
+	}
+	if err := s.ReserveRun(j, uuid.New(), now.Add(61*time.Minute)); err != nil {
+		t.Errorf("expected the first run to have left the hour, got %s", err)
+	}
+}
+
+func TestNamespaceUsageSkipsLost(t *testing.T) {
+	s := NewMemory()
+	now := time.Now()
+	alive := startInstance(t, s, newTestJob(t, s, "alive"), now.Add(-time.Hour))
+	if err := s.SetHeartbeat(alive, now); err != nil {
+		t.Fatal(err)
+	}
+	startInstance(t, s, newTestJob(t, s, "lost"), now.Add(-time.Hour))
+
+	u, err := s.GetNamespaceUsage("default", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Running != 1 {
+		t.Errorf("expected only the instance with a heartbeat to be running, got %d", u.Running)
+	}
+}
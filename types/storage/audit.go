@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/byxorna/flow/types/audit"
+	"github.com/docker/libkv/store"
+	"github.com/sirupsen/logrus"
+)
+
+// AppendAuditEvent stores an audit event. Events are never overwritten
+func (s *KVStore) AppendAuditEvent(e *audit.Event) error {
+	eJSON, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	// a nil previous pair only succeeds if the key does not exist yet
+	_, _, err = s.Client.AtomicPut(e.Path(s.keyspace), eJSON, nil, nil)
+	return err
+}
+
+// GetAuditEvents returns the newest audit events that pass the filter and
+// match, up to limit of them, newest first. A limit of 0 returns every one.
+// Events are keyed newest first, so listing starts at the filter's Until and
+// stops at its Since or once limit events were found
+func (s *KVStore) GetAuditEvents(f *audit.Filter, limit int, match func(*audit.Event) bool) ([]*audit.Event, error) {
+	after := ""
+	if !f.Until.IsZero() {
+		after = audit.TimeKey(f.Until)
+	}
+	events := []*audit.Event{}
+	err := s.walkLeaves(fmt.Sprintf("%s/%s", s.keyspace, audit.StoragePath), after, func(entry *store.KVPair) (bool, error) {
+		if len(entry.Value) == 0 {
+			return true, nil
+		}
+		var e audit.Event
+		if err := json.Unmarshal(entry.Value, &e); err != nil {
+			return false, err
+		}
+		if !f.Since.IsZero() && e.Time.Before(f.Since) {
+			// every event after this one is older still
+			return false, nil
+		}
+		if f.Matches(&e) && (match == nil || match(&e)) {
+			events = append(events, &e)
+		}
+		return limit == 0 || len(events) < limit, nil
+	})
+	return events, err
+}
+
+// PruneAuditEvents deletes audit events older than t, and returns how many
+// were deleted. Only events older than t are read
+func (s *KVStore) PruneAuditEvents(t time.Time) (int, error) {
+	pruned := 0
+	err := s.walkLeaves(fmt.Sprintf("%s/%s", s.keyspace, audit.StoragePath), audit.TimeKey(t), func(entry *store.KVPair) (bool, error) {
+		var e audit.Event
+		if err := json.Unmarshal(entry.Value, &e); err != nil {
+			log.WithFields(logrus.Fields{"key": entry.Key}).WithError(err).Warn("store: Skipping unreadable audit event")
+			return true, nil
+		}
+		if !e.Time.Before(t) {
+			return true, nil
+		}
+		if err := s.Client.Delete(entry.Key); err != nil && err != store.ErrKeyNotFound {
+			return false, err
+		}
+		pruned++
+		return true, nil
+	})
+	return pruned, err
+}
+
+// migrateAuditKeys moves audit events stored under keys that sort oldest
+// first to keys that sort newest first. The event is written under its new
+// key before the old one is deleted, so an interrupted migration loses
+// nothing, and running it again finishes the move
+func migrateAuditKeys(s *KVStore, dryRun bool) ([]string, error) {
+	entries, err := s.leaves(fmt.Sprintf("%s/%s", s.keyspace, audit.StoragePath))
+	if err == store.ErrKeyNotFound {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	changes := []string{}
+	for _, entry := range entries {
+		var e audit.Event
+		if err := json.Unmarshal(entry.Value, &e); err != nil {
+			changes = append(changes, fmt.Sprintf("audit event %s: skipped, cannot be parsed: %s", entry.Key, err))
+			continue
+		}
+		key := e.Path(s.keyspace)
+		if path.Base(key) == path.Base(entry.Key) {
+			continue
+		}
+		changes = append(changes, fmt.Sprintf("audit event %s: move to %s", entry.Key, key))
+		if dryRun {
+			continue
+		}
+		// a nil previous pair only succeeds if the key does not exist yet
+		if _, _, err := s.Client.AtomicPut(key, entry.Value, nil, nil); err != nil && err != store.ErrKeyExists {
+			return nil, fmt.Errorf("unable to move audit event %s: %s", entry.Key, err)
+		}
+		if err := s.Client.Delete(entry.Key); err != nil && err != store.ErrKeyNotFound {
+			return nil, fmt.Errorf("unable to move audit event %s: %s", entry.Key, err)
+		}
+	}
+	return changes, nil
+}
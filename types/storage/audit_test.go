@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/byxorna/flow/types/audit"
+)
+
+// appendEvents stores an event by principal pN every minute from start
+func appendEvents(t *testing.T, s *KVStore, start time.Time, n int) {
+	for i := 0; i < n; i++ {
+		e := audit.NewEvent(fmt.Sprintf("p%d", i), "", audit.Update, audit.JobResource)
+		e.Time = start.Add(time.Duration(i) * time.Minute)
+		if err := s.AppendAuditEvent(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func principals(events []*audit.Event) string {
+	names := []string{}
+	for _, e := range events {
+		names = append(names, e.Principal)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestGetAuditEvents(t *testing.T) {
+	ranged := NewMemory()
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	appendEvents(t, ranged, start, 5)
+	listed := &KVStore{Client: listOnly{ranged.Client}, backend: ranged.backend}
+
+	notP3 := func(e *audit.Event) bool { return e.Principal != "p3" }
+	for name, s := range map[string]*KVStore{"ranged": ranged, "listed": listed} {
+		for _, tc := range []struct {
+			filter audit.Filter
+			limit  int
+			match  func(*audit.Event) bool
+			want   string
+		}{
+			{audit.Filter{}, 0, nil, "p4,p3,p2,p1,p0"},
+			{audit.Filter{}, 2, nil, "p4,p3"},
+			{audit.Filter{}, 2, notP3, "p4,p2"},
+			{audit.Filter{Until: start.Add(2 * time.Minute)}, 2, nil, "p2,p1"},
+			{audit.Filter{Since: start.Add(3 * time.Minute)}, 0, nil, "p4,p3"},
+			{audit.Filter{Principal: "p1"}, 0, nil, "p1"},
+		} {
+			events, err := s.GetAuditEvents(&tc.filter, tc.limit, tc.match)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := principals(events); got != tc.want {
+				t.Errorf("%s %+v limit %d: expected %s, got %s", name, tc.filter, tc.limit, tc.want, got)
+			}
+		}
+	}
+}
+
+func TestPruneAuditEvents(t *testing.T) {
+	s := NewMemory()
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	appendEvents(t, s, start, 5)
+
+	n, err := s.PruneAuditEvents(start.Add(2 * time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 events pruned, got %d", n)
+	}
+	events, err := s.GetAuditEvents(&audit.Filter{}, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := principals(events); got != "p4,p3,p2" {
+		t.Errorf("expected p4,p3,p2 to be left, got %s", got)
+	}
+}
+
+func TestMigrateAuditKeys(t *testing.T) {
+	s := NewMemory()
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		// the keys events had when they sorted oldest first
+		e := audit.NewEvent(fmt.Sprintf("p%d", i), "", audit.Update, audit.JobResource)
+		e.Time = start.Add(time.Duration(i) * time.Minute)
+		b, err := json.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Client.Put(fmt.Sprintf("%s/%s/%019d-%s", s.keyspace, audit.StoragePath, e.Time.UnixNano(), e.ID), b, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Client.Put(fmt.Sprintf("%s/%s/garbage", s.keyspace, audit.StoragePath), []byte("{"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := migrateAuditKeys(s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 4 || !strings.Contains(changes[len(changes)-1], "skipped") {
+		t.Errorf("expected 3 moves and a skipped corrupt event, got %v", changes)
+	}
+	if err := s.Client.Delete(fmt.Sprintf("%s/%s/garbage", s.keyspace, audit.StoragePath)); err != nil {
+		t.Fatal(err)
+	}
+	events, err := s.GetAuditEvents(&audit.Filter{}, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := principals(events); got != "p2,p1,p0" {
+		t.Errorf("expected every event moved and listed newest first, got %s", got)
+	}
+	if changes, err := migrateAuditKeys(s, false); err != nil || len(changes) != 0 {
+		t.Errorf("expected nothing left to migrate, got %v, %v", changes, err)
+	}
+}
@@ -0,0 +1,304 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/byxorna/flow/config"
+	"github.com/byxorna/flow/types"
+	"github.com/byxorna/flow/types/audit"
+	"github.com/byxorna/flow/types/job"
+	"github.com/byxorna/flow/types/namespace"
+	"github.com/byxorna/flow/types/storage/etcdv3"
+	"github.com/docker/libkv/store"
+	"github.com/google/uuid"
+)
+
+// The conformance tests check that the Store behaves the same on every
+// backend flow supports, where the libkv backends underneath disagree on
+// listing, compare-and-swap and watches. They run against memkv and a
+// temporary boltdb file always, and against etcd, over both the v2 and v3
+// APIs, when FLOW_TEST_ETCD_ENDPOINTS is set to a comma separated list of
+// host:port endpoints. Each etcd test works in a keyspace of its own, which
+// is deleted afterwards
+
+// etcdEndpointsEnv names the etcd cluster the conformance tests run against
+const etcdEndpointsEnv = "FLOW_TEST_ETCD_ENDPOINTS"
+
+// conformanceBackend opens an empty Store, and returns a func to clean it up
+type conformanceBackend func(t *testing.T) (*KVStore, func())
+
+func conformanceBackends() map[string]conformanceBackend {
+	backends := map[string]conformanceBackend{
+		"memory": func(t *testing.T) (*KVStore, func()) {
+			return NewMemory(), func() {}
+		},
+		"boltdb": func(t *testing.T) (*KVStore, func()) {
+			dir, err := ioutil.TempDir("", "flow-bolt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var c config.Config
+			c.StorageBackend = string(store.BOLTDB)
+			c.BoltDBPath = filepath.Join(dir, "flow.db")
+			s := openConformance(t, c)
+			return s, func() {
+				s.Client.Close()
+				os.RemoveAll(dir)
+			}
+		},
+	}
+	if endpoints := os.Getenv(etcdEndpointsEnv); endpoints != "" {
+		for _, backend := range []store.Backend{store.ETCD, etcdv3.ETCDV3} {
+			backend := backend
+			backends[string(backend)] = func(t *testing.T) (*KVStore, func()) {
+				var c config.Config
+				c.StorageBackend = string(backend)
+				c.EtcdEndpoints = strings.Split(endpoints, ",")
+				c.EtcdPrefix = "/flow-test-" + uuid.New().String()
+				s := openConformance(t, c)
+				return s, func() {
+					s.Client.DeleteTree(s.keyspace)
+					s.Client.Close()
+				}
+			}
+		}
+	}
+	return backends
+}
+
+func openConformance(t *testing.T, c config.Config) *KVStore {
+	if err := c.ValidateAndSetStorageDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// conformance runs test against a fresh Store on every backend
+func conformance(t *testing.T, test func(t *testing.T, s *KVStore)) {
+	for name, open := range conformanceBackends() {
+		open := open
+		t.Run(name, func(t *testing.T) {
+			s, cleanup := open(t)
+			defer cleanup()
+			test(t, s)
+		})
+	}
+}
+
+func conformanceJob(t *testing.T, s *KVStore, ns, name string) *job.Spec {
+	j := &job.Spec{
+		ID:                 job.ID{Namespace: ns, Name: name},
+		Owner:              "test",
+		ScheduleString:     "@every 1h",
+		Executor:           types.ShellExecutor,
+		ExecutorParameters: map[string]string{"command": "true"},
+	}
+	if err := s.SetJob(j); err != nil {
+		t.Fatal(err)
+	}
+	return j
+}
+
+func jobNames(jobs []*job.Spec) string {
+	names := []string{}
+	for _, j := range jobs {
+		names = append(names, j.ID.String())
+	}
+	return strings.Join(names, ",")
+}
+
+func TestConformanceJobs(t *testing.T) {
+	conformance(t, func(t *testing.T, s *KVStore) {
+		a := conformanceJob(t, s, "foo", "a")
+		conformanceJob(t, s, "foo", "b")
+		// a namespace whose name starts with another's must not leak into it
+		conformanceJob(t, s, "foobar", "c")
+
+		jobs, err := s.GetJobs("foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID.String() < jobs[j].ID.String() })
+		if got := jobNames(jobs); got != "foo/a,foo/b" {
+			t.Errorf("expected foo/a,foo/b in foo, got %s", got)
+		}
+		if jobs, err = s.GetJobs(""); err != nil {
+			t.Fatal(err)
+		} else if len(jobs) != 3 {
+			t.Errorf("expected 3 jobs in all namespaces, got %s", jobNames(jobs))
+		}
+
+		stored, err := s.GetJob(a.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.ResourceVersion == 0 || stored.ResourceVersion != a.ResourceVersion {
+			t.Errorf("expected the version set on write, %d, to be read back, got %d", a.ResourceVersion, stored.ResourceVersion)
+		}
+		version := stored.ResourceVersion
+		stored.Annotations = map[string]string{"changed": "yes"}
+		if err := s.SetJobVersion(stored, version); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SetJobVersion(stored, version); err != ErrVersionMismatch {
+			t.Errorf("expected a stale version to be refused with %s, got %v", ErrVersionMismatch, err)
+		}
+
+		if _, err := s.DeleteJob(a.ID, 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.GetJob(a.ID); err != store.ErrKeyNotFound {
+			t.Errorf("expected %s for a deleted job, got %v", store.ErrKeyNotFound, err)
+		}
+	})
+}
+
+func TestConformanceCompareAndSwap(t *testing.T) {
+	conformance(t, func(t *testing.T, s *KVStore) {
+		key := s.keyspace + "/conformance/cas"
+		if _, _, err := s.Client.AtomicPut(key, []byte("1"), nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := s.Client.AtomicPut(key, []byte("2"), nil, nil); err != store.ErrKeyExists {
+			t.Errorf("expected a create-only put of an existing key to fail with %s, got %v", store.ErrKeyExists, err)
+		}
+		first, err := s.Client.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := s.Client.AtomicPut(key, []byte("2"), first, nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := s.Client.AtomicPut(key, []byte("3"), first, nil); err != store.ErrKeyModified {
+			t.Errorf("expected a put against a stale value to fail with %s, got %v", store.ErrKeyModified, err)
+		}
+		if _, err := s.Client.AtomicDelete(key, first); err != store.ErrKeyModified {
+			t.Errorf("expected a delete against a stale value to fail with %s, got %v", store.ErrKeyModified, err)
+		}
+
+		j := conformanceJob(t, s, "default", "racy")
+		race(t, func() error {
+			_, err := s.UpdateJobState(j.ID, func(state *job.State) {
+				state.ErrorCount++
+			})
+			return err
+		})
+		state, err := s.GetJobState(j.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state.ErrorCount != racingWriters*racingUpdates {
+			t.Errorf("expected %d updates, got %d", racingWriters*racingUpdates, state.ErrorCount)
+		}
+	})
+}
+
+func TestConformanceJobPages(t *testing.T) {
+	conformance(t, func(t *testing.T, s *KVStore) {
+		for _, name := range []string{"e", "c", "a", "d", "b"} {
+			conformanceJob(t, s, "default", name)
+		}
+		got := []string{}
+		after := ""
+		for {
+			page, err := s.GetJobPage("default", after, 2, func(*job.Spec) (bool, error) { return true, nil })
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, jobNames(page.Jobs))
+			if page.Continue == "" {
+				break
+			}
+			after = page.Continue
+		}
+		if want := "default/a,default/b|default/c,default/d|default/e"; strings.Join(got, "|") != want {
+			t.Errorf("expected pages %s, got %s", want, strings.Join(got, "|"))
+		}
+	})
+}
+
+func TestConformanceDeleteNamespace(t *testing.T) {
+	conformance(t, func(t *testing.T, s *KVStore) {
+		for _, name := range []string{"foo", "foobar"} {
+			if err := s.SetNamespace(&namespace.Namespace{Name: name, Owner: "test"}, 0); err != nil {
+				t.Fatal(err)
+			}
+			conformanceJob(t, s, name, "a")
+		}
+		_, deleted, err := s.DeleteNamespace("foo", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := jobNames(deleted); got != "foo/a" {
+			t.Errorf("expected only foo/a to be deleted, got %s", got)
+		}
+		if _, err := s.GetJob(job.ID{Namespace: "foobar", Name: "a"}); err != nil {
+			t.Errorf("expected foobar/a to be kept, got %s", err)
+		}
+		if _, err := s.GetNamespace("foobar"); err != nil {
+			t.Errorf("expected namespace foobar to be kept, got %s", err)
+		}
+	})
+}
+
+func TestConformanceAuditEvents(t *testing.T) {
+	conformance(t, func(t *testing.T, s *KVStore) {
+		start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		appendEvents(t, s, start, 5)
+		events, err := s.GetAuditEvents(&audit.Filter{}, 3, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := principals(events); got != "p4,p3,p2" {
+			t.Errorf("expected the newest 3 events, got %s", got)
+		}
+	})
+}
+
+func TestConformanceWatchJobs(t *testing.T) {
+	conformance(t, func(t *testing.T, s *KVStore) {
+		stop := make(chan struct{})
+		defer close(stop)
+		events, err := s.WatchJobs(stop)
+		if s.backend == store.BOLTDB {
+			if err != store.ErrCallNotSupported {
+				t.Errorf("expected boltdb to refuse to watch with %s, got %v", store.ErrCallNotSupported, err)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		next := func() *JobEvent {
+			select {
+			case ev, ok := <-events:
+				if !ok {
+					t.Fatal("watch closed")
+				}
+				return ev
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for a job event")
+			}
+			return nil
+		}
+		if ev := next(); !ev.Resync {
+			t.Fatalf("expected the first event to be a resync, got %+v", ev)
+		}
+
+		j := conformanceJob(t, s, "default", "watched")
+		// backends that only see directories report every change as a resync
+		if ev := next(); !ev.Resync && ev.ID != j.ID {
+			t.Errorf("expected an event for %s, got %+v", j.ID, ev)
+		}
+	})
+}
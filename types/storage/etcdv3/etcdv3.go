@@ -0,0 +1,527 @@
+package etcdv3
+
+// etcdv3 is a libkv backend that talks the etcd v3 API. libkv's own etcd
+// backend uses the v2 API, which has no transactions or leases and watches
+// directories by polling indexes. Here:
+//   - compare-and-swap writes are transactions on a key's mod revision
+//   - writes with a TTL and locks are attached to leases, so they go away
+//     when their owner stops renewing them
+//   - watches resume from the revision they were started at, so no change
+//     is missed between reading a key and watching it
+// The v3 keyspace is flat, so like consul, List and DeleteTree work on
+// every key below a directory
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/clientv3/concurrency"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/docker/libkv"
+	"github.com/docker/libkv/store"
+)
+
+const (
+	// ETCDV3 is the backend name to pass to libkv.NewStore
+	ETCDV3 store.Backend = "etcdv3"
+
+	// defaultLockTTL is how long a lock survives its owner going away,
+	// unless the lock options say otherwise
+	defaultLockTTL = 20 * time.Second
+
+	// requestTimeout bounds every request that is not a watch
+	requestTimeout = 10 * time.Second
+)
+
+// Register registers the etcd v3 backend with libkv
+func Register() {
+	libkv.AddStore(ETCDV3, New)
+}
+
+// Etcd is a libkv store on top of the etcd v3 client
+type Etcd struct {
+	client *clientv3.Client
+}
+
+// New connects to etcd
+func New(endpoints []string, options *store.Config) (store.Store, error) {
+	cfg := clientv3.Config{Endpoints: endpoints}
+	if options != nil {
+		cfg.DialTimeout = options.ConnectionTimeout
+		cfg.TLS = options.TLS
+		cfg.Username = options.Username
+		cfg.Password = options.Password
+	}
+	client, err := clientv3.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Etcd{client: client}, nil
+}
+
+// normalize turns a key into /a/b form
+func normalize(key string) string {
+	parts := strings.FieldsFunc(key, func(r rune) bool { return r == '/' })
+	return "/" + strings.Join(parts, "/")
+}
+
+// directory returns the prefix of every key below key
+func directory(key string) string {
+	key = normalize(key)
+	if key == "/" {
+		return key
+	}
+	return key + "/"
+}
+
+func requestContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), requestTimeout)
+}
+
+func toPair(kv *mvccpb.KeyValue) *store.KVPair {
+	return &store.KVPair{
+		Key:       string(kv.Key),
+		Value:     kv.Value,
+		LastIndex: uint64(kv.ModRevision),
+	}
+}
+
+// lease returns a put option attaching key to a lease of ttl, or no option
+// if ttl is 0. A key that is already on a lease of the same TTL keeps it,
+// renewed, so a value rewritten on a TTL, like a heartbeat, holds one lease
+// rather than leaving one behind per write
+func (e *Etcd) lease(key string, ttl time.Duration) ([]clientv3.OpOption, error) {
+	if ttl <= 0 {
+		return nil, nil
+	}
+	seconds := int64(ttl / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	ctx, cancel := requestContext()
+	defer cancel()
+	if resp, err := e.client.Get(ctx, key); err == nil && len(resp.Kvs) > 0 && resp.Kvs[0].Lease != 0 {
+		id := clientv3.LeaseID(resp.Kvs[0].Lease)
+		if renewed, err := e.client.KeepAliveOnce(ctx, id); err == nil && renewed.TTL == seconds {
+			return []clientv3.OpOption{clientv3.WithLease(id)}, nil
+		}
+	}
+	lease, err := e.client.Grant(ctx, seconds)
+	if err != nil {
+		return nil, err
+	}
+	return []clientv3.OpOption{clientv3.WithLease(lease.ID)}, nil
+}
+
+// Put stores a value. Directories are implicit, so IsDir puts are ignored.
+// A TTL attaches the value to a lease, and it is deleted once it expires
+func (e *Etcd) Put(key string, value []byte, options *store.WriteOptions) error {
+	var opts []clientv3.OpOption
+	if options != nil {
+		if options.IsDir {
+			return nil
+		}
+		var err error
+		if opts, err = e.lease(normalize(key), options.TTL); err != nil {
+			return err
+		}
+	}
+	ctx, cancel := requestContext()
+	defer cancel()
+	_, err := e.client.Put(ctx, normalize(key), string(value), opts...)
+	return err
+}
+
+// Get returns a value
+func (e *Etcd) Get(key string) (*store.KVPair, error) {
+	ctx, cancel := requestContext()
+	defer cancel()
+	resp, err := e.client.Get(ctx, normalize(key))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	return toPair(resp.Kvs[0]), nil
+}
+
+// Delete deletes a value
+func (e *Etcd) Delete(key string) error {
+	ctx, cancel := requestContext()
+	defer cancel()
+	resp, err := e.client.Delete(ctx, normalize(key))
+	if err != nil {
+		return err
+	}
+	if resp.Deleted == 0 {
+		return store.ErrKeyNotFound
+	}
+	return nil
+}
+
+// Exists returns true if a value is stored at key, or below it
+func (e *Etcd) Exists(key string) (bool, error) {
+	ctx, cancel := requestContext()
+	defer cancel()
+	resp, err := e.client.Txn(ctx).Then(
+		clientv3.OpGet(normalize(key), clientv3.WithCountOnly()),
+		clientv3.OpGet(directory(key), clientv3.WithPrefix(), clientv3.WithCountOnly()),
+	).Commit()
+	if err != nil {
+		return false, err
+	}
+	for _, r := range resp.Responses {
+		if r.GetResponseRange().Count > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// list returns every value below a directory, and the revision it was read at
+func (e *Etcd) list(dir string) ([]*store.KVPair, int64, error) {
+	ctx, cancel := requestContext()
+	defer cancel()
+	resp, err := e.client.Get(ctx, directory(dir),
+		clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	if err != nil {
+		return nil, 0, err
+	}
+	pairs := make([]*store.KVPair, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		pairs = append(pairs, toPair(kv))
+	}
+	return pairs, resp.Header.Revision, nil
+}
+
+// List returns every value below a directory, ordered by key
+func (e *Etcd) List(dir string) ([]*store.KVPair, error) {
+	pairs, _, err := e.list(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(pairs) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	return pairs, nil
+}
+
+// ListRange returns at most limit values below a directory with keys after
+// the key after, ordered by key. Only the page is read from etcd
+func (e *Etcd) ListRange(dir string, after string, limit int) ([]*store.KVPair, error) {
+	prefix := directory(dir)
+	start := prefix
+	if after != "" && normalize(after) >= prefix {
+		// the smallest key after it
+		start = normalize(after) + "\x00"
+	}
+	ctx, cancel := requestContext()
+	defer cancel()
+	resp, err := e.client.Get(ctx, start,
+		clientv3.WithRange(clientv3.GetPrefixRangeEnd(prefix)),
+		clientv3.WithLimit(int64(limit)),
+		clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	if err != nil {
+		return nil, err
+	}
+	pairs := make([]*store.KVPair, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		pairs = append(pairs, toPair(kv))
+	}
+	return pairs, nil
+}
+
+// DeleteTree deletes a directory and everything below it in one transaction
+func (e *Etcd) DeleteTree(dir string) error {
+	ctx, cancel := requestContext()
+	defer cancel()
+	_, err := e.client.Txn(ctx).Then(
+		clientv3.OpDelete(normalize(dir)),
+		clientv3.OpDelete(directory(dir), clientv3.WithPrefix()),
+	).Commit()
+	return err
+}
+
+// AtomicPut stores a value if the stored one is still at the revision of
+// previous. A nil previous only succeeds if nothing is stored yet
+func (e *Etcd) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	key = normalize(key)
+	var opts []clientv3.OpOption
+	if options != nil {
+		var err error
+		if opts, err = e.lease(key, options.TTL); err != nil {
+			return false, nil, err
+		}
+	}
+
+	cmp := clientv3.Compare(clientv3.CreateRevision(key), "=", 0)
+	if previous != nil {
+		cmp = clientv3.Compare(clientv3.ModRevision(key), "=", int64(previous.LastIndex))
+	}
+	ctx, cancel := requestContext()
+	defer cancel()
+	resp, err := e.client.Txn(ctx).
+		If(cmp).
+		Then(clientv3.OpPut(key, string(value), opts...)).
+		Else(clientv3.OpGet(key, clientv3.WithCountOnly())).
+		Commit()
+	if err != nil {
+		return false, nil, err
+	}
+	if !resp.Succeeded {
+		switch {
+		case previous == nil:
+			return false, nil, store.ErrKeyExists
+		case resp.Responses[0].GetResponseRange().Count == 0:
+			return false, nil, store.ErrKeyNotFound
+		default:
+			return false, nil, store.ErrKeyModified
+		}
+	}
+	return true, &store.KVPair{Key: key, Value: value, LastIndex: uint64(resp.Header.Revision)}, nil
+}
+
+// AtomicDelete deletes a value if it is still at the revision of previous
+func (e *Etcd) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	if previous == nil {
+		return false, store.ErrPreviousNotSpecified
+	}
+	key = normalize(key)
+	ctx, cancel := requestContext()
+	defer cancel()
+	resp, err := e.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", int64(previous.LastIndex))).
+		Then(clientv3.OpDelete(key)).
+		Else(clientv3.OpGet(key, clientv3.WithCountOnly())).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+	if !resp.Succeeded {
+		if resp.Responses[0].GetResponseRange().Count == 0 {
+			return false, store.ErrKeyNotFound
+		}
+		return false, store.ErrKeyModified
+	}
+	return true, nil
+}
+
+// watchContext returns a context that is cancelled when stopCh is closed
+func watchContext(stopCh <-chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(clientv3.WithRequireLeader(context.Background()))
+	go func() {
+		select {
+		case <-stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// Watch sends the value of key when the watch starts, and whenever it
+// changes. Deleting the key, or the watch failing, ends the watch
+func (e *Etcd) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	key = normalize(key)
+	current, err := e.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := watchContext(stopCh)
+	events := e.client.Watch(ctx, key, clientv3.WithRev(int64(current.LastIndex)+1))
+	out := make(chan *store.KVPair)
+	go func() {
+		defer cancel()
+		defer close(out)
+		select {
+		case out <- current:
+		case <-ctx.Done():
+			return
+		}
+		for resp := range events {
+			if resp.Err() != nil {
+				return
+			}
+			for _, ev := range resp.Events {
+				if ev.Type == mvccpb.DELETE {
+					return
+				}
+				select {
+				case out <- toPair(ev.Kv):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+// WatchTree sends everything below a directory when the watch starts, and
+// whenever anything below it changes. The tree is read once, and then kept
+// up to date from the watch events rather than read again on every change
+func (e *Etcd) WatchTree(dir string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	pairs, revision, err := e.list(dir)
+	if err != nil {
+		return nil, err
+	}
+	tree := map[string]*store.KVPair{}
+	for _, p := range pairs {
+		tree[p.Key] = p
+	}
+
+	ctx, cancel := watchContext(stopCh)
+	events := e.client.Watch(ctx, directory(dir), clientv3.WithPrefix(), clientv3.WithRev(revision+1))
+	out := make(chan []*store.KVPair)
+	go func() {
+		defer cancel()
+		defer close(out)
+		for {
+			select {
+			case out <- snapshot(tree):
+			case <-ctx.Done():
+				return
+			}
+			resp, ok := <-events
+			if !ok || resp.Err() != nil {
+				return
+			}
+			for _, ev := range resp.Events {
+				if ev.Type == mvccpb.DELETE {
+					delete(tree, string(ev.Kv.Key))
+				} else {
+					tree[string(ev.Kv.Key)] = toPair(ev.Kv)
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+// snapshot returns the values of a tree ordered by key
+func snapshot(tree map[string]*store.KVPair) []*store.KVPair {
+	pairs := make([]*store.KVPair, 0, len(tree))
+	for _, p := range tree {
+		pairs = append(pairs, p)
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+	return pairs
+}
+
+// NewLock returns a lock on key. The lock is held on a lease that is kept
+// alive while the process is, so a crashed owner loses it after the TTL
+func (e *Etcd) NewLock(key string, options *store.LockOptions) (store.Locker, error) {
+	l := &lock{etcd: e, key: normalize(key), ttl: defaultLockTTL}
+	if options != nil {
+		l.value = options.Value
+		if options.TTL > 0 {
+			l.ttl = options.TTL
+		}
+	}
+	return l, nil
+}
+
+// Close closes the connection to etcd
+func (e *Etcd) Close() {
+	e.client.Close()
+}
+
+type lock struct {
+	mu      sync.Mutex
+	etcd    *Etcd
+	key     string
+	value   []byte
+	ttl     time.Duration
+	session *concurrency.Session
+	// created is the create revision of the key while the lock is held
+	created int64
+}
+
+// Lock blocks until the lock is held, or stopChan is closed. The returned
+// channel is closed if the lock is lost because its lease expired
+func (l *lock) Lock(stopChan chan struct{}) (<-chan struct{}, error) {
+	seconds := int(l.ttl / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	session, err := concurrency.NewSession(l.etcd.client, concurrency.WithTTL(seconds))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := watchContext(stopChan)
+	defer cancel()
+	for {
+		resp, err := l.etcd.client.Txn(ctx).
+			If(clientv3.Compare(clientv3.CreateRevision(l.key), "=", 0)).
+			Then(clientv3.OpPut(l.key, string(l.value), clientv3.WithLease(session.Lease()))).
+			Else(clientv3.OpGet(l.key)).
+			Commit()
+		if err != nil {
+			session.Close()
+			if ctx.Err() != nil {
+				return nil, store.ErrCannotLock
+			}
+			return nil, err
+		}
+		if resp.Succeeded {
+			l.mu.Lock()
+			l.session = session
+			l.created = resp.Header.Revision
+			l.mu.Unlock()
+			return session.Done(), nil
+		}
+
+		// wait for the holder to let go, starting from the revision the
+		// key was seen at so the delete cannot be missed
+		held := resp.Responses[0].GetResponseRange().Kvs
+		if len(held) == 0 {
+			continue
+		}
+		events := l.etcd.client.Watch(ctx, l.key, clientv3.WithRev(held[0].ModRevision+1))
+		if !waitForDelete(events) && ctx.Err() != nil {
+			session.Close()
+			return nil, store.ErrCannotLock
+		}
+	}
+}
+
+// waitForDelete returns true once a delete event arrives, or false if the
+// watch ends first
+func waitForDelete(events clientv3.WatchChan) bool {
+	for resp := range events {
+		if resp.Err() != nil {
+			return false
+		}
+		for _, ev := range resp.Events {
+			if ev.Type == mvccpb.DELETE {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Unlock releases the lock, if it is still held, and revokes its lease
+func (l *lock) Unlock() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.session == nil {
+		return nil
+	}
+	ctx, cancel := requestContext()
+	defer cancel()
+	_, err := l.etcd.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(l.key), "=", l.created)).
+		Then(clientv3.OpDelete(l.key)).
+		Commit()
+	l.session.Close()
+	l.session = nil
+	return err
+}
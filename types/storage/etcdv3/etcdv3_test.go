@@ -0,0 +1,252 @@
+package etcdv3
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/embed"
+	"github.com/docker/libkv/store"
+)
+
+// freePort returns a local port that was free a moment ago
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// startEtcd starts a single member etcd in-process, and returns a store
+// talking to it and a func to stop both. The test is skipped if the server
+// cannot start
+func startEtcd(t *testing.T) (*Etcd, func()) {
+	dir, err := ioutil.TempDir("", "flow-etcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := embed.NewConfig()
+	cfg.Dir = dir
+	client, _ := url.Parse(fmt.Sprintf("http://127.0.0.1:%d", freePort(t)))
+	peer, _ := url.Parse(fmt.Sprintf("http://127.0.0.1:%d", freePort(t)))
+	cfg.LCUrls, cfg.ACUrls = []url.URL{*client}, []url.URL{*client}
+	cfg.LPUrls, cfg.APUrls = []url.URL{*peer}, []url.URL{*peer}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	server, err := embed.StartEtcd(cfg)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Skipf("unable to start an embedded etcd: %s", err)
+	}
+	select {
+	case <-server.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		server.Close()
+		os.RemoveAll(dir)
+		t.Skip("embedded etcd did not become ready")
+	}
+
+	s, err := New([]string{client.Host}, &store.Config{ConnectionTimeout: 5 * time.Second})
+	if err != nil {
+		server.Close()
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return s.(*Etcd), func() {
+		s.Close()
+		server.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestAtomicPut(t *testing.T) {
+	e, stop := startEtcd(t)
+	defer stop()
+
+	_, first, err := e.AtomicPut("/flow/a", []byte("1"), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := e.AtomicPut("/flow/a", []byte("2"), nil, nil); err != store.ErrKeyExists {
+		t.Errorf("expected %s creating an existing key, got %v", store.ErrKeyExists, err)
+	}
+	if _, _, err := e.AtomicPut("/flow/a", []byte("2"), first, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := e.AtomicPut("/flow/a", []byte("3"), first, nil); err != store.ErrKeyModified {
+		t.Errorf("expected %s swapping a stale value, got %v", store.ErrKeyModified, err)
+	}
+	if _, err := e.AtomicDelete("/flow/a", first); err != store.ErrKeyModified {
+		t.Errorf("expected %s deleting a stale value, got %v", store.ErrKeyModified, err)
+	}
+	if _, _, err := e.AtomicPut("/flow/b", []byte("1"), first, nil); err != store.ErrKeyNotFound {
+		t.Errorf("expected %s swapping a missing key, got %v", store.ErrKeyNotFound, err)
+	}
+}
+
+func TestPutTTLKeepsLease(t *testing.T) {
+	e, stop := startEtcd(t)
+	defer stop()
+
+	leaseOf := func() int64 {
+		ctx, cancel := requestContext()
+		defer cancel()
+		resp, err := e.client.Get(ctx, "/flow/heartbeat")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Kvs) != 1 {
+			t.Fatal("expected the key to be stored")
+		}
+		return resp.Kvs[0].Lease
+	}
+	ttl := &store.WriteOptions{TTL: 5 * time.Second}
+	if err := e.Put("/flow/heartbeat", []byte("1"), ttl); err != nil {
+		t.Fatal(err)
+	}
+	lease := leaseOf()
+	if lease == 0 {
+		t.Fatal("expected a value written with a TTL to be on a lease")
+	}
+	if err := e.Put("/flow/heartbeat", []byte("2"), ttl); err != nil {
+		t.Fatal(err)
+	}
+	if again := leaseOf(); again != lease {
+		t.Errorf("expected a rewrite with the same TTL to keep lease %x, got %x", lease, again)
+	}
+	if err := e.Put("/flow/heartbeat", []byte("3"), &store.WriteOptions{TTL: 10 * time.Second}); err != nil {
+		t.Fatal(err)
+	}
+	if other := leaseOf(); other == lease {
+		t.Error("expected a rewrite with another TTL to get a lease of its own")
+	}
+}
+
+func TestListRange(t *testing.T) {
+	e, stop := startEtcd(t)
+	defer stop()
+
+	for _, k := range []string{"e", "c", "a", "d", "b"} {
+		if err := e.Put("/flow/jobs/"+k, []byte(k), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a sibling whose name starts with the directory's is not below it
+	if err := e.Put("/flow/jobsx/a", []byte("x"), nil); err != nil {
+		t.Fatal(err)
+	}
+	got := ""
+	after := ""
+	for {
+		pairs, err := e.ListRange("/flow/jobs", after, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range pairs {
+			got += string(p.Value)
+			after = p.Key
+		}
+		if len(pairs) < 2 {
+			break
+		}
+	}
+	if got != "abcde" {
+		t.Errorf("expected abcde a page at a time, got %s", got)
+	}
+}
+
+func TestWatchTree(t *testing.T) {
+	e, stop := startEtcd(t)
+	defer stop()
+
+	if err := e.Put("/flow/jobs/a", []byte("1"), nil); err != nil {
+		t.Fatal(err)
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	events, err := e.WatchTree("/flow/jobs", stopCh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := func() []*store.KVPair {
+		select {
+		case pairs, ok := <-events:
+			if !ok {
+				t.Fatal("watch ended")
+			}
+			return pairs
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the tree")
+		}
+		return nil
+	}
+	if pairs := next(); len(pairs) != 1 {
+		t.Fatalf("expected the tree as it was, got %d values", len(pairs))
+	}
+	if err := e.Put("/flow/jobs/b", []byte("2"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if pairs := next(); len(pairs) != 2 || string(pairs[1].Value) != "2" {
+		t.Errorf("expected the new value in the tree, got %v", pairs)
+	}
+}
+
+func TestLockLostWithLease(t *testing.T) {
+	e, stop := startEtcd(t)
+	defer stop()
+
+	first, _ := e.NewLock("/flow/leader", &store.LockOptions{Value: []byte("one"), TTL: 5 * time.Second})
+	lost, err := first.Lock(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leader, err := e.Get("/flow/leader"); err != nil || string(leader.Value) != "one" {
+		t.Fatalf("expected one to hold the lock, got %v, %v", leader, err)
+	}
+
+	second, _ := e.NewLock("/flow/leader", &store.LockOptions{Value: []byte("two"), TTL: 5 * time.Second})
+	locked := make(chan error, 1)
+	go func() {
+		_, err := second.Lock(nil)
+		locked <- err
+	}()
+	select {
+	case err := <-locked:
+		t.Fatalf("expected the lock to be held, got %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// a holder whose lease goes away loses the lock, as a dead one would
+	held := first.(*lock)
+	held.mu.Lock()
+	id := held.session.Lease()
+	held.mu.Unlock()
+	ctx, cancel := requestContext()
+	defer cancel()
+	if _, err := e.client.Revoke(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-lost:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the lock to be lost with its lease")
+	}
+	select {
+	case err := <-locked:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the lock to pass on")
+	}
+	if leader, err := e.Get("/flow/leader"); err != nil || string(leader.Value) != "two" {
+		t.Errorf("expected two to hold the lock, got %v, %v", leader, err)
+	}
+	second.Unlock()
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/byxorna/flow/types/execution"
+	"github.com/byxorna/flow/types/job"
+	"github.com/docker/libkv/store"
+	"github.com/google/uuid"
+)
+
+// storeInstance stores an instance of a job in group, started at started and
+// finished with success unless running
+func storeInstance(t *testing.T, s *KVStore, j *job.Spec, group int64, started time.Time, success, running bool) *execution.Instance {
+	i := execution.NewInstance(j.ID)
+	i.Group = group
+	i.StartedAt = started
+	if !running {
+		i.FinishedAt = started.Add(time.Minute)
+		i.Success = success
+	}
+	if _, err := s.SetExecution(i); err != nil {
+		t.Fatal(err)
+	}
+	return i
+}
+
+func TestExecutionHistory(t *testing.T) {
+	s := NewMemory()
+	j := newTestJob(t, s, "a")
+	other := newTestJob(t, s, "b")
+	now := time.Now()
+
+	first := storeInstance(t, s, j, 1, now.Add(-3*time.Hour), true, false)
+	storeInstance(t, s, j, 1, now.Add(-3*time.Hour), true, false)
+	failed := storeInstance(t, s, j, 2, now.Add(-2*time.Hour), false, false)
+	running := storeInstance(t, s, j, 3, now.Add(-time.Hour), false, true)
+	storeInstance(t, s, other, 4, now, true, false)
+
+	execs, err := s.GetExecutions(j.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(execs) != 4 {
+		t.Errorf("expected 4 instances of %s, got %d", j.ID.String(), len(execs))
+	}
+
+	got, err := s.GetExecution(j.ID, failed.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != failed.ID || got.Group != 2 || got.Success {
+		t.Errorf("expected the failed instance, got %+v", got)
+	}
+	if _, err := s.GetExecution(j.ID, uuid.New()); err != store.ErrKeyNotFound {
+		t.Errorf("expected %v for an unknown instance, got %v", store.ErrKeyNotFound, err)
+	}
+	if _, err := s.GetExecution(other.ID, failed.ID); err != store.ErrKeyNotFound {
+		t.Errorf("expected %v for an instance of another job, got %v", store.ErrKeyNotFound, err)
+	}
+
+	groups, order, err := s.GetGroupedExecutions(j.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(order, []int64{3, 2, 1}) {
+		t.Errorf("expected groups newest first, got %v", order)
+	}
+	for group, n := range map[int64]int{1: 2, 2: 1, 3: 1} {
+		if len(groups[group]) != n {
+			t.Errorf("expected %d instances in group %d, got %d", n, group, len(groups[group]))
+		}
+	}
+
+	group, err := s.GetExecutionGroup(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(group) != 2 {
+		t.Errorf("expected both instances of group 1, got %d", len(group))
+	}
+
+	last, err := s.GetLastExecutionGroup(j.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(last) != 1 || last[0].ID != running.ID {
+		t.Errorf("expected the running instance as the last group, got %v", last)
+	}
+	summary, err := s.GetJobSummary(j.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Status != job.Running {
+		t.Errorf("expected the job to be running, got %s", summary.Status)
+	}
+}
+
+func TestExecutionHistoryEmpty(t *testing.T) {
+	s := NewMemory()
+	j := newTestJob(t, s, "a")
+
+	last, err := s.GetLastExecutionGroup(j.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(last) != 0 {
+		t.Errorf("expected no last group for a job that never ran, got %v", last)
+	}
+	summary, err := s.GetJobSummary(j.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Status != job.Pending {
+		t.Errorf("expected a job that never ran to be pending, got %s", summary.Status)
+	}
+}
@@ -0,0 +1,508 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/docker/libkv/store"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+
+	"github.com/byxorna/flow/types/audit"
+	"github.com/byxorna/flow/types/execution"
+	"github.com/byxorna/flow/types/job"
+	"github.com/byxorna/flow/types/namespace"
+	"github.com/byxorna/flow/types/rbac"
+)
+
+const (
+	// QuarantinePath is where fsck moves values it cannot use, below the
+	// keyspace, keeping the rest of their original path
+	QuarantinePath = "quarantine"
+)
+
+var (
+	// ErrFsckStale is returned by a repair of something that changed since
+	// fsck found it, which is left alone for the next run to look at again
+	ErrFsckStale = fmt.Errorf("changed since it was checked, run fsck again")
+)
+
+// FsckKind is the kind of problem fsck found
+type FsckKind string
+
+const (
+	// Corrupt values cannot be parsed
+	Corrupt FsckKind = "corrupt"
+	// Invalid values parse, but are not something flow would have stored
+	Invalid FsckKind = "invalid"
+	// Orphan data belongs to something that no longer exists
+	Orphan FsckKind = "orphan"
+	// Dangling references point at jobs that do not exist
+	Dangling FsckKind = "dangling"
+)
+
+// FsckFinding is a problem fsck found, and what repairing it does
+type FsckFinding struct {
+	Kind    FsckKind `json:"kind"`
+	Key     string   `json:"key"`
+	Job     *job.ID  `json:"job,omitempty"`
+	Problem string   `json:"problem"`
+	// Repair is what repairing does, or empty if it needs a person
+	Repair   string `json:"repair,omitempty"`
+	Repaired bool   `json:"repaired"`
+	Error    string `json:"error,omitempty"`
+
+	fix func() error
+}
+
+// FsckReport is the outcome of Fsck
+type FsckReport struct {
+	Repair   bool           `json:"repair"`
+	Scanned  int            `json:"scanned"`
+	Findings []*FsckFinding `json:"findings"`
+}
+
+// Unrepaired returns how many findings are still a problem
+func (r *FsckReport) Unrepaired() int {
+	n := 0
+	for _, f := range r.Findings {
+		if !f.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+// fsck is a single scan of the keyspace
+type fsck struct {
+	s      *KVStore
+	report *FsckReport
+	// jobs are the stored jobs by namespace/name, whether they are usable or not
+	jobs map[string]bool
+	// specs are the jobs that are usable
+	specs []*job.Spec
+}
+
+// Fsck scans the keyspace for values that cannot be parsed, job specs that
+// are invalid, data left behind by deleted jobs and instances, and job
+// references to jobs that do not exist. With repair set, unusable values are
+// moved to the quarantine, orphans are deleted and references to missing
+// dependents are dropped. Repairs run after the whole scan, so data that
+// becomes orphaned by a repair is only found by the next run. Each repair
+// checks again that the problem is still there, and only deletes values that
+// are unchanged since they were read, so a job created, or an instance
+// started, while fsck runs is not mistaken for something left behind
+func (s *KVStore) Fsck(repair bool) (*FsckReport, error) {
+	// a newer schema may store things this version would call broken
+	version, err := s.GetSchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	if version > SchemaVersion {
+		return nil, fmt.Errorf("%s: storage is at version %d, this flow supports up to %d", ErrSchemaTooNew, version, SchemaVersion)
+	}
+
+	c := &fsck{
+		s:      s,
+		report: &FsckReport{Repair: repair, Findings: []*FsckFinding{}},
+		jobs:   map[string]bool{},
+	}
+	checks := []func() error{
+		c.checkJobs,
+		c.checkStatus,
+		c.checkInstances,
+		c.checkLogs,
+		c.checkNamespaces,
+		c.checkPolicy,
+		c.checkAudit,
+	}
+	for _, check := range checks {
+		if err := check(); err != nil {
+			return c.report, err
+		}
+	}
+	c.checkReferences()
+
+	if !repair {
+		return c.report, nil
+	}
+	for _, f := range c.report.Findings {
+		if f.fix == nil {
+			continue
+		}
+		// an earlier repair may already have removed it, i.e. output of orphaned instances
+		if err := f.fix(); err != nil && err != store.ErrKeyNotFound {
+			log.WithError(err).WithFields(logrus.Fields{"key": f.Key, "kind": f.Kind}).Error("store: Unable to repair")
+			f.Error = err.Error()
+			continue
+		}
+		f.Repaired = true
+		log.WithFields(logrus.Fields{"key": f.Key, "kind": f.Kind}).Info("store: Repaired")
+	}
+	return c.report, nil
+}
+
+func (c *fsck) find(f *FsckFinding) {
+	c.report.Findings = append(c.report.Findings, f)
+}
+
+// quarantined returns a finding that moves pair to the quarantine
+func (c *fsck) quarantined(kind FsckKind, pair *store.KVPair, problem string) *FsckFinding {
+	return &FsckFinding{
+		Kind:    kind,
+		Key:     pair.Key,
+		Problem: problem,
+		Repair:  fmt.Sprintf("move to %s", c.s.quarantinePath(pair.Key)),
+		fix:     func() error { return c.s.quarantine(pair) },
+	}
+}
+
+// quarantinePath returns where key is moved to by quarantine
+func (s *KVStore) quarantinePath(key string) string {
+	rel := splitKey(key)[len(splitKey(s.keyspace)):]
+	return fmt.Sprintf("%s/%s/%s", s.keyspace, QuarantinePath, strings.Join(rel, "/"))
+}
+
+// quarantine moves a value out of the way, unless it changed since it was read
+func (s *KVStore) quarantine(pair *store.KVPair) error {
+	if err := s.Client.Put(s.quarantinePath(pair.Key), pair.Value, nil); err != nil {
+		return err
+	}
+	_, err := s.Client.AtomicDelete(pair.Key, pair)
+	return err
+}
+
+// dirs returns the directories directly below path, or none if it does not exist
+func (c *fsck) dirs(path string) ([]string, error) {
+	dirs, _, err := c.s.children(path)
+	if err == store.ErrKeyNotFound {
+		return []string{}, nil
+	}
+	return dirs, err
+}
+
+// leaves returns the values directly below path, or none if it does not exist
+func (c *fsck) leaves(path string) ([]*store.KVPair, error) {
+	leaves, err := c.s.leaves(path)
+	if err == store.ErrKeyNotFound {
+		return []*store.KVPair{}, nil
+	}
+	c.report.Scanned += len(leaves)
+	return leaves, err
+}
+
+// keyName returns the last segment of a key
+func keyName(key string) string {
+	parts := splitKey(key)
+	if len(parts) == 0 {
+		return ""
+	}
+	return parts[len(parts)-1]
+}
+
+// namespacedLeaves calls f with every value stored at <path>/<namespace>/<name>
+func (c *fsck) namespacedLeaves(path string, f func(id job.ID, pair *store.KVPair)) error {
+	namespaces, err := c.dirs(path)
+	if err != nil {
+		return err
+	}
+	for _, ns := range namespaces {
+		leaves, err := c.leaves(fmt.Sprintf("%s/%s", path, ns))
+		if err != nil {
+			return err
+		}
+		for _, pair := range leaves {
+			f(job.ID{Namespace: ns, Name: keyName(pair.Key)}, pair)
+		}
+	}
+	return nil
+}
+
+// jobKey identifies a job by where it is stored
+func jobKey(id job.ID) string {
+	return fmt.Sprintf("%s/%s", id.Namespace, id.Name)
+}
+
+// checkJobs finds job specs that cannot be parsed, are invalid, or are
+// stored under a different ID than their own
+func (c *fsck) checkJobs() error {
+	return c.namespacedLeaves(fmt.Sprintf("%s/%s", c.s.keyspace, job.StoragePath), func(id job.ID, pair *store.KVPair) {
+		c.jobs[jobKey(id)] = true
+		var j job.Spec
+		if err := json.Unmarshal(pair.Value, &j); err != nil {
+			c.find(c.quarantined(Corrupt, pair, fmt.Sprintf("job spec cannot be parsed: %s", err)))
+			return
+		}
+		if j.ID.Namespace != id.Namespace || j.ID.Name != id.Name {
+			c.find(c.quarantined(Invalid, pair, fmt.Sprintf("job %s is stored as %s", j.ID.String(), jobKey(id))))
+			return
+		}
+		if err := j.Validate(); err != nil {
+			c.find(c.quarantined(Invalid, pair, fmt.Sprintf("job spec is invalid: %s", err)))
+			return
+		}
+		c.specs = append(c.specs, &j)
+	})
+}
+
+// checkStatus finds job runtime state that cannot be parsed, or whose job is gone
+func (c *fsck) checkStatus() error {
+	return c.namespacedLeaves(fmt.Sprintf("%s/%s", c.s.keyspace, job.StatesPath), func(id job.ID, pair *store.KVPair) {
+		if !c.jobs[jobKey(id)] {
+			c.find(&FsckFinding{
+				Kind:    Orphan,
+				Key:     pair.Key,
+				Job:     &job.ID{Namespace: id.Namespace, Name: id.Name},
+				Problem: "status of a job that does not exist",
+				Repair:  "delete",
+				fix: func() error {
+					if err := c.s.jobGone(id); err != nil {
+						return err
+					}
+					return c.s.deleteUnchanged(pair)
+				},
+			})
+			return
+		}
+		var state job.State
+		if err := json.Unmarshal(pair.Value, &state); err != nil {
+			c.find(c.quarantined(Corrupt, pair, fmt.Sprintf("job status cannot be parsed: %s", err)))
+		}
+	})
+}
+
+// checkInstances finds the instances of jobs that are gone, and instances
+// that cannot be parsed
+func (c *fsck) checkInstances() error {
+	path := fmt.Sprintf("%s/%s", c.s.keyspace, execution.InstancesPath)
+	namespaces, err := c.dirs(path)
+	if err != nil {
+		return err
+	}
+	for _, ns := range namespaces {
+		names, err := c.dirs(fmt.Sprintf("%s/%s", path, ns))
+		if err != nil {
+			return err
+		}
+		for _, n := range names {
+			id := job.ID{Namespace: ns, Name: n}
+			leaves, err := c.leaves(execution.Path(c.s.keyspace, id))
+			if err != nil {
+				return err
+			}
+			if !c.jobs[jobKey(id)] {
+				c.find(&FsckFinding{
+					Kind:    Orphan,
+					Key:     execution.Path(c.s.keyspace, id),
+					Job:     &id,
+					Problem: fmt.Sprintf("%d instances of a job that does not exist", len(leaves)),
+					Repair:  "delete the instances and their output",
+					fix:     func() error { return c.s.deleteInstances(id, leaves) },
+				})
+				continue
+			}
+			for _, pair := range leaves {
+				var i execution.Instance
+				if err := json.Unmarshal(pair.Value, &i); err != nil {
+					c.find(c.quarantined(Corrupt, pair, fmt.Sprintf("instance cannot be parsed: %s", err)))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// deleteInstances deletes the instances of a job that is gone, and their
+// output. Output is found by the instance keys, so instances that cannot be
+// parsed go too. Only the instances that were read are deleted, so one
+// stored since is found by the next run instead
+func (s *KVStore) deleteInstances(id job.ID, instances []*store.KVPair) error {
+	if err := s.jobGone(id); err != nil {
+		return err
+	}
+	for _, pair := range instances {
+		if err := s.deleteUnchanged(pair); err != nil && err != store.ErrKeyNotFound {
+			return err
+		}
+		if instance, err := uuid.Parse(keyName(pair.Key)); err == nil {
+			if err := s.DeleteLogs(instance); err != nil {
+				return err
+			}
+		}
+	}
+	// the directory of backends that have them, which is only removed if empty
+	if err := s.Client.Delete(execution.Path(s.keyspace, id)); err != nil && err != store.ErrKeyNotFound {
+		return err
+	}
+	return nil
+}
+
+// jobGone returns ErrFsckStale if a job is stored as id, which may have been
+// created since fsck found data left behind by an earlier job of that name
+func (s *KVStore) jobGone(id job.ID) error {
+	_, err := s.Client.Get(job.Prefix(s.keyspace, id))
+	if err == store.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return ErrFsckStale
+}
+
+// deleteUnchanged deletes pair, unless it changed since it was read
+func (s *KVStore) deleteUnchanged(pair *store.KVPair) error {
+	ok, err := s.Client.AtomicDelete(pair.Key, pair)
+	if err == store.ErrKeyModified || (err == nil && !ok) {
+		return ErrFsckStale
+	}
+	return err
+}
+
+// checkLogs finds output of instances that are gone. An instance is looked
+// up when its output is found, so output of an instance that started during
+// the scan is not mistaken for an orphan
+func (c *fsck) checkLogs() error {
+	path := fmt.Sprintf("%s/%s", c.s.keyspace, execution.LogsPath)
+	instances, err := c.dirs(path)
+	if err != nil {
+		return err
+	}
+	for _, dir := range instances {
+		c.report.Scanned++
+		logPath := fmt.Sprintf("%s/%s", path, dir)
+		problem := ""
+		instance, err := uuid.Parse(dir)
+		if err != nil {
+			problem = "output of something that is not an instance"
+		} else if _, err := c.s.GetInstance(instance); err == store.ErrKeyNotFound {
+			problem = "output of an instance that does not exist"
+		} else if err != nil {
+			problem = fmt.Sprintf("output of an instance that cannot be found: %s", err)
+		}
+		if problem == "" {
+			continue
+		}
+		c.find(&FsckFinding{
+			Kind:    Orphan,
+			Key:     logPath,
+			Problem: problem,
+			Repair:  "delete",
+			fix: func() error {
+				// the instance may have been stored after its first output
+				if _, err := c.s.GetInstance(instance); err == nil {
+					return ErrFsckStale
+				}
+				return c.s.deleteTree(logPath)
+			},
+		})
+	}
+	return nil
+}
+
+// checkNamespaces finds namespaces that cannot be parsed, or are invalid
+func (c *fsck) checkNamespaces() error {
+	leaves, err := c.leaves(fmt.Sprintf("%s/%s", c.s.keyspace, namespace.StoragePath))
+	if err != nil {
+		return err
+	}
+	for _, pair := range leaves {
+		var n namespace.Namespace
+		if err := json.Unmarshal(pair.Value, &n); err != nil {
+			c.find(c.quarantined(Corrupt, pair, fmt.Sprintf("namespace cannot be parsed: %s", err)))
+			continue
+		}
+		if err := n.Validate(); err != nil {
+			c.find(c.quarantined(Invalid, pair, fmt.Sprintf("namespace is invalid: %s", err)))
+		}
+	}
+	return nil
+}
+
+// checkPolicy finds an access policy that cannot be parsed. It is left for a
+// person to fix, as quarantining it would leave no policy, which takes
+// every grant away from everyone but the admins from config
+func (c *fsck) checkPolicy() error {
+	pair, err := c.s.Client.Get(rbac.Path(c.s.keyspace))
+	if err == store.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	c.report.Scanned++
+	var p rbac.Policy
+	if err := json.Unmarshal(pair.Value, &p); err != nil {
+		c.find(&FsckFinding{
+			Kind:    Corrupt,
+			Key:     pair.Key,
+			Problem: fmt.Sprintf("access policy cannot be parsed: %s", err),
+		})
+	}
+	return nil
+}
+
+// checkAudit finds audit events that cannot be parsed
+func (c *fsck) checkAudit() error {
+	leaves, err := c.leaves(fmt.Sprintf("%s/%s", c.s.keyspace, audit.StoragePath))
+	if err != nil {
+		return err
+	}
+	for _, pair := range leaves {
+		var e audit.Event
+		if err := json.Unmarshal(pair.Value, &e); err != nil {
+			c.find(c.quarantined(Corrupt, pair, fmt.Sprintf("audit event cannot be parsed: %s", err)))
+		}
+	}
+	return nil
+}
+
+// checkReferences finds parent and dependent jobs that do not exist.
+// Missing dependents are dropped by repair. A missing parent is left for a
+// person, as the job needs a new parent or a schedule instead
+func (c *fsck) checkReferences() {
+	for _, j := range c.specs {
+		id := j.ID
+		if j.ParentJob != nil && !c.jobs[jobKey(NormalizeID(*j.ParentJob))] {
+			c.find(&FsckFinding{
+				Kind:    Dangling,
+				Key:     j.Path(c.s.keyspace),
+				Job:     &id,
+				Problem: fmt.Sprintf("parent job %s does not exist", j.ParentJob.String()),
+			})
+		}
+
+		missing := map[string]bool{}
+		names := []string{}
+		for _, d := range j.DependentJobs {
+			if k := jobKey(NormalizeID(d)); !c.jobs[k] && !missing[k] {
+				missing[k] = true
+				names = append(names, k)
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+		c.find(&FsckFinding{
+			Kind:    Dangling,
+			Key:     j.Path(c.s.keyspace),
+			Job:     &id,
+			Problem: fmt.Sprintf("dependent jobs %s do not exist", strings.Join(names, ", ")),
+			Repair:  "remove them from the job's dependents",
+			fix: func() error {
+				_, err := c.s.UpdateJob(id, func(j *job.Spec) error {
+					kept := []job.ID{}
+					for _, d := range j.DependentJobs {
+						if !missing[jobKey(NormalizeID(d))] {
+							kept = append(kept, d)
+						}
+					}
+					j.DependentJobs = kept
+					return nil
+				})
+				return err
+			},
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/byxorna/flow/types/execution"
+	"github.com/byxorna/flow/types/job"
+	"github.com/docker/libkv/store"
+)
+
+// orphanJob leaves the status and an instance of a job behind, as a job
+// deleted while it ran would
+func orphanJob(t *testing.T, s *KVStore, name string) (*job.Spec, *execution.Instance) {
+	j := newTestJob(t, s, name)
+	i := startInstance(t, s, j, time.Now())
+	if err := s.Client.Delete(job.Prefix(s.keyspace, j.ID)); err != nil {
+		t.Fatal(err)
+	}
+	return j, i
+}
+
+// orphans returns the orphan findings of a report, by key
+func orphans(r *FsckReport) map[string]*FsckFinding {
+	found := map[string]*FsckFinding{}
+	for _, f := range r.Findings {
+		if f.Kind == Orphan {
+			found[f.Key] = f
+		}
+	}
+	return found
+}
+
+func TestFsckRepairsOrphans(t *testing.T) {
+	s := NewMemory()
+	j, i := orphanJob(t, s, "gone")
+
+	report, err := s.Fsck(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := orphans(report)
+	for _, key := range []string{job.StatePath(s.keyspace, j.ID), execution.Path(s.keyspace, j.ID)} {
+		if f, ok := found[key]; !ok || !f.Repaired {
+			t.Errorf("expected %s to be found and repaired, got %+v", key, f)
+		}
+	}
+	if _, err := s.Client.Get(job.StatePath(s.keyspace, j.ID)); err != store.ErrKeyNotFound {
+		t.Errorf("expected the status to be deleted, got %v", err)
+	}
+	if _, err := s.GetExecution(j.ID, i.ID); err != store.ErrKeyNotFound {
+		t.Errorf("expected the instance to be deleted, got %v", err)
+	}
+	if report.Unrepaired() != 0 {
+		t.Errorf("expected nothing left to fix, got %d", report.Unrepaired())
+	}
+}
+
+func TestFsckKeepsJobsRecreatedDuringRepair(t *testing.T) {
+	s := NewMemory()
+	j, i := orphanJob(t, s, "recreated")
+
+	report, err := s.Fsck(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := orphans(report)
+	if len(found) != 2 {
+		t.Fatalf("expected the status and instances to be found, got %v", found)
+	}
+	// the job is created again between the scan and the repair
+	newTestJob(t, s, "recreated")
+	for key, f := range found {
+		if err := f.fix(); err != ErrFsckStale {
+			t.Errorf("expected repairing %s to fail with %s, got %v", key, ErrFsckStale, err)
+		}
+	}
+	if _, err := s.GetExecution(j.ID, i.ID); err != nil {
+		t.Errorf("expected the instance to be kept, got %s", err)
+	}
+	if _, err := s.Client.Get(job.StatePath(s.keyspace, j.ID)); err != nil {
+		t.Errorf("expected the status to be kept, got %s", err)
+	}
+}
+
+func TestFsckKeepsValuesChangedDuringRepair(t *testing.T) {
+	s := NewMemory()
+	j, i := orphanJob(t, s, "changed")
+
+	report, err := s.Fsck(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the run that outlived its job finishes between the scan and the repair
+	i.FinishedAt = time.Now()
+	if _, err := s.SetExecution(i); err != nil {
+		t.Fatal(err)
+	}
+	f, ok := orphans(report)[execution.Path(s.keyspace, j.ID)]
+	if !ok {
+		t.Fatalf("expected the instances to be found, got %v", report.Findings)
+	}
+	if err := f.fix(); err != ErrFsckStale {
+		t.Errorf("expected the repair to fail with %s, got %v", ErrFsckStale, err)
+	}
+	if _, err := s.GetExecution(j.ID, i.ID); err != nil {
+		t.Errorf("expected the changed instance to be kept, got %s", err)
+	}
+}
@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/byxorna/flow/types/execution"
+	"github.com/byxorna/flow/types/job"
+	"github.com/docker/libkv/store"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// SetHeartbeat records that a running instance was alive at t. The
+// heartbeat is written with a TTL of execution.LostAfter, so on backends
+// that expire keys, such as etcd on a lease, the heartbeat of an executor
+// that died goes away by itself once the instance counts as lost
+func (s *KVStore) SetHeartbeat(i *execution.Instance, t time.Time) error {
+	tJSON, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return s.Client.Put(execution.HeartbeatPath(s.keyspace, i.ID), tJSON, &store.WriteOptions{TTL: execution.LostAfter})
+}
+
+// DeleteHeartbeat removes the heartbeat of an instance that is no longer running
+func (s *KVStore) DeleteHeartbeat(instance uuid.UUID) error {
+	err := s.Client.Delete(execution.HeartbeatPath(s.keyspace, instance))
+	if err == store.ErrKeyNotFound {
+		return nil
+	}
+	return err
+}
+
+// RequestCancel asks the executor running an instance to cancel it, on
+// whichever node it runs. The request expires with the instance's
+// heartbeat, as an instance that is lost has nothing left to cancel it
+func (s *KVStore) RequestCancel(instance uuid.UUID, by string) error {
+	return s.Client.Put(execution.CancelPath(s.keyspace, instance), []byte(by), &store.WriteOptions{TTL: execution.LostAfter})
+}
+
+// GetCancelRequest returns who asked for an instance to be cancelled, or
+// nothing if no one did
+func (s *KVStore) GetCancelRequest(instance uuid.UUID) (string, error) {
+	res, err := s.Client.Get(execution.CancelPath(s.keyspace, instance))
+	if err == store.ErrKeyNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(res.Value), nil
+}
+
+// DeleteCancelRequest removes the cancel request of an instance that is no longer running
+func (s *KVStore) DeleteCancelRequest(instance uuid.UUID) error {
+	err := s.Client.Delete(execution.CancelPath(s.keyspace, instance))
+	if err == store.ErrKeyNotFound {
+		return nil
+	}
+	return err
+}
+
+// getHeartbeat returns the last heartbeat of an instance, or the zero time if it has none
+func (s *KVStore) getHeartbeat(instance uuid.UUID) (time.Time, error) {
+	var t time.Time
+	res, err := s.Client.Get(execution.HeartbeatPath(s.keyspace, instance))
+	if err != nil {
+		if err == store.ErrKeyNotFound {
+			return t, nil
+		}
+		return t, err
+	}
+	err = json.Unmarshal(res.Value, &t)
+	return t, err
+}
+
+// FailLostInstance gives up on an instance if it is lost at now, recording
+// it as failed, or as cancelled if cancelledBy is set. It returns false and
+// changes nothing if the instance finished or was heard from in time. The
+// instance is only written if nothing else wrote it since it was read, so
+// an executor that is still alive always wins. On success, i is updated
+func (s *KVStore) FailLostInstance(i *execution.Instance, cancelledBy string, now time.Time) (bool, error) {
+	path := fmt.Sprintf("%s/%s", execution.Path(s.keyspace, i.Job), i.ID)
+	pair, err := s.Client.Get(path)
+	if err != nil {
+		return false, err
+	}
+	var lost execution.Instance
+	if err := json.Unmarshal(pair.Value, &lost); err != nil {
+		return false, err
+	}
+	heartbeat, err := s.getHeartbeat(i.ID)
+	if err != nil {
+		return false, err
+	}
+	if !lost.Lost(heartbeat, now) {
+		return false, nil
+	}
+
+	lost.FinishedAt = now
+	lost.Success = false
+	if cancelledBy != "" {
+		lost.Cancelled = true
+		lost.CancelledBy = cancelledBy
+		lost.CancelledAt = now
+	}
+	if lost.ExecutorAttributes == nil {
+		lost.ExecutorAttributes = map[string]string{}
+	}
+	if heartbeat.IsZero() {
+		// it never had one, or it expired
+		lost.ExecutorAttributes["lost"] = fmt.Sprintf("no heartbeat within %s", execution.LostAfter)
+	} else {
+		lost.ExecutorAttributes["lost"] = fmt.Sprintf("no heartbeat since %s", heartbeat.Format(time.RFC3339))
+	}
+	lostJSON, err := json.Marshal(&lost)
+	if err != nil {
+		return false, err
+	}
+	if _, _, err := s.Client.AtomicPut(path, lostJSON, pair, nil); err != nil {
+		if err == store.ErrKeyModified || err == store.ErrKeyNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+
+	*i = lost
+	if err := s.DeleteHeartbeat(i.ID); err != nil {
+		return true, err
+	}
+	if err := s.ReleaseRun(i.Job, i.ID); err != nil {
+		return true, err
+	}
+	if err := s.updateLatest(i); err != nil {
+		return true, err
+	}
+	if !i.Cancelled {
+		if _, err := s.RecordRun(i); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// FailLostInstances gives up on every instance that is lost at now, and
+// returns them. Only the latest group of a job that is still running is
+// looked at, as a job does not start again until its last run finished
+func (s *KVStore) FailLostInstances(now time.Time) ([]*execution.Instance, error) {
+	states, err := s.jobStates()
+	if err != nil {
+		return nil, err
+	}
+	failed := []*execution.Instance{}
+	for id, state := range states {
+		if state.Latest == nil || state.Latest.Status != job.Running {
+			continue
+		}
+		group, err := s.GetLastExecutionGroup(id)
+		if err != nil {
+			return failed, err
+		}
+		for _, i := range group {
+			if !i.FinishedAt.IsZero() {
+				continue
+			}
+			ok, err := s.FailLostInstance(i, "", now)
+			if err != nil {
+				log.WithError(err).WithFields(logrus.Fields{"instance": i.ID}).Error("store: Unable to fail lost instance")
+				continue
+			}
+			if ok {
+				failed = append(failed, i)
+			}
+		}
+	}
+	return failed, nil
+}
+
+// jobStates returns the stored runtime state of every job, by job ID
+func (s *KVStore) jobStates() (map[job.ID]*job.State, error) {
+	path := fmt.Sprintf("%s/%s", s.keyspace, job.StatesPath)
+	namespaces, _, err := s.children(path)
+	if err == store.ErrKeyNotFound {
+		return map[job.ID]*job.State{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	states := map[job.ID]*job.State{}
+	for _, ns := range namespaces {
+		leaves, err := s.leaves(fmt.Sprintf("%s/%s", path, ns))
+		if err == store.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, leaf := range leaves {
+			parts := splitKey(leaf.Key)
+			var state job.State
+			if err := json.Unmarshal(leaf.Value, &state); err != nil {
+				log.WithError(err).WithFields(logrus.Fields{"key": leaf.Key}).Error("store: Unable to read job state")
+				continue
+			}
+			states[job.ID{Namespace: ns, Name: parts[len(parts)-1]}] = &state
+		}
+	}
+	return states, nil
+}
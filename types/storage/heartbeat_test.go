@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/byxorna/flow/types"
+	"github.com/byxorna/flow/types/execution"
+	"github.com/byxorna/flow/types/job"
+)
+
+func newTestJob(t *testing.T, s *KVStore, name string) *job.Spec {
+	j := &job.Spec{
+		ID:                 job.ID{Namespace: "default", Name: name},
+		Owner:              "test",
+		ScheduleString:     "@every 1h",
+		Executor:           types.ShellExecutor,
+		ExecutorParameters: map[string]string{"command": "true"},
+	}
+	if err := s.SetJob(j); err != nil {
+		t.Fatal(err)
+	}
+	return j
+}
+
+// startInstance stores an unfinished instance of a job started at t
+func startInstance(t *testing.T, s *KVStore, j *job.Spec, started time.Time) *execution.Instance {
+	i := execution.NewInstance(j.ID)
+	i.StartedAt = started
+	if _, err := s.SetExecution(i); err != nil {
+		t.Fatal(err)
+	}
+	return i
+}
+
+func TestFailLostInstances(t *testing.T) {
+	s := NewMemory()
+	now := time.Now()
+	started := now.Add(-time.Hour)
+
+	lost := newTestJob(t, s, "lost")
+	lostInstance := startInstance(t, s, lost, started)
+	alive := newTestJob(t, s, "alive")
+	aliveInstance := startInstance(t, s, alive, started)
+	if err := s.SetHeartbeat(aliveInstance, now.Add(-execution.HeartbeatInterval)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, j := range []*job.Spec{lost, alive} {
+		summary, err := s.GetJobSummary(j.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if summary.Status != job.Running {
+			t.Fatalf("expected %s to be running, got %s", j.ID.String(), summary.Status)
+		}
+	}
+
+	failed, err := s.FailLostInstances(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 1 || failed[0].ID != lostInstance.ID {
+		t.Fatalf("expected only %s to be failed, got %v", lostInstance.ID, failed)
+	}
+
+	i, err := s.GetExecution(lost.ID, lostInstance.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.FinishedAt.IsZero() || i.Success {
+		t.Errorf("expected the lost instance to be stored as failed, got %+v", i)
+	}
+	state, err := s.GetJobState(lost.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.ErrorCount != 1 {
+		t.Errorf("expected the lost instance to count as an error, got %d", state.ErrorCount)
+	}
+	if summary := execution.SummaryOf(state); summary.Status != job.Failed {
+		t.Errorf("expected the lost job to be failed, got %s", summary.Status)
+	}
+
+	summary, err := s.GetJobSummary(alive.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Status != job.Running {
+		t.Errorf("expected a job with a recent heartbeat to still be running, got %s", summary.Status)
+	}
+}
+
+func TestFailLostInstanceCancel(t *testing.T) {
+	s := NewMemory()
+	now := time.Now()
+	j := newTestJob(t, s, "lost")
+	i := startInstance(t, s, j, now.Add(-time.Hour))
+
+	ok, err := s.FailLostInstance(i, "someone", now)
+	if err != nil || !ok {
+		t.Fatalf("expected the lost instance to be cancelled, got %t, %v", ok, err)
+	}
+	if !i.Cancelled || i.CancelledBy != "someone" {
+		t.Errorf("expected the instance to be cancelled by someone, got %+v", i)
+	}
+	state, err := s.GetJobState(j.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.ErrorCount != 0 {
+		t.Errorf("expected a cancelled instance not to count as an error, got %d", state.ErrorCount)
+	}
+	if summary := execution.SummaryOf(state); summary.Status != job.Cancelled {
+		t.Errorf("expected the job to be cancelled, got %s", summary.Status)
+	}
+
+	ok, err = s.FailLostInstance(i, "someone", now)
+	if err != nil || ok {
+		t.Errorf("expected a finished instance to be left alone, got %t, %v", ok, err)
+	}
+}
@@ -0,0 +1,186 @@
+package storage
+
+// libkv backends disagree on what List and DeleteTree mean:
+//   - etcd returns the direct children of a directory, with full keys
+//   - zookeeper returns the direct children, keyed by name only
+//   - consul and boltdb return every key below a path, and match it as a
+//     plain string prefix, so listing jobs/foo also returns jobs/foobar/...
+//   - etcdv3 and memkv return every key below a path, like consul
+// children and deleteTree paper over the differences. etcdv3 and memkv can
+// also list a page of keys at a time, see rangeLister
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/docker/libkv/store"
+
+	"github.com/byxorna/flow/types/storage/etcdv3"
+	"github.com/byxorna/flow/types/storage/memkv"
+)
+
+// splitKey returns the segments of a key, ignoring leading, trailing and doubled slashes
+func splitKey(key string) []string {
+	return strings.FieldsFunc(key, func(r rune) bool { return r == '/' })
+}
+
+// prefixMatching is true for backends that treat a path as a plain string
+// prefix and return everything below it
+func (s *KVStore) prefixMatching() bool {
+	return s.backend == store.CONSUL || s.backend == store.BOLTDB ||
+		s.backend == etcdv3.ETCDV3 || s.backend == memkv.MEMORY
+}
+
+// rangeLister is implemented by backends that can list part of a directory.
+// ListRange returns at most limit values below dir with keys after the key
+// after, ordered by key. An empty after starts at the first key
+type rangeLister interface {
+	ListRange(dir string, after string, limit int) ([]*store.KVPair, error)
+}
+
+// walkPageSize is how many keys walkLeaves reads at a time from backends
+// that can list a range of keys
+const walkPageSize = 100
+
+// relative returns key relative to base, or false if key is not below base
+func relative(base []string, key string) ([]string, bool) {
+	parts := splitKey(key)
+	if len(parts) <= len(base) {
+		return nil, false
+	}
+	for i := range base {
+		if parts[i] != base[i] {
+			return nil, false
+		}
+	}
+	return parts[len(base):], true
+}
+
+// children returns the names of the directories directly below path, and the
+// values stored directly below it keyed by their full path. Backends that
+// have directories store them without a value, so empty values count as directories
+func (s *KVStore) children(path string) ([]string, []*store.KVPair, error) {
+	entries, err := s.Client.List(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	base := splitKey(path)
+	// keep the caller's form of the path, as boltdb does not normalize keys
+	prefix := strings.TrimRight(path, "/")
+
+	dirs := []string{}
+	seen := map[string]bool{}
+	leaves := []*store.KVPair{}
+	for _, e := range entries {
+		var rel []string
+		if s.prefixMatching() {
+			var ok bool
+			if rel, ok = relative(base, e.Key); !ok {
+				continue
+			}
+		} else {
+			parts := splitKey(e.Key)
+			if len(parts) == 0 {
+				continue
+			}
+			rel = parts[len(parts)-1:]
+		}
+
+		name := rel[0]
+		if len(rel) > 1 || len(e.Value) == 0 {
+			if !seen[name] {
+				seen[name] = true
+				dirs = append(dirs, name)
+			}
+			continue
+		}
+		leaves = append(leaves, &store.KVPair{
+			Key:       prefix + "/" + name,
+			Value:     e.Value,
+			LastIndex: e.LastIndex,
+		})
+	}
+	return dirs, leaves, nil
+}
+
+// leaves returns the values stored directly below path
+func (s *KVStore) leaves(path string) ([]*store.KVPair, error) {
+	_, leaves, err := s.children(path)
+	return leaves, err
+}
+
+// deleteTree deletes path and everything below it
+func (s *KVStore) deleteTree(path string) error {
+	if !s.prefixMatching() {
+		return s.Client.DeleteTree(path)
+	}
+	entries, err := s.Client.List(path)
+	if err != nil {
+		return err
+	}
+	base := splitKey(path)
+	for _, e := range entries {
+		if _, ok := relative(base, e.Key); !ok {
+			continue
+		}
+		if err := s.Client.Delete(e.Key); err != nil && err != store.ErrKeyNotFound {
+			return err
+		}
+	}
+	if err := s.Client.Delete(path); err != nil && err != store.ErrKeyNotFound {
+		return err
+	}
+	return nil
+}
+
+// walkLeaves calls fn with the values stored directly below dir in key
+// order, starting after the one named after, until fn returns false.
+// Backends that can list a range of keys are read a page at a time, so
+// nothing past where fn stops is read
+func (s *KVStore) walkLeaves(dir string, after string, fn func(*store.KVPair) (bool, error)) error {
+	ranger, ok := s.Client.(rangeLister)
+	if !ok {
+		leaves, err := s.leaves(dir)
+		if err == store.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		sort.Slice(leaves, func(i, j int) bool { return leaves[i].Key < leaves[j].Key })
+		for _, pair := range leaves {
+			if after != "" && path.Base(pair.Key) <= after {
+				continue
+			}
+			if more, err := fn(pair); err != nil || !more {
+				return err
+			}
+		}
+		return nil
+	}
+
+	base := splitKey(dir)
+	next := ""
+	if after != "" {
+		next = strings.TrimRight(dir, "/") + "/" + after
+	}
+	for {
+		pairs, err := ranger.ListRange(dir, next, walkPageSize)
+		if err != nil {
+			return err
+		}
+		for _, pair := range pairs {
+			next = pair.Key
+			if rel, ok := relative(base, pair.Key); !ok || len(rel) != 1 {
+				continue
+			}
+			if more, err := fn(pair); err != nil || !more {
+				return err
+			}
+		}
+		if len(pairs) < walkPageSize {
+			return nil
+		}
+	}
+}
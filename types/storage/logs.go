@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/byxorna/flow/types/execution"
+	"github.com/byxorna/flow/types/job"
+	"github.com/docker/libkv/store"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// GetInstance returns an execution instance by its ID alone
+func (s *KVStore) GetInstance(instance uuid.UUID) (*execution.Instance, error) {
+	res, err := s.Client.Get(execution.LogIndexPath(s.keyspace, instance))
+	if err != nil {
+		return nil, err
+	}
+	var id job.ID
+	if err := json.Unmarshal(res.Value, &id); err != nil {
+		return nil, err
+	}
+	return s.GetExecution(id, instance)
+}
+
+// AppendLog stores a chunk of output written by an instance
+func (s *KVStore) AppendLog(i *execution.Instance, c *execution.LogChunk) error {
+	cJSON, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return s.Client.Put(execution.LogChunkPath(s.keyspace, i.ID, c.Stream, c.Seq), cJSON, nil)
+}
+
+// GetLogs returns the chunks of a stream of an instance's output, in order,
+// starting at sequence number from
+func (s *KVStore) GetLogs(instance uuid.UUID, stream string, from int) ([]*execution.LogChunk, error) {
+	res, err := s.leaves(execution.LogStreamPath(s.keyspace, instance, stream))
+	if err != nil {
+		if err == store.ErrKeyNotFound {
+			return []*execution.LogChunk{}, nil
+		}
+		return nil, err
+	}
+
+	chunks := []*execution.LogChunk{}
+	for _, node := range res {
+		path := store.SplitKey(node.Key)
+		seq, err := strconv.Atoi(path[len(path)-1])
+		if err != nil || seq < from {
+			continue
+		}
+		var c execution.LogChunk
+		if err := json.Unmarshal(node.Value, &c); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, &c)
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].Seq < chunks[j].Seq })
+	return chunks, nil
+}
+
+// DeleteLogs removes all output of an instance
+func (s *KVStore) DeleteLogs(instance uuid.UUID) error {
+	err := s.deleteTree(execution.LogPath(s.keyspace, instance))
+	if err == store.ErrKeyNotFound {
+		return nil
+	}
+	return err
+}
+
+// setLogIndex records which job an instance belongs to, so it can be found by ID
+func (s *KVStore) setLogIndex(i *execution.Instance) error {
+	idJSON, err := json.Marshal(i.Job)
+	if err != nil {
+		return err
+	}
+	return s.Client.Put(execution.LogIndexPath(s.keyspace, i.ID), idJSON, nil)
+}
+
+const (
+	// logFlushInterval is how long output is buffered before it is stored
+	logFlushInterval = 1 * time.Second
+	// logChunkSize is how much output is buffered before it is stored
+	// without waiting for logFlushInterval
+	logChunkSize = 64 << 10
+	// maxLogBytes is how much output of a stream is stored. Anything after
+	// that is dropped
+	maxLogBytes = 16 << 20
+)
+
+// LogWriter is an io.Writer that stores everything written to it as chunks of
+// an instance's output, so it can be read while the instance is still running.
+// Output is buffered, and stored once a second or whenever a chunk fills up.
+// Storage errors are logged and the output dropped rather than returned, so a
+// storage hiccup never fails or blocks the process writing. Close must be
+// called once the process exits, to store what is left in the buffer
+type LogWriter struct {
+	mu       sync.Mutex
+	store    Store
+	instance *execution.Instance
+	stream   string
+	seq      int
+	buf      []byte
+	timer    *time.Timer
+	// written is how many bytes were accepted, stored or not
+	written   int
+	truncated bool
+}
+
+// NewLogWriter returns a LogWriter for a stream of an instance's output
+func (s *KVStore) NewLogWriter(i *execution.Instance, stream string) *LogWriter {
+	return &LogWriter{store: s, instance: i, stream: stream}
+}
+
+// Write buffers p, storing the buffer if it is full. It always accepts all of p
+func (w *LogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n := len(p)
+	if room := maxLogBytes - w.written; len(p) > room {
+		if room < 0 {
+			room = 0
+		}
+		p = p[:room]
+		if !w.truncated {
+			w.truncated = true
+			log.WithFields(logrus.Fields{"instance": w.instance.ID, "stream": w.stream}).
+				Warnf("store: Output is over %d bytes, dropping the rest", maxLogBytes)
+		}
+	}
+	w.written += len(p)
+	w.buf = append(w.buf, p...)
+	for len(w.buf) >= logChunkSize {
+		w.save(w.buf[:logChunkSize])
+		w.buf = w.buf[logChunkSize:]
+	}
+	if len(w.buf) > 0 && w.timer == nil {
+		w.timer = time.AfterFunc(logFlushInterval, w.Flush)
+	}
+	return n, nil
+}
+
+// Flush stores whatever is buffered
+func (w *LogWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.flush()
+}
+
+// Close stores whatever is buffered. Nothing should be written after
+func (w *LogWriter) Close() error {
+	w.Flush()
+	return nil
+}
+
+// flush stores the buffer. Must be called with the lock held
+func (w *LogWriter) flush() {
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	if len(w.buf) > 0 {
+		w.save(w.buf)
+		w.buf = nil
+	}
+}
+
+// save appends data as the next chunk of output. Must be called with the lock held
+func (w *LogWriter) save(data []byte) {
+	c := execution.LogChunk{
+		Stream: w.stream,
+		Seq:    w.seq,
+		Time:   time.Now(),
+		Data:   append([]byte{}, data...),
+	}
+	if err := w.store.AppendLog(w.instance, &c); err != nil {
+		log.WithError(err).WithFields(logrus.Fields{"instance": w.instance.ID, "stream": w.stream, "bytes": len(data)}).
+			Error("store: Unable to store output, dropping it")
+	}
+	// a dropped chunk leaves a gap in the sequence, rather than being
+	// mistaken for the output that follows it
+	w.seq++
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/byxorna/flow/types/execution"
+	"github.com/byxorna/flow/types/job"
+)
+
+func TestLogWriterBuffers(t *testing.T) {
+	s := NewMemory()
+	i := execution.NewInstance(job.ID{Namespace: "default", Name: "logs"})
+	w := s.NewLogWriter(i, execution.Stdout)
+
+	for n := 0; n < 100; n++ {
+		fmt.Fprintf(w, "line %d\n", n)
+	}
+	chunks, err := s.GetLogs(i.ID, execution.Stdout, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 0 {
+		t.Errorf("expected small writes to be buffered, got %d chunks", len(chunks))
+	}
+
+	w.Close()
+	chunks, err = s.GetLogs(i.ID, execution.Stdout, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 1 {
+		t.Fatalf("expected one chunk after closing, got %d", len(chunks))
+	}
+	if !bytes.HasPrefix(chunks[0].Data, []byte("line 0\n")) || !bytes.HasSuffix(chunks[0].Data, []byte("line 99\n")) {
+		t.Errorf("unexpected output %q", chunks[0].Data)
+	}
+}
+
+func TestLogWriterFlushesOnInterval(t *testing.T) {
+	s := NewMemory()
+	i := execution.NewInstance(job.ID{Namespace: "default", Name: "logs"})
+	w := s.NewLogWriter(i, execution.Stdout)
+	defer w.Close()
+
+	w.Write([]byte("hello\n"))
+	deadline := time.Now().Add(logFlushInterval * 5)
+	for {
+		chunks, err := s.GetLogs(i.ID, execution.Stdout, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(chunks) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("buffered output was not stored while the writer was open")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestLogWriterChunksAndLimit(t *testing.T) {
+	s := NewMemory()
+	i := execution.NewInstance(job.ID{Namespace: "default", Name: "logs"})
+	w := s.NewLogWriter(i, execution.Stdout)
+
+	block := bytes.Repeat([]byte("x"), logChunkSize)
+	for written := 0; written < maxLogBytes+2*logChunkSize; written += len(block) {
+		if n, err := w.Write(block); err != nil || n != len(block) {
+			t.Fatalf("expected the whole write to be accepted, got %d, %v", n, err)
+		}
+	}
+	w.Close()
+
+	chunks, err := s.GetLogs(i.ID, execution.Stdout, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != maxLogBytes/logChunkSize {
+		t.Errorf("expected output past the limit to be dropped, got %d chunks", len(chunks))
+	}
+}
+
+// failingStore fails to store any output
+type failingStore struct {
+	Store
+}
+
+func (failingStore) AppendLog(i *execution.Instance, c *execution.LogChunk) error {
+	return fmt.Errorf("storage is down")
+}
+
+func TestLogWriterDropsOnError(t *testing.T) {
+	i := execution.NewInstance(job.ID{Namespace: "default", Name: "logs"})
+	w := &LogWriter{store: failingStore{}, instance: i, stream: execution.Stdout}
+
+	block := bytes.Repeat([]byte("x"), logChunkSize+1)
+	if n, err := w.Write(block); err != nil || n != len(block) {
+		t.Errorf("expected a storage error not to fail the write, got %d, %v", n, err)
+	}
+	w.Close()
+}
@@ -0,0 +1,383 @@
+package memkv
+
+// memkv is a libkv backend that keeps everything in memory. It supports the
+// whole store.Store interface, including watches, compare-and-swap and locks,
+// so flow can run without a cluster for development and tests. Like consul,
+// List and DeleteTree return everything below a directory
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/docker/libkv"
+	"github.com/docker/libkv/store"
+)
+
+const (
+	// MEMORY is the backend name to pass to libkv.NewStore
+	MEMORY store.Backend = "memory"
+)
+
+// Register registers the in-memory backend with libkv
+func Register() {
+	libkv.AddStore(MEMORY, New)
+}
+
+// Memory is an in-memory key-value store
+type Memory struct {
+	sync.Mutex
+	data     map[string]*store.KVPair
+	index    uint64
+	watchers map[*watcher]bool
+}
+
+// watcher is woken whenever a key it watches changes
+type watcher struct {
+	key    string
+	tree   bool
+	notify chan struct{}
+}
+
+// New returns an empty store. Endpoints and options are ignored; every call
+// returns a separate store
+func New(endpoints []string, options *store.Config) (store.Store, error) {
+	return &Memory{
+		data:     map[string]*store.KVPair{},
+		watchers: map[*watcher]bool{},
+	}, nil
+}
+
+// normalize turns a key into /a/b form
+func normalize(key string) string {
+	parts := strings.FieldsFunc(key, func(r rune) bool { return r == '/' })
+	return "/" + strings.Join(parts, "/")
+}
+
+// below returns true if key is strictly below directory
+func below(key string, directory string) bool {
+	if directory == "/" {
+		return key != "/"
+	}
+	return strings.HasPrefix(key, directory+"/")
+}
+
+// set stores a value and wakes watchers. Must be called with the lock held
+func (m *Memory) set(key string, value []byte) *store.KVPair {
+	m.index++
+	pair := &store.KVPair{Key: key, Value: append([]byte{}, value...), LastIndex: m.index}
+	m.data[key] = pair
+	m.changed(key)
+	return copyPair(pair)
+}
+
+// remove deletes a value and wakes watchers. Must be called with the lock held
+func (m *Memory) remove(key string) {
+	m.index++
+	delete(m.data, key)
+	m.changed(key)
+}
+
+func (m *Memory) changed(key string) {
+	for w := range m.watchers {
+		if w.key == key || (w.tree && below(key, w.key)) {
+			select {
+			case w.notify <- struct{}{}:
+			default:
+				// already woken
+			}
+		}
+	}
+}
+
+func copyPair(p *store.KVPair) *store.KVPair {
+	return &store.KVPair{Key: p.Key, Value: append([]byte{}, p.Value...), LastIndex: p.LastIndex}
+}
+
+// Put stores a value. Directories are implicit, so IsDir puts are ignored
+func (m *Memory) Put(key string, value []byte, options *store.WriteOptions) error {
+	if options != nil && options.IsDir {
+		return nil
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.set(normalize(key), value)
+	return nil
+}
+
+// Get returns a value
+func (m *Memory) Get(key string) (*store.KVPair, error) {
+	m.Lock()
+	defer m.Unlock()
+	p, ok := m.data[normalize(key)]
+	if !ok {
+		return nil, store.ErrKeyNotFound
+	}
+	return copyPair(p), nil
+}
+
+// Delete deletes a value
+func (m *Memory) Delete(key string) error {
+	m.Lock()
+	defer m.Unlock()
+	key = normalize(key)
+	if _, ok := m.data[key]; !ok {
+		return store.ErrKeyNotFound
+	}
+	m.remove(key)
+	return nil
+}
+
+// Exists returns true if a value is stored at key, or below it
+func (m *Memory) Exists(key string) (bool, error) {
+	m.Lock()
+	defer m.Unlock()
+	key = normalize(key)
+	if _, ok := m.data[key]; ok {
+		return true, nil
+	}
+	for k := range m.data {
+		if below(k, key) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// List returns every value below a directory, ordered by key
+func (m *Memory) List(directory string) ([]*store.KVPair, error) {
+	m.Lock()
+	defer m.Unlock()
+	return m.list(normalize(directory))
+}
+
+func (m *Memory) list(directory string) ([]*store.KVPair, error) {
+	pairs := []*store.KVPair{}
+	for k, p := range m.data {
+		if below(k, directory) {
+			pairs = append(pairs, copyPair(p))
+		}
+	}
+	if len(pairs) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+	return pairs, nil
+}
+
+// ListRange returns at most limit values below a directory with keys after
+// the key after, ordered by key
+func (m *Memory) ListRange(directory string, after string, limit int) ([]*store.KVPair, error) {
+	m.Lock()
+	defer m.Unlock()
+	directory = normalize(directory)
+	if after != "" {
+		after = normalize(after)
+	}
+	pairs := []*store.KVPair{}
+	for k, p := range m.data {
+		if below(k, directory) && k > after {
+			pairs = append(pairs, copyPair(p))
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+	if len(pairs) > limit {
+		pairs = pairs[:limit]
+	}
+	return pairs, nil
+}
+
+// DeleteTree deletes a directory and everything below it
+func (m *Memory) DeleteTree(directory string) error {
+	m.Lock()
+	defer m.Unlock()
+	directory = normalize(directory)
+	for k := range m.data {
+		if k == directory || below(k, directory) {
+			m.remove(k)
+		}
+	}
+	return nil
+}
+
+// AtomicPut stores a value if the stored one is still at the index of
+// previous. A nil previous only succeeds if nothing is stored yet
+func (m *Memory) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	m.Lock()
+	defer m.Unlock()
+	key = normalize(key)
+	current, ok := m.data[key]
+	switch {
+	case previous == nil && ok:
+		return false, nil, store.ErrKeyExists
+	case previous != nil && !ok:
+		return false, nil, store.ErrKeyNotFound
+	case previous != nil && current.LastIndex != previous.LastIndex:
+		return false, nil, store.ErrKeyModified
+	}
+	return true, m.set(key, value), nil
+}
+
+// AtomicDelete deletes a value if it is still at the index of previous
+func (m *Memory) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	if previous == nil {
+		return false, store.ErrPreviousNotSpecified
+	}
+	m.Lock()
+	defer m.Unlock()
+	key = normalize(key)
+	current, ok := m.data[key]
+	if !ok {
+		return false, store.ErrKeyNotFound
+	}
+	if current.LastIndex != previous.LastIndex {
+		return false, store.ErrKeyModified
+	}
+	m.remove(key)
+	return true, nil
+}
+
+// watch registers a watcher until stopCh is closed
+func (m *Memory) watch(key string, tree bool, stopCh <-chan struct{}) *watcher {
+	w := &watcher{key: key, tree: tree, notify: make(chan struct{}, 1)}
+	m.Lock()
+	m.watchers[w] = true
+	m.Unlock()
+	go func() {
+		<-stopCh
+		m.Lock()
+		delete(m.watchers, w)
+		m.Unlock()
+	}()
+	// the current value is sent right away
+	w.notify <- struct{}{}
+	return w
+}
+
+// Watch sends the value of key when the watch starts, and whenever it
+// changes. Deleting the key ends the watch
+func (m *Memory) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	key = normalize(key)
+	if _, err := m.Get(key); err != nil {
+		return nil, err
+	}
+	w := m.watch(key, false, stopCh)
+	out := make(chan *store.KVPair)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-w.notify:
+			}
+			p, err := m.Get(key)
+			if err != nil {
+				return
+			}
+			select {
+			case out <- p:
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// WatchTree sends everything below a directory when the watch starts, and
+// whenever anything below it changes
+func (m *Memory) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	directory = normalize(directory)
+	w := m.watch(directory, true, stopCh)
+	out := make(chan []*store.KVPair)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-w.notify:
+			}
+			pairs, err := m.List(directory)
+			if err == store.ErrKeyNotFound {
+				pairs = []*store.KVPair{}
+			}
+			select {
+			case out <- pairs:
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// NewLock returns a lock on key
+func (m *Memory) NewLock(key string, options *store.LockOptions) (store.Locker, error) {
+	var value []byte
+	if options != nil {
+		value = options.Value
+	}
+	return &lock{m: m, key: normalize(key), value: value}, nil
+}
+
+// Close does nothing, as there is no connection
+func (m *Memory) Close() {}
+
+type lock struct {
+	m     *Memory
+	key   string
+	value []byte
+	held  *store.KVPair
+	lost  chan struct{}
+}
+
+// Lock blocks until the lock is held, or stopChan is closed. The returned
+// channel is closed if the lock is lost, which only happens if its key is
+// deleted or overwritten by someone else
+func (l *lock) Lock(stopChan chan struct{}) (<-chan struct{}, error) {
+	done := make(chan struct{})
+	defer close(done)
+	w := l.m.watch(l.key, false, done)
+	for {
+		_, pair, err := l.m.AtomicPut(l.key, l.value, nil, nil)
+		if err == nil {
+			l.held = pair
+			l.lost = make(chan struct{})
+			go l.watchLost(pair)
+			return l.lost, nil
+		}
+		if err != store.ErrKeyExists {
+			return nil, err
+		}
+		select {
+		case <-stopChan:
+			return nil, store.ErrCannotLock
+		case <-w.notify:
+		}
+	}
+}
+
+func (l *lock) watchLost(pair *store.KVPair) {
+	stop := make(chan struct{})
+	defer close(stop)
+	w := l.m.watch(l.key, false, stop)
+	for range w.notify {
+		current, err := l.m.Get(l.key)
+		if err != nil || current.LastIndex != pair.LastIndex {
+			close(l.lost)
+			return
+		}
+	}
+}
+
+// Unlock releases the lock
+func (l *lock) Unlock() error {
+	if l.held == nil {
+		return nil
+	}
+	_, err := l.m.AtomicDelete(l.key, l.held)
+	l.held = nil
+	return err
+}
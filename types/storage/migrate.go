@@ -0,0 +1,329 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/sirupsen/logrus"
+
+	"github.com/byxorna/flow/types/job"
+)
+
+var (
+	// ErrSchemaTooNew is returned when storage was migrated by a newer flow
+	// than this one, which cannot know what changed
+	ErrSchemaTooNew = fmt.Errorf("storage schema is newer than this version of flow supports")
+)
+
+const (
+	// schemaPath is where the schema version is stored, below the keyspace
+	schemaPath = "schema"
+	// migrationLockPath is the lock held while migrating
+	migrationLockPath = "locks/migrate"
+	// migrationLockTimeout is how long to wait for another node to finish migrating
+	migrationLockTimeout = 5 * time.Minute
+)
+
+// Migration is a step from one schema version to the next. Apply must be
+// safe to run again on data it already migrated, and must not write
+// anything when dryRun is set. It returns a description of every change
+type Migration struct {
+	Version     int
+	Description string
+	Apply       func(s *KVStore, dryRun bool) ([]string, error)
+}
+
+// migrations are every step to the current schema, in order. Only ever
+// append to this list
+var migrations = []Migration{
+	{Version: 1, Description: "move run counters from job specs into their status", Apply: migrateJobCounters},
+	{Version: 2, Description: "record the outcome of each job's last run in its status", Apply: migrateLatestRuns},
+	{Version: 3, Description: "key audit events newest first", Apply: migrateAuditKeys},
+}
+
+// SchemaVersion is the schema this version of flow reads and writes
+var SchemaVersion = migrations[len(migrations)-1].Version
+
+// schema is the stored schema version
+type schema struct {
+	Version   int       `json:"version"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// MigrationResult is what a migration changed, or would change
+type MigrationResult struct {
+	Version     int      `json:"version"`
+	Description string   `json:"description"`
+	Changes     []string `json:"changes"`
+}
+
+// MigrationReport is the outcome of Migrate
+type MigrationReport struct {
+	From    int               `json:"from"`
+	To      int               `json:"to"`
+	DryRun  bool              `json:"dry_run"`
+	Applied []MigrationResult `json:"applied"`
+}
+
+// GetSchemaVersion returns the schema version of storage. Storage that
+// predates schema versions is at version 0
+func (s *KVStore) GetSchemaVersion() (int, error) {
+	pair, err := s.Client.Get(fmt.Sprintf("%s/%s", s.keyspace, schemaPath))
+	if err != nil {
+		if err == store.ErrKeyNotFound {
+			return 0, nil
+		}
+		return 0, err
+	}
+	var v schema
+	if err := json.Unmarshal(pair.Value, &v); err != nil {
+		return 0, fmt.Errorf("unable to read schema version: %s", err)
+	}
+	return v.Version, nil
+}
+
+func (s *KVStore) setSchemaVersion(version int) error {
+	b, err := json.Marshal(&schema{Version: version, UpdatedAt: time.Now()})
+	if err != nil {
+		return err
+	}
+	return s.Client.Put(fmt.Sprintf("%s/%s", s.keyspace, schemaPath), b, nil)
+}
+
+// Migrate brings storage up to SchemaVersion, recording the version after
+// every step so an interrupted migration picks up where it stopped. It holds
+// a lock while migrating, so only one node migrates at a time. With dryRun
+// set, nothing is written and the report says what would change
+func (s *KVStore) Migrate(dryRun bool) (*MigrationReport, error) {
+	if !dryRun {
+		unlock, err := s.lockMigrations()
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+
+	from, err := s.GetSchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	if from > SchemaVersion {
+		return nil, fmt.Errorf("%s: storage is at version %d, this flow supports up to %d", ErrSchemaTooNew, from, SchemaVersion)
+	}
+
+	report := &MigrationReport{From: from, To: from, DryRun: dryRun, Applied: []MigrationResult{}}
+	for _, m := range migrations {
+		if m.Version <= from {
+			continue
+		}
+		logger := log.WithFields(logrus.Fields{"version": m.Version, "dry_run": dryRun})
+		logger.Infof("store: Migrating: %s", m.Description)
+		changes, err := m.Apply(s, dryRun)
+		if err != nil {
+			return report, fmt.Errorf("migration to version %d failed: %s", m.Version, err)
+		}
+		report.Applied = append(report.Applied, MigrationResult{
+			Version:     m.Version,
+			Description: m.Description,
+			Changes:     changes,
+		})
+		report.To = m.Version
+		if dryRun {
+			continue
+		}
+		if err := s.setSchemaVersion(m.Version); err != nil {
+			return report, err
+		}
+		logger.WithFields(logrus.Fields{"changes": len(changes)}).Info("store: Migrated")
+	}
+	return report, nil
+}
+
+// lockMigrations takes the migration lock, and returns a func to release
+// it. boltdb has no locks, but its file lock already keeps other processes out
+func (s *KVStore) lockMigrations() (func(), error) {
+	hostname, _ := os.Hostname()
+	lock, err := s.Client.NewLock(
+		fmt.Sprintf("%s/%s", s.keyspace, migrationLockPath),
+		&store.LockOptions{Value: []byte(hostname)},
+	)
+	if err == store.ErrCallNotSupported {
+		return func() {}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	stop := make(chan struct{})
+	timer := time.AfterFunc(migrationLockTimeout, func() { close(stop) })
+	defer timer.Stop()
+	log.Debug("store: Waiting for the migration lock")
+	if _, err := lock.Lock(stop); err != nil {
+		return nil, fmt.Errorf("unable to take the migration lock: %s", err)
+	}
+	return func() {
+		if err := lock.Unlock(); err != nil {
+			log.WithError(err).Error("store: Unable to release the migration lock")
+		}
+	}, nil
+}
+
+// jobEntries returns the stored value of every job, in every namespace
+func (s *KVStore) jobEntries() ([]*store.KVPair, error) {
+	path := fmt.Sprintf("%s/%s", s.keyspace, job.StoragePath)
+	namespaces, _, err := s.children(path)
+	if err == store.ErrKeyNotFound {
+		return []*store.KVPair{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries := []*store.KVPair{}
+	for _, ns := range namespaces {
+		leaves, err := s.leaves(fmt.Sprintf("%s/%s", path, ns))
+		if err == store.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, leaves...)
+	}
+	return entries, nil
+}
+
+// legacyCounters are the run counters job specs carried before runtime
+// state was stored apart from them
+var legacyCounters = []string{"success_count", "error_count", "last_success", "last_error"}
+
+// migrateJobCounters moves run counters left in job specs into the job's
+// status, adding them to whatever the status counted since. The status is
+// written first, marked with the version of the spec the counters came
+// from, and the spec is cleaned after with a compare-and-swap. If the spec
+// cannot be cleaned, running the migration again finds the mark and only
+// cleans it, so counters are never added twice. Jobs that cannot be parsed
+// are reported and skipped
+func migrateJobCounters(s *KVStore, dryRun bool) ([]string, error) {
+	entries, err := s.jobEntries()
+	if err != nil {
+		return nil, err
+	}
+	changes := []string{}
+	for _, entry := range entries {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(entry.Value, &fields); err != nil {
+			changes = append(changes, fmt.Sprintf("job %s: skipped, cannot be parsed: %s", entry.Key, err))
+			continue
+		}
+		found := false
+		for _, f := range legacyCounters {
+			if _, ok := fields[f]; ok {
+				found = true
+				delete(fields, f)
+			}
+		}
+		if !found {
+			continue
+		}
+
+		var legacy job.State
+		if err := json.Unmarshal(entry.Value, &legacy); err != nil {
+			changes = append(changes, fmt.Sprintf("job %s: skipped, counters cannot be parsed: %s", entry.Key, err))
+			continue
+		}
+		spec, err := parseJobEntry(entry)
+		if err != nil {
+			changes = append(changes, fmt.Sprintf("job %s: skipped, %s", entry.Key, err))
+			continue
+		}
+		changes = append(changes, fmt.Sprintf("job %s: move %d successes and %d errors into its status",
+			spec.ID.String(), legacy.SuccessCount, legacy.ErrorCount))
+		if dryRun {
+			continue
+		}
+
+		cleaned, err := json.Marshal(fields)
+		if err != nil {
+			return nil, err
+		}
+		_, err = s.UpdateJobState(spec.ID, func(state *job.State) {
+			if state.CountersMigrated == entry.LastIndex {
+				return
+			}
+			state.CountersMigrated = entry.LastIndex
+			state.SuccessCount += legacy.SuccessCount
+			state.ErrorCount += legacy.ErrorCount
+			if legacy.LastSuccess.After(state.LastSuccess) {
+				state.LastSuccess = legacy.LastSuccess
+			}
+			if legacy.LastError.After(state.LastError) {
+				state.LastError = legacy.LastError
+			}
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to update status of job %s: %s", spec.ID.String(), err)
+		}
+		if _, _, err := s.Client.AtomicPut(entry.Key, cleaned, entry, nil); err != nil {
+			return nil, fmt.Errorf("unable to rewrite job %s: %s", spec.ID.String(), err)
+		}
+	}
+	return changes, nil
+}
+
+// parseJobEntry reads a stored job far enough to migrate it
+func parseJobEntry(entry *store.KVPair) (*job.Spec, error) {
+	var spec job.Spec
+	if err := json.Unmarshal(entry.Value, &spec); err != nil {
+		return nil, fmt.Errorf("cannot be parsed: %s", err)
+	}
+	if spec.ID.Namespace == "" || spec.ID.Name == "" {
+		return nil, fmt.Errorf("has no id")
+	}
+	return &spec, nil
+}
+
+// migrateLatestRuns records the outcome of the last execution group of every
+// job in its status, which is where job listings now read it from. Jobs
+// whose status already has it are skipped, and jobs that cannot be parsed
+// are reported and skipped
+func migrateLatestRuns(s *KVStore, dryRun bool) ([]string, error) {
+	entries, err := s.jobEntries()
+	if err != nil {
+		return nil, err
+	}
+	changes := []string{}
+	for _, entry := range entries {
+		spec, err := parseJobEntry(entry)
+		if err != nil {
+			changes = append(changes, fmt.Sprintf("job %s: skipped, %s", entry.Key, err))
+			continue
+		}
+		state, err := s.GetJobState(spec.ID)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read status of job %s: %s", spec.ID.String(), err)
+		}
+		if state.Latest != nil {
+			continue
+		}
+		group, err := s.GetLastExecutionGroup(spec.ID)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read executions of job %s: %s", spec.ID.String(), err)
+		}
+		if len(group) == 0 {
+			continue
+		}
+		changes = append(changes, fmt.Sprintf("job %s: record the outcome of execution group %d", spec.ID.String(), group[0].Group))
+		if dryRun {
+			continue
+		}
+		for _, i := range group {
+			if err := s.updateLatest(i); err != nil {
+				return nil, fmt.Errorf("unable to update status of job %s: %s", spec.ID.String(), err)
+			}
+		}
+	}
+	return changes, nil
+}
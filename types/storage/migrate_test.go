@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/byxorna/flow/types/job"
+)
+
+// putLegacyJob stores j as a spec still carrying run counters, as specs did
+// before state was stored apart from them
+func putLegacyJob(t *testing.T, s *KVStore, j *job.Spec, successes, errors int) {
+	b, err := json.Marshal(j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		t.Fatal(err)
+	}
+	fields["success_count"] = successes
+	fields["error_count"] = errors
+	if b, err = json.Marshal(fields); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Client.Put(job.Prefix(s.keyspace, j.ID), b, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// putCorruptJob stores a job that cannot be parsed
+func putCorruptJob(t *testing.T, s *KVStore, name string) {
+	if err := s.Client.Put(job.Prefix(s.keyspace, job.ID{Namespace: "default", Name: name}), []byte("{not json"), nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func skipped(changes []string) int {
+	n := 0
+	for _, c := range changes {
+		if strings.Contains(c, "skipped") {
+			n++
+		}
+	}
+	return n
+}
+
+func TestMigrateJobCounters(t *testing.T) {
+	s := NewMemory()
+	j := newTestJob(t, s, "legacy")
+	putLegacyJob(t, s, j, 3, 2)
+	putCorruptJob(t, s, "broken")
+
+	changes, err := migrateJobCounters(s, false)
+	if err != nil {
+		t.Fatalf("expected a corrupt job not to stop the migration, got %s", err)
+	}
+	if len(changes) != 2 || skipped(changes) != 1 {
+		t.Errorf("expected one job moved and one reported as skipped, got %v", changes)
+	}
+	state, err := s.GetJobState(j.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.SuccessCount != 3 || state.ErrorCount != 2 {
+		t.Errorf("expected 3 successes and 2 errors, got %d and %d", state.SuccessCount, state.ErrorCount)
+	}
+	if _, err := s.GetJob(j.ID); err != nil {
+		t.Errorf("expected the cleaned job to be readable, got %s", err)
+	}
+	if changes, err = migrateJobCounters(s, false); err != nil || skipped(changes) != len(changes) {
+		t.Errorf("expected nothing left to move, got %v, %v", changes, err)
+	}
+}
+
+func TestMigrateJobCountersResumes(t *testing.T) {
+	s := NewMemory()
+	j := newTestJob(t, s, "legacy")
+	putLegacyJob(t, s, j, 3, 2)
+	pair, err := s.Client.Get(job.Prefix(s.keyspace, j.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a migration that stopped after the status was written, but before
+	// the spec was cleaned
+	if _, err := s.UpdateJobState(j.ID, func(state *job.State) {
+		state.SuccessCount, state.ErrorCount = 3, 2
+		state.CountersMigrated = pair.LastIndex
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := migrateJobCounters(s, false); err != nil {
+		t.Fatal(err)
+	}
+	state, err := s.GetJobState(j.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.SuccessCount != 3 || state.ErrorCount != 2 {
+		t.Errorf("expected counters not to be added twice, got %d successes and %d errors", state.SuccessCount, state.ErrorCount)
+	}
+	cleaned, err := s.Client.Get(job.Prefix(s.keyspace, j.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(cleaned.Value), "success_count") {
+		t.Errorf("expected the spec to be cleaned, got %s", cleaned.Value)
+	}
+}
+
+func TestMigrateLatestRuns(t *testing.T) {
+	s := NewMemory()
+	j := newTestJob(t, s, "ran")
+	startInstance(t, s, j, time.Now())
+	// forget the outcome, as storage written before it was recorded would
+	if err := s.Client.Delete(job.StatePath(s.keyspace, j.ID)); err != nil {
+		t.Fatal(err)
+	}
+	putCorruptJob(t, s, "broken")
+
+	changes, err := migrateLatestRuns(s, false)
+	if err != nil {
+		t.Fatalf("expected a corrupt job not to stop the migration, got %s", err)
+	}
+	if len(changes) != 2 || skipped(changes) != 1 {
+		t.Errorf("expected one job recorded and one reported as skipped, got %v", changes)
+	}
+	state, err := s.GetJobState(j.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Latest == nil {
+		t.Error("expected the outcome of the last run to be recorded")
+	}
+}
@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/byxorna/flow/types/job"
+	"github.com/byxorna/flow/types/namespace"
+	"github.com/docker/libkv/store"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrNamespaceNotEmpty is returned when deleting a namespace that still has jobs
+	ErrNamespaceNotEmpty = fmt.Errorf("namespace still has jobs, delete them first or cascade")
+)
+
+// GetNamespace returns a namespace
+func (s *KVStore) GetNamespace(name string) (*namespace.Namespace, error) {
+	res, err := s.Client.Get(namespace.Path(s.keyspace, name))
+	if err != nil {
+		return nil, err
+	}
+	var n namespace.Namespace
+	if err := json.Unmarshal(res.Value, &n); err != nil {
+		return nil, err
+	}
+	n.ResourceVersion = res.LastIndex
+	return &n, nil
+}
+
+// GetNamespaces returns every namespace
+func (s *KVStore) GetNamespaces() ([]*namespace.Namespace, error) {
+	path := fmt.Sprintf("%s/%s", s.keyspace, namespace.StoragePath)
+	entries, err := s.leaves(path)
+	if err != nil {
+		if err == store.ErrKeyNotFound {
+			return []*namespace.Namespace{}, nil
+		}
+		return nil, err
+	}
+	namespaces := make([]*namespace.Namespace, 0, len(entries))
+	for _, entry := range entries {
+		if len(entry.Value) == 0 {
+			continue
+		}
+		var n namespace.Namespace
+		if err := json.Unmarshal(entry.Value, &n); err != nil {
+			return nil, err
+		}
+		n.ResourceVersion = entry.LastIndex
+		namespaces = append(namespaces, &n)
+	}
+	return namespaces, nil
+}
+
+// SetNamespace creates or replaces a namespace. If version is not 0, the
+// namespace must still be stored at that version
+func (s *KVStore) SetNamespace(n *namespace.Namespace, version uint64) error {
+	if err := n.Validate(); err != nil {
+		return err
+	}
+	path := namespace.Path(s.keyspace, n.Name)
+	log.WithFields(logrus.Fields{"namespace": n.Name}).Debug("store: Setting namespace")
+
+	var previous *store.KVPair
+	if version != 0 {
+		previous = &store.KVPair{Key: path, LastIndex: version}
+	} else {
+		pair, err := s.Client.Get(path)
+		if err != nil && err != store.ErrKeyNotFound {
+			return err
+		}
+		previous = pair
+	}
+
+	n.ResourceVersion = 0
+	nJSON, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	_, newPair, err := s.Client.AtomicPut(path, nJSON, previous, nil)
+	if err == store.ErrKeyModified || err == store.ErrKeyExists {
+		if version != 0 {
+			return ErrVersionMismatch
+		}
+		return ErrConflict
+	}
+	if err != nil {
+		return err
+	}
+	if newPair != nil {
+		n.ResourceVersion = newPair.LastIndex
+	}
+	return nil
+}
+
+// DeleteNamespace deletes a namespace. Unless cascade is set, it fails with
+// ErrNamespaceNotEmpty while the namespace has jobs; with it, the jobs are
+// deleted too. The jobs that were deleted are returned, even on error
+func (s *KVStore) DeleteNamespace(name string, cascade bool) (*namespace.Namespace, []*job.Spec, error) {
+	n, err := s.GetNamespace(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	jobs, err := s.GetJobs(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(jobs) > 0 && !cascade {
+		return nil, nil, ErrNamespaceNotEmpty
+	}
+	deleted := []*job.Spec{}
+	for _, j := range jobs {
+		log.WithFields(logrus.Fields{"namespace": name, "job": j.ID.String()}).Info("store: Deleting job with its namespace")
+		d, err := s.DeleteJob(j.ID, 0)
+		if err == store.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, deleted, err
+		}
+		deleted = append(deleted, d)
+	}
+	if err := s.Client.Delete(job.NamespacePausePath(s.keyspace, name)); err != nil && err != store.ErrKeyNotFound {
+		return nil, deleted, err
+	}
+	if err := s.Client.Delete(namespace.AdmissionPath(s.keyspace, name)); err != nil && err != store.ErrKeyNotFound {
+		return nil, deleted, err
+	}
+	if err := s.Client.Delete(namespace.Path(s.keyspace, name)); err != nil {
+		return nil, deleted, err
+	}
+	return n, deleted, nil
+}
+
+// ApplyNamespaceDefaults merges the defaults of their namespaces into jobs,
+// reading each namespace once. Jobs in namespaces that do not exist are left
+// alone. Defaults are applied to jobs as they are read to be scheduled,
+// rather than stored with them, so the jobs must not be written back
+func (s *KVStore) ApplyNamespaceDefaults(jobs ...*job.Spec) error {
+	namespaces := map[string]*namespace.Namespace{}
+	for _, j := range jobs {
+		n, ok := namespaces[j.ID.Namespace]
+		if !ok {
+			var err error
+			n, err = s.GetNamespace(j.ID.Namespace)
+			if err != nil && err != store.ErrKeyNotFound {
+				return err
+			}
+			namespaces[j.ID.Namespace] = n
+		}
+		if n != nil {
+			n.Apply(j)
+		}
+	}
+	return nil
+}
+
+// GetNamespaceUsage counts the jobs of a namespace, its running instances, and
+// the instances started in the hour before t. Instances that are lost, as
+// their executor stopped recording heartbeats for them, are not running
+func (s *KVStore) GetNamespaceUsage(name string, t time.Time) (*namespace.Usage, error) {
+	jobs, err := s.GetJobs(name)
+	if err != nil {
+		return nil, err
+	}
+	running, started, err := s.namespaceRuns(jobs, t)
+	if err != nil {
+		return nil, err
+	}
+	return &namespace.Usage{Jobs: len(jobs), Running: len(running), RunsLastHour: len(started)}, nil
+}
+
+// CheckJobQuota returns a namespace.QuotaError if storing a new job would put
+// its namespace over quota. Jobs that already exist are always allowed
+func (s *KVStore) CheckJobQuota(j *job.Spec) error {
+	n, err := s.GetNamespace(j.ID.Namespace)
+	if err == store.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if n.Quota == nil || n.Quota.MaxJobs == 0 {
+		return nil
+	}
+	if exists, err := s.JobExists(j.ID); err != nil || exists {
+		return err
+	}
+	jobs, err := s.GetJobs(j.ID.Namespace)
+	if err != nil {
+		return err
+	}
+	return n.AllowsJob(namespace.Usage{Jobs: len(jobs)})
+}
+
+// SetJobHeld records why a due run of a job is being held, or clears it if reason is empty
+func (s *KVStore) SetJobHeld(id job.ID, reason string, since time.Time) error {
+	_, err := s.UpdateJobState(id, func(state *job.State) {
+		if reason == "" {
+			state.HeldReason = ""
+			state.HeldSince = nil
+			return
+		}
+		if state.HeldSince == nil {
+			state.HeldSince = &since
+		}
+		state.HeldReason = reason
+	})
+	return err
+}
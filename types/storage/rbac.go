@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"encoding/json"
+
+	"github.com/byxorna/flow/types/rbac"
+	"github.com/docker/libkv/store"
+)
+
+// GetPolicy returns the access control policy, or nil if none has been stored
+func (s *KVStore) GetPolicy() (*rbac.Policy, error) {
+	res, err := s.Client.Get(rbac.Path(s.keyspace))
+	if err != nil {
+		if err == store.ErrKeyNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var p rbac.Policy
+	if err := json.Unmarshal(res.Value, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// SetPolicy replaces the access control policy
+func (s *KVStore) SetPolicy(p *rbac.Policy) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	pJSON, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	log.WithField("roles", len(p.Roles)).Info("store: Setting access control policy")
+	return s.Client.Put(rbac.Path(s.keyspace), pJSON, nil)
+}
@@ -7,28 +7,39 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/docker/libkv"
 	"github.com/docker/libkv/store"
+	"github.com/docker/libkv/store/boltdb"
 	"github.com/docker/libkv/store/consul"
 	"github.com/docker/libkv/store/etcd"
 	"github.com/docker/libkv/store/zookeeper"
+	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 
 	"github.com/byxorna/flow/config"
 	"github.com/byxorna/flow/types/execution"
 	"github.com/byxorna/flow/types/job"
+	"github.com/byxorna/flow/types/storage/etcdv3"
+	"github.com/byxorna/flow/types/storage/memkv"
 )
 
 var (
-	log = logrus.WithFields(logrus.Fields{"module": "storage"})
+	// ErrConflict is returned when a write keeps losing races with concurrent writers
+	ErrConflict = fmt.Errorf("too many concurrent modifications, giving up")
+	// errJobGone stops a state update for a job that was deleted
+	errJobGone = fmt.Errorf("job no longer exists")
+	// ErrVersionMismatch is returned when a write expects a version of a job that is no longer stored
+	ErrVersionMismatch = fmt.Errorf("job has been modified since the expected resource version")
+	log                = logrus.WithFields(logrus.Fields{"module": "storage"})
 )
 
-// MaxExecutions is how many executions to retain in the storage backend
-const MaxExecutions = 100
+// maxAtomicAttempts is how many times a compare-and-swap is retried before giving up
+const maxAtomicAttempts = 10
 
-// Store abstracts the DAL
-type Store struct {
+// KVStore is a Store on top of a libkv backend
+type KVStore struct {
 	// Client is the libkv client
 	Client   store.Store
 	keyspace string
@@ -39,32 +50,67 @@ func init() {
 	etcd.Register()
 	consul.Register()
 	zookeeper.Register()
+	boltdb.Register()
+	etcdv3.Register()
+	memkv.Register()
 }
 
-// New returns a new storage backend
-func New(c config.Config) (*Store, error) {
-	if len(c.EtcdEndpoints) == 0 {
-		return nil, fmt.Errorf("No supported storage backend in Config")
+// New returns a new storage backend, as selected by c.StorageBackend. Config
+// holds plain settings, so whether the backend has the ones it needs is
+// checked here
+func New(c config.Config) (*KVStore, error) {
+	backend := store.Backend(c.StorageBackend)
+	var (
+		machines []string
+		prefix   string
+		cfg      *store.Config
+		err      error
+	)
+	switch backend {
+	case store.ETCD, etcdv3.ETCDV3:
+		if err := c.ValidateAndSetEtcdDefaults(); err != nil {
+			return nil, err
+		}
+		machines, prefix = c.EtcdEndpoints, c.EtcdPrefix
+		if cfg, err = c.ToLibKVConfig(); err != nil {
+			return nil, err
+		}
+	case store.CONSUL, store.ZK:
+		if len(c.StorageEndpoints) < 1 {
+			return nil, fmt.Errorf("Need to provide storage-endpoints for the %s backend", backend)
+		}
+		machines, prefix = c.StorageEndpoints, c.StoragePrefix
+		cfg = c.StorageLibKVConfig()
+	case store.BOLTDB:
+		if c.BoltDBPath == "" {
+			return nil, fmt.Errorf("Need to provide boltdb-path for the boltdb backend")
+		}
+		machines, prefix = []string{c.BoltDBPath}, c.StoragePrefix
+		cfg = c.StorageLibKVConfig()
+	case memkv.MEMORY:
+		// nothing to configure, and nothing survives a restart
+		machines, prefix = []string{string(memkv.MEMORY)}, c.StoragePrefix
+		cfg = c.StorageLibKVConfig()
+	default:
+		return nil, fmt.Errorf("unknown storage-backend %q, must be one of etcd, etcdv3, consul, zk, boltdb or memory", backend)
 	}
-	//TODO update this if we wanna support multiple backends. For now, idgaf
-	backend := store.ETCD
-	machines := c.EtcdEndpoints
 	keyspace := ""
-	if c.EtcdPrefix != "/" {
+	if prefix != "/" {
 		// "" is fine for keyspace if we have a default prefix to make joining work
-		keyspace = c.EtcdPrefix
+		keyspace = prefix
 	}
-	cfg := c.ToLibKVConfig()
 
-	s, err := libkv.NewStore(store.Backend(backend), machines, &cfg)
+	s, err := libkv.NewStore(backend, machines, cfg)
 	if err != nil {
-		log.Error(err)
+		return nil, err
 	}
 
 	log.WithFields(logrus.Fields{
 		"backend":  backend,
 		"machines": machines,
 		"keyspace": keyspace,
+		"tls":      cfg.TLS != nil,
+		"username": cfg.Username,
 	}).Debug("store: Backend config")
 
 	_, err = s.List(keyspace)
@@ -72,67 +118,378 @@ func New(c config.Config) (*Store, error) {
 		log.WithError(err).Fatal("store: Store backend not reachable")
 	}
 
-	return &Store{
+	return &KVStore{
 		Client:   s,
 		keyspace: keyspace,
 		backend:  backend,
 	}, nil
 }
 
+// NewMemory returns an empty store kept in memory, for development and tests
+func NewMemory() *KVStore {
+	s, _ := memkv.New(nil, nil)
+	return &KVStore{
+		Client:  s,
+		backend: memkv.MEMORY,
+	}
+}
+
 // String returns a string for a Store
-func (s *Store) String() string {
+func (s *KVStore) String() string {
 	return fmt.Sprintf("%s in %s", s.backend, s.keyspace)
 }
 
-// SetJob Stores a job
-func (s *Store) SetJob(j *job.Spec) error {
-	// Sanitize the job name
-	j.ID.Name = generateSlug(j.ID.Name)
-	jobKey := j.Path(s.keyspace)
-	log.Debug("Storing %s to %s", j.ID, jobKey)
+// SetJob Stores a job. The pause of an existing job is kept from the stored
+// version, and the write is a compare-and-swap against it, so a concurrent
+// pause or resume is never lost
+func (s *KVStore) SetJob(j *job.Spec) error {
+	return s.SetJobVersion(j, 0)
+}
 
-	if err := j.Validate(); err != nil {
+// SetJobVersion is SetJob, but if version is not 0 the write only succeeds
+// if the stored job is still at that resource version. On success,
+// j.ResourceVersion is set to the new version
+func (s *KVStore) SetJobVersion(j *job.Spec, version uint64) error {
+	if err := s.PrepareJob(j); err != nil {
 		return err
 	}
+	jobKey := j.Path(s.keyspace)
+	log.Debugf("Storing %s to %s", j.ID.String(), jobKey)
 
-	// Get if the requested job already exist
-	ej, err := s.GetJob(j.ID)
-	if err != nil && err != store.ErrKeyNotFound {
-		return err
+	for attempt := 0; attempt < maxAtomicAttempts; attempt++ {
+		// Get if the requested job already exist
+		pair, err := s.Client.Get(jobKey)
+		if err != nil && err != store.ErrKeyNotFound {
+			return err
+		}
+		if version != 0 && (pair == nil || pair.LastIndex != version) {
+			return ErrVersionMismatch
+		}
+		// pauses are only changed through SetJobPause
+		j.Pause = nil
+		if pair != nil {
+			var ej job.Spec
+			if err := json.Unmarshal(pair.Value, &ej); err != nil {
+				return err
+			}
+			j.Pause = ej.Pause
+		}
+
+		j.ResourceVersion = 0
+		jobJSON, _ := json.Marshal(j)
+
+		log.WithFields(logrus.Fields{
+			"job":       j.ID.Name,
+			"namespace": j.ID.Namespace,
+			"json":      string(jobJSON),
+		}).Debug("store: Setting job")
+
+		// a nil previous pair only succeeds if the job still does not exist
+		_, newPair, err := s.Client.AtomicPut(jobKey, jobJSON, pair, nil)
+		if err == store.ErrKeyModified || err == store.ErrKeyExists {
+			if version != 0 {
+				return ErrVersionMismatch
+			}
+			log.WithFields(logrus.Fields{"job": j.ID.String(), "attempt": attempt}).Debug("store: Job modified concurrently, retrying")
+			backoff(attempt)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if newPair != nil {
+			j.ResourceVersion = newPair.LastIndex
+		}
+		return nil
+	}
+	return ErrConflict
+}
+
+// PrepareJob normalizes a job the way it would be stored, and validates it.
+// Namespace defaults are not stored with the job, so a change to them
+// reaches every job in the namespace; see ApplyNamespaceDefaults
+func (s *KVStore) PrepareJob(j *job.Spec) error {
+	// Sanitize the job name
+	j.ID = NormalizeID(j.ID)
+	return j.Validate()
+}
+
+// NormalizeID returns the ID a job is stored under
+func NormalizeID(id job.ID) job.ID {
+	id.Name = generateSlug(id.Name)
+	return id
+}
+
+// JobExists returns true if a job is stored under the ID, once its name is normalized
+func (s *KVStore) JobExists(id job.ID) (bool, error) {
+	_, err := s.Client.Get(job.Prefix(s.keyspace, NormalizeID(id)))
+	if err == store.ErrKeyNotFound {
+		return false, nil
 	}
-	if ej != nil {
-		// When the job runs, these status vars are updated
-		// otherwise use the ones that are stored
-		if ej.LastError.After(j.LastError) {
-			j.LastError = ej.LastError
+	return err == nil, err
+}
+
+// CheckDependencies verifies the jobs a job depends on, or that depend on it, exist
+func (s *KVStore) CheckDependencies(j *job.Spec) error {
+	deps := j.DependentJobs
+	if j.ParentJob != nil {
+		deps = append([]job.ID{*j.ParentJob}, deps...)
+	}
+	for _, id := range deps {
+		if _, err := s.Client.Get(job.Prefix(s.keyspace, id)); err != nil {
+			if err == store.ErrKeyNotFound {
+				return fmt.Errorf("job %s depends on %s, which does not exist", j.ID.String(), id.String())
+			}
+			return err
 		}
-		if ej.LastSuccess.After(j.LastSuccess) {
-			j.LastSuccess = ej.LastSuccess
+	}
+	return nil
+}
+
+// UpdateJob applies update to the stored version of a job with a
+// compare-and-swap, retrying on conflict with concurrent writers
+func (s *KVStore) UpdateJob(id job.ID, update func(*job.Spec) error) (*job.Spec, error) {
+	path := job.Prefix(s.keyspace, id)
+	for attempt := 0; attempt < maxAtomicAttempts; attempt++ {
+		pair, err := s.Client.Get(path)
+		if err != nil {
+			return nil, err
 		}
-		if ej.SuccessCount > j.SuccessCount {
-			j.SuccessCount = ej.SuccessCount
+		var j job.Spec
+		if err := json.Unmarshal(pair.Value, &j); err != nil {
+			return nil, err
+		}
+		if err := update(&j); err != nil {
+			return nil, err
 		}
-		if ej.ErrorCount > j.ErrorCount {
-			j.ErrorCount = ej.ErrorCount
+		if err := j.Validate(); err != nil {
+			return nil, err
 		}
+		j.ResourceVersion = 0
+		jobJSON, err := json.Marshal(&j)
+		if err != nil {
+			return nil, err
+		}
+
+		_, newPair, err := s.Client.AtomicPut(path, jobJSON, pair, nil)
+		if err == store.ErrKeyModified {
+			log.WithFields(logrus.Fields{"job": id.String(), "attempt": attempt}).Debug("store: Job modified concurrently, retrying")
+			backoff(attempt)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if newPair != nil {
+			j.ResourceVersion = newPair.LastIndex
+		}
+		return &j, nil
 	}
+	return nil, ErrConflict
+}
 
-	jobJSON, _ := json.Marshal(j)
+// GetJobState returns the runtime state of a job. A job that has never run
+// has a zero State
+func (s *KVStore) GetJobState(id job.ID) (*job.State, error) {
+	var state job.State
+	res, err := s.Client.Get(job.StatePath(s.keyspace, id))
+	if err != nil {
+		if err == store.ErrKeyNotFound {
+			return &state, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(res.Value, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
 
-	log.WithFields(logrus.Fields{
-		"job":       j.ID.Name,
-		"namespace": j.ID.Namespace,
-		"json":      string(jobJSON),
-	}).Debug("store: Setting job")
+// UpdateJobState applies update to the runtime state of a job with a
+// compare-and-swap, retrying on conflict with concurrent writers
+func (s *KVStore) UpdateJobState(id job.ID, update func(*job.State)) (*job.State, error) {
+	return s.updateJobState(id, func(state *job.State) error {
+		update(state)
+		return nil
+	})
+}
 
-	err = s.Client.Put(jobKey, jobJSON, nil)
+// updateJobState is UpdateJobState, but update can fail, which gives up
+// without writing anything
+func (s *KVStore) updateJobState(id job.ID, update func(*job.State) error) (*job.State, error) {
+	path := job.StatePath(s.keyspace, id)
+	for attempt := 0; attempt < maxAtomicAttempts; attempt++ {
+		var state job.State
+		pair, err := s.Client.Get(path)
+		if err != nil && err != store.ErrKeyNotFound {
+			return nil, err
+		}
+		if pair != nil {
+			if err := json.Unmarshal(pair.Value, &state); err != nil {
+				return nil, err
+			}
+		}
+		if err := update(&state); err != nil {
+			return nil, err
+		}
+		stateJSON, err := json.Marshal(&state)
+		if err != nil {
+			return nil, err
+		}
+
+		// a nil previous pair only succeeds if there is still no state stored
+		_, _, err = s.Client.AtomicPut(path, stateJSON, pair, nil)
+		if err == store.ErrKeyModified || err == store.ErrKeyExists {
+			log.WithFields(logrus.Fields{"job": id.String(), "attempt": attempt}).Debug("store: Job state modified concurrently, retrying")
+			backoff(attempt)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &state, nil
+	}
+	return nil, ErrConflict
+}
+
+// updateLatest records how an instance's execution group is going in its
+// job's state, unless the job has run a newer group since. The state keeps
+// the outcome of each instance of the group, so only the state is read, and
+// the compare-and-swap keeps concurrent updates to instances of the same
+// group from losing each other. Nothing is written for a job that was deleted
+func (s *KVStore) updateLatest(e *execution.Instance) error {
+	_, err := s.updateJobState(e.Job, func(state *job.State) error {
+		// checked on every attempt, as in RecordRun
+		exists, err := s.JobExists(e.Job)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return errJobGone
+		}
+		if state.Latest != nil && state.Latest.Group > e.Group {
+			return nil
+		}
+		outcomes := map[string]job.Outcome{}
+		if l := state.Latest; l != nil && l.Group == e.Group {
+			if l.Instances == nil {
+				// recorded before outcomes were kept per instance, so the
+				// group is read once to fill them in
+				group, err := s.GetExecutionGroup(e)
+				if err != nil {
+					return err
+				}
+				for _, i := range group {
+					outcomes[i.ID.String()] = execution.OutcomeOf(i)
+				}
+			}
+			for id, o := range l.Instances {
+				outcomes[id] = o
+			}
+		}
+		outcomes[e.ID.String()] = execution.OutcomeOf(e)
+		state.Latest = execution.LatestOf(e.Group, outcomes)
+		return nil
+	})
+	if err == errJobGone {
+		return nil
+	}
 	return err
 }
 
+// RecordRun counts a finished instance in its job's success or error counters.
+// Cancelled instances are not counted. If the job was deleted, there is
+// nothing to count against, so nothing is written and a nil State is returned
+func (s *KVStore) RecordRun(i *execution.Instance) (*job.State, error) {
+	state, err := s.updateJobState(i.Job, func(state *job.State) error {
+		// checked on every attempt, so a job deleted while this retries does
+		// not get its status written back
+		exists, err := s.JobExists(i.Job)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return errJobGone
+		}
+		// whatever was holding the job back let this run through
+		state.HeldReason = ""
+		state.HeldSince = nil
+		switch {
+		case i.Cancelled:
+		case i.Success:
+			state.SuccessCount++
+			if i.FinishedAt.After(state.LastSuccess) {
+				state.LastSuccess = i.FinishedAt
+			}
+		default:
+			state.ErrorCount++
+			if i.FinishedAt.After(state.LastError) {
+				state.LastError = i.FinishedAt
+			}
+		}
+		return nil
+	})
+	if err == errJobGone {
+		log.WithFields(logrus.Fields{"job": i.Job.String(), "instance": i.ID}).Debug("store: Job was deleted, not recording run")
+		return nil, nil
+	}
+	return state, err
+}
+
+// SetJobPause pauses a job, or resumes it if p is nil, and returns the updated job
+func (s *KVStore) SetJobPause(id job.ID, p *job.Pause) (*job.Spec, error) {
+	log.WithFields(logrus.Fields{
+		"job":       id.Name,
+		"namespace": id.Namespace,
+		"paused":    p != nil,
+	}).Debug("store: Setting job pause")
+	return s.UpdateJob(id, func(j *job.Spec) error {
+		j.Pause = p
+		return nil
+	})
+}
+
+// GetNamespacePause returns the pause of a namespace, or nil if it is not paused
+func (s *KVStore) GetNamespacePause(namespace string) (*job.Pause, error) {
+	res, err := s.Client.Get(job.NamespacePausePath(s.keyspace, namespace))
+	if err != nil {
+		if err == store.ErrKeyNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var p job.Pause
+	if err := json.Unmarshal(res.Value, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// SetNamespacePause pauses every job in a namespace, or resumes them if p is nil
+func (s *KVStore) SetNamespacePause(namespace string, p *job.Pause) error {
+	path := job.NamespacePausePath(s.keyspace, namespace)
+	log.WithFields(logrus.Fields{
+		"namespace": namespace,
+		"paused":    p != nil,
+	}).Debug("store: Setting namespace pause")
+	if p == nil {
+		err := s.Client.Delete(path)
+		if err == store.ErrKeyNotFound {
+			return nil
+		}
+		return err
+	}
+	pJSON, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return s.Client.Put(path, pJSON, nil)
+}
+
 /*
 // Set the depencency tree for a job given the job and the previous version
 // of the Job or nil if it's new.
-func (s *Store) SetJobDependencyTree(j *job.Spec, previousJob *job.Spec) error {
+func (s *KVStore) SetJobDependencyTree(j *job.Spec, previousJob *job.Spec) error {
 	// Existing job that doesn't have parent job set and it's being set
 	if previousJob != nil && previousJob.ParentJob == "" && j.ParentJob != "" {
 		pj, err := j.GetParent()
@@ -192,7 +549,7 @@ func (s *Store) SetJobDependencyTree(j *job.Spec, previousJob *job.Spec) error {
 // GetJobs returns all jobs
 // if namespace is "", returns ALL jobs. Otherwise, returns only
 // jobs for the specified namespace.
-func (s *Store) GetJobs(namespace string) ([]*job.Spec, error) {
+func (s *KVStore) GetJobs(namespace string) ([]*job.Spec, error) {
 	var namespaces []string
 	if namespace != "" {
 		namespaces = make([]string, 1)
@@ -201,7 +558,7 @@ func (s *Store) GetJobs(namespace string) ([]*job.Spec, error) {
 		// lookup all namespaces,
 		path := fmt.Sprintf("%s/%s/", s.keyspace, job.StoragePath)
 		log.Debugf("fetching namespaces from path %s", path)
-		namespaceKeys, err := s.Client.List(path)
+		namespaceDirs, _, err := s.children(path)
 		if err != nil {
 			if err == store.ErrKeyNotFound {
 				log.Debugf("store: No namespaces found at %s", path)
@@ -209,9 +566,9 @@ func (s *Store) GetJobs(namespace string) ([]*job.Spec, error) {
 			}
 			return nil, err
 		}
-		namespaces = make([]string, len(namespaceKeys))
-		for i, e := range namespaceKeys {
-			namespaces[i] = e.Key
+		namespaces = make([]string, len(namespaceDirs))
+		for i, ns := range namespaceDirs {
+			namespaces[i] = fmt.Sprintf("%s/%s/%s", s.keyspace, job.StoragePath, ns)
 		}
 		log.WithFields(logrus.Fields{"path": path, "num": len(namespaces)}).
 			Debugf("got %d namespaces from %s", len(namespaces), path)
@@ -223,7 +580,7 @@ func (s *Store) GetJobs(namespace string) ([]*job.Spec, error) {
 		parts := strings.Split(nspath, "/")
 		ns := parts[len(parts)-1]
 		log.WithFields(logrus.Fields{"namespace": ns}).Debugf("fetching jobs")
-		jobEntries, err := s.Client.List(nspath)
+		jobEntries, err := s.leaves(nspath)
 		if err != nil {
 			if err == store.ErrKeyNotFound {
 				log.Debugf("store: No jobs found at %s", nspath)
@@ -237,15 +594,13 @@ func (s *Store) GetJobs(namespace string) ([]*job.Spec, error) {
 
 		for _, entry := range jobEntries {
 			log.Debugf("deserializing %s contents", entry.Key)
-			if len(entry.Value) == 0 {
-				return nil, fmt.Errorf("key %s has no content for its value", entry.Key)
-			}
 			var j job.Spec
 			log.Debugf("attempting to unmarshal key %v '%s' as Job", entry.Key, string(entry.Value))
 			err := json.Unmarshal([]byte(entry.Value), &j)
 			if err != nil {
 				return nil, err
 			}
+			j.ResourceVersion = entry.LastIndex
 			jobs = append(jobs, &j)
 		}
 	}
@@ -259,8 +614,194 @@ func (s *Store) GetJobs(namespace string) ([]*job.Spec, error) {
 	return jobs, nil
 }
 
+// JobPage is a page of jobs ordered by ID. Continue is passed back to
+// GetJobPage to fetch the next page, and is empty on the last one
+type JobPage struct {
+	Jobs     []*job.Spec
+	Continue string
+}
+
+// GetJobPage returns at most limit jobs for which match is true, ordered by
+// ID and starting after the job whose ID is the continue token after. Like
+// GetJobs, an empty namespace pages through every namespace. Backends that
+// can list a range of keys are read a page at a time; the rest are read in
+// full and paged here
+func (s *KVStore) GetJobPage(namespace string, after string, limit int, match func(*job.Spec) (bool, error)) (*JobPage, error) {
+	page := &JobPage{Jobs: []*job.Spec{}}
+	// add adds a matching job to the page. It returns true once a match is
+	// found after the page is full, so a continue token is only handed out
+	// when there is something left to fetch
+	add := func(j *job.Spec) (bool, error) {
+		ok, err := match(j)
+		if err != nil || !ok {
+			return false, err
+		}
+		if len(page.Jobs) == limit {
+			page.Continue = page.Jobs[limit-1].ID.String()
+			return true, nil
+		}
+		page.Jobs = append(page.Jobs, j)
+		return false, nil
+	}
+
+	ranger, ok := s.Client.(rangeLister)
+	if !ok {
+		jobs, err := s.GetJobs(namespace)
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(jobs, func(a, b int) bool { return jobs[a].ID.String() < jobs[b].ID.String() })
+		for _, j := range jobs {
+			if j.ID.String() <= after {
+				continue
+			}
+			if full, err := add(j); err != nil || full {
+				return page, err
+			}
+		}
+		return page, nil
+	}
+
+	dir := fmt.Sprintf("%s/%s", s.keyspace, job.StoragePath)
+	depth := 2
+	if namespace != "" {
+		dir = fmt.Sprintf("%s/%s", dir, namespace)
+		depth = 1
+	}
+	base := splitKey(dir)
+	next := ""
+	if after != "" {
+		next = fmt.Sprintf("%s/%s/%s", s.keyspace, job.StoragePath, after)
+	}
+	for {
+		// one more than a page, as the last match only tells there is more
+		pairs, err := ranger.ListRange(dir, next, limit+1)
+		if err != nil {
+			return nil, err
+		}
+		for _, pair := range pairs {
+			next = pair.Key
+			if rel, ok := relative(base, pair.Key); !ok || len(rel) != depth {
+				continue
+			}
+			var j job.Spec
+			if err := json.Unmarshal(pair.Value, &j); err != nil {
+				return nil, err
+			}
+			if err := j.Validate(); err != nil {
+				return nil, err
+			}
+			j.ResourceVersion = pair.LastIndex
+			if full, err := add(&j); err != nil || full {
+				return page, err
+			}
+		}
+		if len(pairs) < limit+1 {
+			return page, nil
+		}
+	}
+}
+
+// JobEvent is a change to a stored job. Job is nil if it was deleted. When
+// the backend cannot tell which jobs changed, Resync is set instead, and
+// every job should be compared again
+type JobEvent struct {
+	ID     job.ID
+	Job    *job.Spec
+	Resync bool
+}
+
+// WatchJobs returns a channel of changes to stored jobs. The first event on a
+// new watch is always a Resync, as changes made before the watch started are
+// not reported. The channel is closed if the watch fails or stopCh is closed.
+// etcd v2 and zookeeper only report directories, so every change they see
+// is a Resync. boltdb cannot watch at all, and returns ErrCallNotSupported
+func (s *KVStore) WatchJobs(stopCh <-chan struct{}) (<-chan *JobEvent, error) {
+	if s.backend == store.BOLTDB {
+		return nil, store.ErrCallNotSupported
+	}
+	path := fmt.Sprintf("%s/%s", s.keyspace, job.StoragePath)
+	// watching a tree requires it to exist
+	if ok, err := s.Client.Exists(path); err == nil && !ok {
+		if err := s.Client.Put(path, nil, &store.WriteOptions{IsDir: true}); err != nil {
+			return nil, err
+		}
+	}
+
+	events, err := s.Client.WatchTree(path, stopCh)
+	if err != nil {
+		return nil, err
+	}
+	changes := make(chan *JobEvent)
+	go func() {
+		defer close(changes)
+		// versions are the last seen version of each job, by key
+		var versions map[string]uint64
+		for entries := range events {
+			var batch []*JobEvent
+			if versions == nil || !s.prefixMatching() {
+				batch = []*JobEvent{{Resync: true}}
+				versions = map[string]uint64{}
+				for _, e := range entries {
+					versions[e.Key] = e.LastIndex
+				}
+			} else {
+				batch = s.jobEvents(splitKey(path), entries, versions)
+			}
+			for _, ev := range batch {
+				select {
+				case changes <- ev:
+				case <-stopCh:
+					return
+				}
+			}
+		}
+	}()
+	return changes, nil
+}
+
+// jobEvents compares the entries of a watched jobs tree against the versions
+// seen before, updating versions and returning an event for every job that
+// was created, updated or deleted
+func (s *KVStore) jobEvents(base []string, entries []*store.KVPair, versions map[string]uint64) []*JobEvent {
+	batch := []*JobEvent{}
+	current := map[string]bool{}
+	for _, e := range entries {
+		current[e.Key] = true
+		if v, ok := versions[e.Key]; ok && v == e.LastIndex {
+			continue
+		}
+		versions[e.Key] = e.LastIndex
+		rel, ok := relative(base, e.Key)
+		if !ok || len(rel) != 2 || len(e.Value) == 0 {
+			continue
+		}
+		var j job.Spec
+		if err := json.Unmarshal(e.Value, &j); err != nil {
+			log.WithError(err).WithFields(logrus.Fields{"key": e.Key}).Error("store: Unable to read changed job")
+			continue
+		}
+		if err := j.Validate(); err != nil {
+			log.WithError(err).WithFields(logrus.Fields{"key": e.Key}).Error("store: Changed job is invalid")
+			continue
+		}
+		j.ResourceVersion = e.LastIndex
+		batch = append(batch, &JobEvent{ID: j.ID, Job: &j})
+	}
+	for k := range versions {
+		if current[k] {
+			continue
+		}
+		delete(versions, k)
+		if rel, ok := relative(base, k); ok && len(rel) == 2 {
+			batch = append(batch, &JobEvent{ID: job.ID{Namespace: rel[0], Name: rel[1]}})
+		}
+	}
+	return batch
+}
+
 // GetJob ...
-func (s *Store) GetJob(id job.ID) (*job.Spec, error) {
+func (s *KVStore) GetJob(id job.ID) (*job.Spec, error) {
 	path := job.Prefix(s.keyspace, id)
 	res, err := s.Client.Get(path)
 	if err != nil {
@@ -271,6 +812,7 @@ func (s *Store) GetJob(id job.ID) (*job.Spec, error) {
 	if err = json.Unmarshal([]byte(res.Value), &j); err != nil {
 		return nil, err
 	}
+	j.ResourceVersion = res.LastIndex
 
 	log.WithFields(logrus.Fields{
 		"name":      j.ID.Name,
@@ -285,30 +827,45 @@ func (s *Store) GetJob(id job.ID) (*job.Spec, error) {
 	return &j, nil
 }
 
-// DeleteJob ...
-func (s *Store) DeleteJob(id job.ID) (*job.Spec, error) {
+// DeleteJob deletes a job and all its executions. If version is not 0, the
+// job is only deleted if it is still at that resource version
+func (s *KVStore) DeleteJob(id job.ID, version uint64) (*job.Spec, error) {
 	j, err := s.GetJob(id)
 	if err != nil {
 		return nil, err
 	}
 
+	path := job.Prefix(s.keyspace, id)
+	if version != 0 {
+		ok, err := s.Client.AtomicDelete(path, &store.KVPair{Key: path, LastIndex: version})
+		if err == store.ErrKeyModified || (err == nil && !ok) {
+			return nil, ErrVersionMismatch
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else if err := s.Client.Delete(path); err != nil {
+		return nil, err
+	}
+
 	if err := s.DeleteExecutions(id); err != nil {
 		if err != store.ErrKeyNotFound {
 			return nil, err
 		}
 	}
-
-	if err := s.Client.Delete(job.Prefix(s.keyspace, id)); err != nil {
-		return nil, err
+	if err := s.Client.Delete(job.StatePath(s.keyspace, id)); err != nil {
+		if err != store.ErrKeyNotFound {
+			return nil, err
+		}
 	}
 
 	return j, nil
 }
 
 // GetExecutions ...
-func (s *Store) GetExecutions(id job.ID) ([]*execution.Instance, error) {
-	prefix := execution.Path(s.keyspace, id)
-	res, err := s.Client.List(prefix)
+func (s *KVStore) GetExecutions(id job.ID) ([]*execution.Instance, error) {
+	// keys are <prefix>/<namespace>/<job>/<instance>
+	res, err := s.leaves(execution.Path(s.keyspace, id))
 	if err != nil {
 		return nil, err
 	}
@@ -316,17 +873,6 @@ func (s *Store) GetExecutions(id job.ID) ([]*execution.Instance, error) {
 	var executions []*execution.Instance
 
 	for _, node := range res {
-		if store.Backend(s.backend) != store.ZK {
-			path := store.SplitKey(node.Key)
-			nsDir := path[len(path)-2]
-			jobDir := path[len(path)-1]
-			if nsDir != id.Namespace {
-				continue
-			}
-			if jobDir != id.Name {
-				continue
-			}
-		}
 		var e execution.Instance
 		err := json.Unmarshal([]byte(node.Value), &e)
 		if err != nil {
@@ -337,28 +883,59 @@ func (s *Store) GetExecutions(id job.ID) ([]*execution.Instance, error) {
 	return executions, nil
 }
 
-// GetLastExecutionGroup ...
-func (s *Store) GetLastExecutionGroup(id job.ID) ([]*execution.Instance, error) {
-	prefix := execution.Path(s.keyspace, id)
-	res, err := s.Client.List(prefix)
+// GetExecution returns a single execution instance of a job
+func (s *KVStore) GetExecution(id job.ID, instance uuid.UUID) (*execution.Instance, error) {
+	res, err := s.Client.Get(fmt.Sprintf("%s/%s", execution.Path(s.keyspace, id), instance))
 	if err != nil {
 		return nil, err
 	}
-	if len(res) == 0 {
-		return []*execution.Instance{}, nil
+	var e execution.Instance
+	if err := json.Unmarshal(res.Value, &e); err != nil {
+		return nil, err
 	}
+	return &e, nil
+}
 
-	var ex execution.Instance
-	err = json.Unmarshal([]byte(res[len(res)-1].Value), &ex)
+// GetLastExecutionGroup returns the instances of the most recent execution group
+// of a job. Instance keys are random, so the group is found by its number rather
+// than its position in the listing
+func (s *KVStore) GetLastExecutionGroup(id job.ID) ([]*execution.Instance, error) {
+	execs, err := s.GetExecutions(id)
 	if err != nil {
+		if err == store.ErrKeyNotFound {
+			return []*execution.Instance{}, nil
+		}
 		return nil, err
 	}
-	return s.GetExecutionGroup(&ex)
+
+	var last int64
+	for _, ex := range execs {
+		if ex.Group > last {
+			last = ex.Group
+		}
+	}
+	group := []*execution.Instance{}
+	for _, ex := range execs {
+		if ex.Group == last {
+			group = append(group, ex)
+		}
+	}
+	return group, nil
+}
+
+// GetJobSummary returns the status of a job's last run, as recorded in its state
+func (s *KVStore) GetJobSummary(id job.ID) (*execution.Summary, error) {
+	state, err := s.GetJobState(id)
+	if err != nil {
+		return nil, err
+	}
+	summary := execution.SummaryOf(state)
+	return &summary, nil
 }
 
 // GetExecutionGroup ...
-func (s *Store) GetExecutionGroup(e *execution.Instance) ([]*execution.Instance, error) {
-	res, err := s.Client.List(execution.Path(s.keyspace, e.Job))
+func (s *KVStore) GetExecutionGroup(e *execution.Instance) ([]*execution.Instance, error) {
+	res, err := s.leaves(execution.Path(s.keyspace, e.Job))
 	if err != nil {
 		return nil, err
 	}
@@ -380,7 +957,7 @@ func (s *Store) GetExecutionGroup(e *execution.Instance) ([]*execution.Instance,
 
 // GetGroupedExecutions Returns executions for a job grouped and with an ordered index
 // to facilitate access.
-func (s *Store) GetGroupedExecutions(id job.ID) (map[int64][]*execution.Instance, []int64, error) {
+func (s *KVStore) GetGroupedExecutions(id job.ID) (map[int64][]*execution.Instance, []int64, error) {
 	execs, err := s.GetExecutions(id)
 	if err != nil {
 		return nil, nil, err
@@ -401,7 +978,9 @@ func (s *Store) GetGroupedExecutions(id job.ID) (map[int64][]*execution.Instance
 }
 
 // SetExecution Save a new execution and returns the key of the new saved item or an error.
-func (s *Store) SetExecution(e *execution.Instance) (string, error) {
+// The outcome of the instance's group is recorded in its job's state. Old
+// executions are not pruned here, see PruneExecutions
+func (s *KVStore) SetExecution(e *execution.Instance) (string, error) {
 	exJSON, _ := json.Marshal(e)
 
 	log.WithFields(logrus.Fields{
@@ -421,46 +1000,76 @@ func (s *Store) SetExecution(e *execution.Instance) (string, error) {
 	if err != nil {
 		return "", err
 	}
-
-	execs, err := s.GetExecutions(e.Job)
-	if err != nil {
-		log.Errorf("store: No executions found for job %s", e.Job)
+	if err := s.setLogIndex(e); err != nil {
 		return "", err
 	}
-
-	// Get and ordered array of all execution groups
-	var byGroup int64arr
-	for _, ex := range execs {
-		byGroup = append(byGroup, ex.Group)
-	}
-	sort.Sort(byGroup)
-
-	// Delete all execution results over the limit, starting from olders
-	if len(byGroup) > MaxExecutions {
-		for i := range byGroup[MaxExecutions:] {
-			err := s.Client.Delete(
-				fmt.Sprintf(
-					"%s/%s",
-					execution.Path(s.keyspace, execs[i].Job),
-					execs[i].ID,
-				),
-			)
-			if err != nil {
-				log.Errorf("store: Trying to delete overflowed execution %s", execs[i].ID)
-			}
-		}
+	if err := s.updateLatest(e); err != nil {
+		return "", err
 	}
 
 	return e.ID.String(), nil
 }
 
+// PruneExecutions deletes the executions of a job that retention r no
+// longer keeps at time now, and returns how many were deleted. Groups left
+// running by an executor that died are pruned like finished ones
+func (s *KVStore) PruneExecutions(id job.ID, r job.Retention, now time.Time) (int, error) {
+	execs, err := s.GetExecutions(id)
+	if err != nil {
+		if err == store.ErrKeyNotFound {
+			return 0, nil
+		}
+		return 0, err
+	}
+	heartbeats := map[uuid.UUID]time.Time{}
+	for _, e := range execs {
+		if !e.FinishedAt.IsZero() || e.Cancelled {
+			continue
+		}
+		if heartbeats[e.ID], err = s.getHeartbeat(e.ID); err != nil {
+			return 0, err
+		}
+	}
+	deleted := 0
+	for _, e := range execution.Expired(execs, heartbeats, r, now) {
+		if err := s.deleteExecution(e); err != nil && err != store.ErrKeyNotFound {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
 // DeleteExecutions Removes all executions of a job
-func (s *Store) DeleteExecutions(id job.ID) error {
-	return s.Client.DeleteTree(execution.Path(s.keyspace, id))
+func (s *KVStore) DeleteExecutions(id job.ID) error {
+	execs, err := s.GetExecutions(id)
+	if err != nil {
+		return err
+	}
+	for _, e := range execs {
+		if err := s.DeleteLogs(e.ID); err != nil {
+			return err
+		}
+		if err := s.DeleteHeartbeat(e.ID); err != nil {
+			return err
+		}
+	}
+	return s.deleteTree(execution.Path(s.keyspace, id))
+}
+
+// deleteExecution removes an execution instance, its output and its heartbeat
+func (s *KVStore) deleteExecution(e *execution.Instance) error {
+	if err := s.DeleteLogs(e.ID); err != nil {
+		return err
+	}
+	if err := s.DeleteHeartbeat(e.ID); err != nil {
+		return err
+	}
+	return s.Client.Delete(fmt.Sprintf("%s/%s", execution.Path(s.keyspace, e.Job), e.ID))
 }
 
 // GetLeader Retrieve the leader from the store
-func (s *Store) GetLeader() []byte {
+func (s *KVStore) GetLeader() []byte {
 	res, err := s.Client.Get(s.LeaderKey())
 	if err != nil {
 		if err == store.ErrNotReachable {
@@ -477,6 +1086,29 @@ func (s *Store) GetLeader() []byte {
 }
 
 // LeaderKey Retrieve the leader key used in the KV store to store the leader node
-func (s *Store) LeaderKey() string {
+func (s *KVStore) LeaderKey() string {
 	return s.keyspace + "/leader"
 }
+
+// leaderTTL is how long a leader that died stays leader, on backends that
+// hold locks on a TTL
+const leaderTTL = 20 * time.Second
+
+// Campaign blocks until node is elected leader, or stop is closed. The
+// leader holds a lock on LeaderKey with its name as the value, so GetLeader
+// returns it. On etcdv3 the lock is held on a lease that is kept alive
+// while the process is, so a leader that dies is replaced once it expires.
+// The returned channel is closed if leadership is lost, and resign gives it
+// up. Backends that cannot lock, like boltdb, return store.ErrCallNotSupported
+func (s *KVStore) Campaign(node string, stop chan struct{}) (<-chan struct{}, func() error, error) {
+	l, err := s.Client.NewLock(s.LeaderKey(), &store.LockOptions{Value: []byte(node), TTL: leaderTTL})
+	if err != nil {
+		return nil, nil, err
+	}
+	lost, err := l.Lock(stop)
+	if err != nil {
+		return nil, nil, err
+	}
+	log.WithField("node", node).Info("store: Elected leader")
+	return lost, l.Unlock, nil
+}
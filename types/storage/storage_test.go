@@ -0,0 +1,322 @@
+package storage
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/byxorna/flow/config"
+	"github.com/byxorna/flow/types/execution"
+	"github.com/byxorna/flow/types/job"
+	"github.com/docker/libkv/store"
+)
+
+const (
+	// racingWriters update the same job at once
+	racingWriters = 8
+	// racingUpdates is how many updates each writer makes
+	racingUpdates = 20
+)
+
+// race runs update from racingWriters goroutines racingUpdates times each,
+// and fails the test if any update gave up
+func race(t *testing.T, update func() error) {
+	var wg sync.WaitGroup
+	errs := make(chan error, racingWriters*racingUpdates)
+	for w := 0; w < racingWriters; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < racingUpdates; n++ {
+				if err := update(); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("update failed: %s", err)
+	}
+}
+
+func TestUpdateJobRace(t *testing.T) {
+	s := NewMemory()
+	j := newTestJob(t, s, "racy")
+
+	race(t, func() error {
+		_, err := s.UpdateJob(j.ID, func(j *job.Spec) error {
+			n, _ := strconv.Atoi(j.Annotations["count"])
+			if j.Annotations == nil {
+				j.Annotations = map[string]string{}
+			}
+			j.Annotations["count"] = strconv.Itoa(n + 1)
+			return nil
+		})
+		return err
+	})
+
+	stored, err := s.GetJob(j.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := stored.Annotations["count"]; got != strconv.Itoa(racingWriters*racingUpdates) {
+		t.Errorf("expected %d updates, got %s", racingWriters*racingUpdates, got)
+	}
+}
+
+func TestUpdateJobStateRace(t *testing.T) {
+	s := NewMemory()
+	j := newTestJob(t, s, "racy")
+
+	race(t, func() error {
+		_, err := s.UpdateJobState(j.ID, func(state *job.State) {
+			state.ErrorCount++
+		})
+		return err
+	})
+
+	state, err := s.GetJobState(j.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.ErrorCount != racingWriters*racingUpdates {
+		t.Errorf("expected %d updates, got %d", racingWriters*racingUpdates, state.ErrorCount)
+	}
+}
+
+func TestRecordRunRace(t *testing.T) {
+	s := NewMemory()
+	j := newTestJob(t, s, "racy")
+
+	var mu sync.Mutex
+	n := 0
+	race(t, func() error {
+		i := execution.NewInstance(j.ID)
+		i.StartedAt = time.Now()
+		i.FinishedAt = time.Now()
+		mu.Lock()
+		i.Success = n%2 == 0
+		n++
+		mu.Unlock()
+		_, err := s.RecordRun(i)
+		return err
+	})
+
+	state, err := s.GetJobState(j.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.SuccessCount+state.ErrorCount != racingWriters*racingUpdates {
+		t.Errorf("expected %d runs counted, got %d successes and %d errors",
+			racingWriters*racingUpdates, state.SuccessCount, state.ErrorCount)
+	}
+	if state.SuccessCount != racingWriters*racingUpdates/2 {
+		t.Errorf("expected %d successes, got %d", racingWriters*racingUpdates/2, state.SuccessCount)
+	}
+}
+
+func TestRecordRunDeletedJob(t *testing.T) {
+	s := NewMemory()
+	j := newTestJob(t, s, "deleted")
+	i := execution.NewInstance(j.ID)
+	i.StartedAt = time.Now()
+	i.FinishedAt = time.Now()
+	i.Success = true
+	if _, err := s.DeleteJob(j.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := s.RecordRun(i)
+	if err != nil || state != nil {
+		t.Fatalf("expected nothing recorded for a deleted job, got %v, %v", state, err)
+	}
+	if exists, _ := s.Client.Exists(job.StatePath(s.keyspace, j.ID)); exists {
+		t.Error("expected no state to be written back for a deleted job")
+	}
+}
+
+func TestSetExecutionDeletedJob(t *testing.T) {
+	s := NewMemory()
+	j := newTestJob(t, s, "deleted")
+	if _, err := s.DeleteJob(j.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// an executor finishing an instance of a deleted job must not bring
+	// back its status
+	i := execution.NewInstance(j.ID)
+	i.StartedAt = time.Now()
+	if _, err := s.SetExecution(i); err != nil {
+		t.Fatalf("expected the instance to be stored, got %v", err)
+	}
+	if exists, _ := s.Client.Exists(job.StatePath(s.keyspace, j.ID)); exists {
+		t.Error("expected no state to be written back for a deleted job")
+	}
+}
+
+func TestNewChecksBackendSettings(t *testing.T) {
+	for _, tc := range []struct {
+		backend string
+		err     string
+	}{
+		{"nosql", "unknown storage-backend"},
+		{"consul", "storage-endpoints"},
+		{"zk", "storage-endpoints"},
+		{"boltdb", "boltdb-path"},
+		{"etcd", "etcd-endpoints"},
+		{"etcdv3", "etcd-endpoints"},
+	} {
+		var c config.Config
+		c.StorageBackend = tc.backend
+		if err := c.ValidateAndSetDefaults(); err != nil {
+			t.Fatalf("%s: expected config to leave backend settings to storage, got %s", tc.backend, err)
+		}
+		if _, err := New(c); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: expected an error containing %q, got %v", tc.backend, tc.err, err)
+		}
+	}
+}
+
+// listCounter counts the listings made through a store
+type listCounter struct {
+	store.Store
+	lists int
+}
+
+func (c *listCounter) List(directory string) ([]*store.KVPair, error) {
+	c.lists++
+	return c.Store.List(directory)
+}
+
+func TestSetExecutionReadsOnlyTheState(t *testing.T) {
+	s := NewMemory()
+	j := newTestJob(t, s, "a")
+	counter := &listCounter{Store: s.Client}
+	s.Client = counter
+
+	group := int64(1)
+	instances := []*execution.Instance{}
+	for n := 0; n < 3; n++ {
+		i := execution.NewInstance(j.ID)
+		i.Group = group
+		i.StartedAt = time.Now()
+		if _, err := s.SetExecution(i); err != nil {
+			t.Fatal(err)
+		}
+		instances = append(instances, i)
+	}
+	instances[0].FinishedAt = time.Now()
+	instances[0].Success = true
+	if _, err := s.SetExecution(instances[0]); err != nil {
+		t.Fatal(err)
+	}
+	if counter.lists != 0 {
+		t.Errorf("expected storing instances not to list executions, got %d listings", counter.lists)
+	}
+
+	summary, err := s.GetJobSummary(j.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Status != job.Running {
+		t.Errorf("expected the group to be running while two instances are, got %s", summary.Status)
+	}
+	for _, i := range instances[1:] {
+		i.FinishedAt = time.Now()
+		if _, err := s.SetExecution(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if summary, err = s.GetJobSummary(j.ID); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Status != job.PartiallyFailed {
+		t.Errorf("expected one success and two failures to be partially failed, got %s", summary.Status)
+	}
+}
+
+func TestSetExecutionWithoutOutcomes(t *testing.T) {
+	s := NewMemory()
+	j := newTestJob(t, s, "a")
+	failed := execution.NewInstance(j.ID)
+	failed.Group = 1
+	failed.StartedAt = time.Now()
+	failed.FinishedAt = time.Now()
+	if _, err := s.SetExecution(failed); err != nil {
+		t.Fatal(err)
+	}
+	// a state recorded before outcomes were kept per instance
+	if _, err := s.UpdateJobState(j.ID, func(state *job.State) { state.Latest.Instances = nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	succeeded := execution.NewInstance(j.ID)
+	succeeded.Group = 1
+	succeeded.StartedAt = time.Now()
+	succeeded.FinishedAt = time.Now()
+	succeeded.Success = true
+	if _, err := s.SetExecution(succeeded); err != nil {
+		t.Fatal(err)
+	}
+	state, err := s.GetJobState(j.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Latest.Status != job.PartiallyFailed || len(state.Latest.Instances) != 2 {
+		t.Errorf("expected the stored instances to be read back in, got %s with %d outcomes", state.Latest.Status, len(state.Latest.Instances))
+	}
+}
+
+// listOnly hides ListRange, so jobs are paged the way they are on backends
+// that can only list everything
+type listOnly struct {
+	store.Store
+}
+
+func TestGetJobPage(t *testing.T) {
+	ranged := NewMemory()
+	for _, name := range []string{"e", "a", "c", "b", "d"} {
+		newTestJob(t, ranged, name)
+	}
+	other := newTestJob(t, ranged, "a")
+	other.ID.Namespace = "other"
+	if err := ranged.SetJob(other); err != nil {
+		t.Fatal(err)
+	}
+	listed := &KVStore{Client: listOnly{ranged.Client}, backend: ranged.backend}
+
+	skipC := func(j *job.Spec) (bool, error) { return j.ID.Name != "c", nil }
+	for name, s := range map[string]*KVStore{"ranged": ranged, "listed": listed} {
+		for _, tc := range []struct {
+			namespace string
+			want      [][]string
+		}{
+			{"default", [][]string{{"default/a", "default/b"}, {"default/d", "default/e"}}},
+			{"", [][]string{{"default/a", "default/b"}, {"default/d", "default/e"}, {"other/a"}}},
+		} {
+			after := ""
+			for n, want := range tc.want {
+				page, err := s.GetJobPage(tc.namespace, after, 2, skipC)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := []string{}
+				for _, j := range page.Jobs {
+					got = append(got, j.ID.String())
+				}
+				if strings.Join(got, ",") != strings.Join(want, ",") {
+					t.Errorf("%s %q page %d: expected %v, got %v", name, tc.namespace, n, want, got)
+				}
+				last := n == len(tc.want)-1
+				if last != (page.Continue == "") {
+					t.Errorf("%s %q page %d: unexpected continue token %q", name, tc.namespace, n, page.Continue)
+				}
+				after = page.Continue
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/byxorna/flow/types/audit"
+	"github.com/byxorna/flow/types/execution"
+	"github.com/byxorna/flow/types/job"
+	"github.com/byxorna/flow/types/namespace"
+	"github.com/byxorna/flow/types/rbac"
+	"github.com/google/uuid"
+)
+
+// Store is everything flow keeps in storage. KVStore implements it on top of
+// any libkv backend, including the in-memory one used when testing
+type Store interface {
+	String() string
+
+	// jobs
+	SetJob(j *job.Spec) error
+	SetJobVersion(j *job.Spec, version uint64) error
+	PrepareJob(j *job.Spec) error
+	JobExists(id job.ID) (bool, error)
+	CheckDependencies(j *job.Spec) error
+	UpdateJob(id job.ID, update func(*job.Spec) error) (*job.Spec, error)
+	GetJob(id job.ID) (*job.Spec, error)
+	GetJobs(namespace string) ([]*job.Spec, error)
+	GetJobPage(namespace string, after string, limit int, match func(*job.Spec) (bool, error)) (*JobPage, error)
+	DeleteJob(id job.ID, version uint64) (*job.Spec, error)
+	WatchJobs(stopCh <-chan struct{}) (<-chan *JobEvent, error)
+	SetJobPause(id job.ID, p *job.Pause) (*job.Spec, error)
+
+	// job runtime state
+	GetJobState(id job.ID) (*job.State, error)
+	UpdateJobState(id job.ID, update func(*job.State)) (*job.State, error)
+	RecordRun(i *execution.Instance) (*job.State, error)
+	SetJobHeld(id job.ID, reason string, since time.Time) error
+	GetJobSummary(id job.ID) (*execution.Summary, error)
+
+	// namespaces
+	GetNamespace(name string) (*namespace.Namespace, error)
+	GetNamespaces() ([]*namespace.Namespace, error)
+	SetNamespace(n *namespace.Namespace, version uint64) error
+	DeleteNamespace(name string, cascade bool) (*namespace.Namespace, []*job.Spec, error)
+	ApplyNamespaceDefaults(jobs ...*job.Spec) error
+	GetNamespacePause(namespace string) (*job.Pause, error)
+	SetNamespacePause(namespace string, p *job.Pause) error
+	GetNamespaceUsage(name string, t time.Time) (*namespace.Usage, error)
+	CheckJobQuota(j *job.Spec) error
+	ReserveRun(j *job.Spec, instance uuid.UUID, now time.Time) error
+	ReleaseRun(id job.ID, instance uuid.UUID) error
+
+	// executions
+	SetExecution(e *execution.Instance) (string, error)
+	GetExecution(id job.ID, instance uuid.UUID) (*execution.Instance, error)
+	GetExecutions(id job.ID) ([]*execution.Instance, error)
+	GetExecutionGroup(e *execution.Instance) ([]*execution.Instance, error)
+	GetGroupedExecutions(id job.ID) (map[int64][]*execution.Instance, []int64, error)
+	GetLastExecutionGroup(id job.ID) ([]*execution.Instance, error)
+	PruneExecutions(id job.ID, r job.Retention, now time.Time) (int, error)
+	DeleteExecutions(id job.ID) error
+	GetInstance(instance uuid.UUID) (*execution.Instance, error)
+	SetHeartbeat(i *execution.Instance, t time.Time) error
+	DeleteHeartbeat(instance uuid.UUID) error
+	RequestCancel(instance uuid.UUID, by string) error
+	GetCancelRequest(instance uuid.UUID) (string, error)
+	DeleteCancelRequest(instance uuid.UUID) error
+	FailLostInstance(i *execution.Instance, cancelledBy string, now time.Time) (bool, error)
+	FailLostInstances(now time.Time) ([]*execution.Instance, error)
+
+	// instance output
+	AppendLog(i *execution.Instance, c *execution.LogChunk) error
+	GetLogs(instance uuid.UUID, stream string, from int) ([]*execution.LogChunk, error)
+	DeleteLogs(instance uuid.UUID) error
+	NewLogWriter(i *execution.Instance, stream string) *LogWriter
+
+	// access control and auditing
+	GetPolicy() (*rbac.Policy, error)
+	SetPolicy(p *rbac.Policy) error
+	AppendAuditEvent(e *audit.Event) error
+	GetAuditEvents(f *audit.Filter, limit int, match func(*audit.Event) bool) ([]*audit.Event, error)
+	PruneAuditEvents(t time.Time) (int, error)
+
+	// schema and consistency
+	GetSchemaVersion() (int, error)
+	Migrate(dryRun bool) (*MigrationReport, error)
+	Fsck(repair bool) (*FsckReport, error)
+
+	// leader election
+	GetLeader() []byte
+	LeaderKey() string
+	Campaign(node string, stop chan struct{}) (<-chan struct{}, func() error, error)
+}
+
+var _ Store = &KVStore{}
@@ -1,7 +1,9 @@
 package storage
 
 import (
+	"math/rand"
 	"strings"
+	"time"
 	"unicode"
 )
 
@@ -24,3 +26,10 @@ type int64arr []int64
 func (a int64arr) Len() int           { return len(a) }
 func (a int64arr) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a int64arr) Less(i, j int) bool { return a[i] < a[j] }
+
+// backoff sleeps a little longer on every attempt, with jitter so racing
+// writers do not retry in lockstep
+func backoff(attempt int) {
+	d := time.Duration(attempt+1) * 10 * time.Millisecond
+	time.Sleep(d + time.Duration(rand.Int63n(int64(d))))
+}
@@ -6,12 +6,14 @@ type Executor string
 var (
 	// DefaultExecutor is the default executor type
 	DefaultExecutor Executor
-	// KubernetesExecutor ...
+	// KubernetesExecutor runs jobs as kubernetes pods
 	KubernetesExecutor Executor = "kubernetes"
-	// MapReduceExecutor ...
+	// MapReduceExecutor runs jobs as mapreduce tasks
 	MapReduceExecutor Executor = "mapreduce"
-	// ShellExecutor ...
+	// ShellExecutor runs jobs as commands on the node that schedules them
 	ShellExecutor Executor = "shell"
-	// MesosExecutor ...
+	// MesosExecutor runs jobs as mesos tasks
 	MesosExecutor Executor = "mesos"
+	// SSHExecutor runs jobs as commands on remote hosts over ssh
+	SSHExecutor Executor = "ssh"
 )